@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -13,10 +14,45 @@ import (
 
 func main() {
 	port := flag.String("port", "6379", "Port to run the Redis server on")
+	bind := flag.String("bind", "", "Addresses to listen on, separated by spaces")
+	dir := flag.String("dir", "", "Directory for the RDB and AOF files")
+	appendonly := flag.String("appendonly", "", "Enable the append-only file (yes/no)")
+	maxmemory := flag.String("maxmemory", "", "Memory limit, e.g. 100mb")
+	loglevel := flag.String("loglevel", "", "Log level (debug, verbose, notice, warning)")
 	config := flag.String("config", "redis.conf", "Configuration file path")
 	flag.Parse()
 
-	srv := server.NewServer(*config)
+	// Only flags given on the command line override the configuration file
+	values := map[string]string{
+		"port":       *port,
+		"bind":       *bind,
+		"dir":        *dir,
+		"appendonly": *appendonly,
+		"maxmemory":  *maxmemory,
+		"loglevel":   *loglevel,
+	}
+	overrides := make(map[string]string)
+	configSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			configSet = true
+		} else if value, ok := values[f.Name]; ok {
+			overrides[f.Name] = value
+		}
+	})
+
+	// The default configuration file is optional
+	configPath := *config
+	if !configSet {
+		if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+			configPath = ""
+		}
+	}
+
+	srv, err := server.NewServer(configPath, overrides)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	// Set up graceful shutdown
 	c := make(chan os.Signal, 1)
@@ -25,20 +61,23 @@ func main() {
 	// Start server in a goroutine
 	serverErr := make(chan error, 1)
 	go func() {
-		fmt.Printf("Redis server starting on port %s\n", *port)
-		if err := srv.Start(*port); err != nil {
+		fmt.Println("Redis server starting")
+		if err := srv.Start(); err != nil {
 			serverErr <- err
 		}
 	}()
 
 	// Wait for either shutdown signal or server error
+	status := 0
 	select {
 	case <-c:
 		fmt.Println("\nReceived shutdown signal...")
 	case err := <-serverErr:
 		log.Printf("Server error: %v", err)
+		status = 1
 	}
 
 	fmt.Println("Shutting down Redis server...")
 	srv.Shutdown()
+	os.Exit(status)
 }
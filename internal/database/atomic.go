@@ -0,0 +1,89 @@
+package database
+
+// Read-modify-write operations run their callback under the write lock of
+// the database, so that concurrent updates of a key never lose one another.
+// Callbacks must not call back into the database.
+
+// UpdateFunc computes the new value of a string or a hash field from the
+// current one; exists is false when there is none. Returning an error
+// leaves the value untouched.
+type UpdateFunc func(old string, exists bool) (string, error)
+
+// UpdateString replaces the string at key by the result of fn and reports
+// event to the keyspace notifications. The key keeps its expiration.
+func (db *Database) UpdateString(key, event string, fn UpdateFunc) error {
+	return db.updateString(key, event, func(old string, exists bool) (string, bool, error) {
+		value, err := fn(old, exists)
+		return value, err == nil, err
+	})
+}
+
+// CompareAndSwap stores value at key if it currently holds the string old,
+// keeping its expiration, and reports whether it did.
+func (db *Database) CompareAndSwap(key, old, value string) (bool, error) {
+	swapped := false
+	err := db.updateString(key, "set", func(current string, exists bool) (string, bool, error) {
+		swapped = exists && current == old
+		return value, swapped, nil
+	})
+	return swapped, err
+}
+
+// updateString is UpdateString for callbacks that may decide not to store
+// anything, in which case the key is not even created.
+func (db *Database) updateString(key, event string, fn func(old string, exists bool) (string, bool, error)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, StringType)
+	if err != nil {
+		return err
+	}
+	var old string
+	if val != nil {
+		old = val.StrVal
+	}
+
+	value, store, err := fn(old, val != nil)
+	if err != nil || !store {
+		return err
+	}
+	if val == nil {
+		val = db.addString(key)
+	}
+	db.setStr(val, value)
+	db.modified(key, 1)
+	db.notify(EventString, event, key)
+	return nil
+}
+
+// UpdateField replaces the value of a hash field by the result of fn,
+// creating the hash and the field if needed, and reports event like
+// UpdateString. The field keeps its expiration.
+func (db *Database) UpdateField(key, field, event string, fn UpdateFunc) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, HashType)
+	if err != nil {
+		return err
+	}
+	var old string
+	var exists bool
+	if val != nil {
+		old, exists = val.HashVal.Get(field)
+	}
+
+	value, err := fn(old, exists)
+	if err != nil {
+		return err
+	}
+	if val == nil {
+		val = newHashValue()
+		db.setKey(key, val)
+	}
+	db.setField(val, field, value)
+	db.modified(key, 1)
+	db.notify(EventHash, event, key)
+	return nil
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	stressClients   = 50
+	stressIncrement = 200
+)
+
+// runClients runs fn from n goroutines at once and waits for all of them.
+func runClients(n int, fn func()) {
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for i := 0; i < n; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			start.Wait()
+			fn()
+		}()
+	}
+	start.Done()
+	done.Wait()
+}
+
+func TestConcurrentIncrBy(t *testing.T) {
+	db := NewDatabase(0)
+	db.Set("counter", "0")
+	at := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	db.ExpireAt("counter", at, ExpireAlways)
+
+	runClients(stressClients, func() {
+		for i := 0; i < stressIncrement; i++ {
+			if _, err := db.IncrBy("counter", 1); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+
+	want := strconv.Itoa(stressClients * stressIncrement)
+	if got, _ := db.Get("counter"); got != want {
+		t.Fatalf("counter = %s, want %s", got, want)
+	}
+	if got, _ := db.ExpireTime("counter"); !got.Equal(at) {
+		t.Fatalf("expiration = %v, want %v", got, at)
+	}
+}
+
+func TestConcurrentHIncrBy(t *testing.T) {
+	db := NewDatabase(0)
+
+	runClients(stressClients, func() {
+		for i := 0; i < stressIncrement; i++ {
+			if _, err := db.HIncrBy("hash", "counter", 1); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+
+	n, err := db.HIncrBy("hash", "counter", 0)
+	if err != nil || n != stressClients*stressIncrement {
+		t.Fatalf("counter = %d, %v, want %d", n, err, stressClients*stressIncrement)
+	}
+}
+
+func TestConcurrentAppend(t *testing.T) {
+	db := NewDatabase(0)
+
+	runClients(stressClients, func() {
+		for i := 0; i < stressIncrement; i++ {
+			if _, err := db.Append("log", "x"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+
+	got, _ := db.Get("log")
+	if len(got) != stressClients*stressIncrement {
+		t.Fatalf("length = %d, want %d", len(got), stressClients*stressIncrement)
+	}
+}
+
+// Every client retries its compare-and-swap until it wins, so the counter
+// ends at the total number of increments.
+func TestConcurrentCompareAndSwap(t *testing.T) {
+	db := NewDatabase(0)
+	db.Set("counter", "0")
+
+	runClients(stressClients, func() {
+		for i := 0; i < stressIncrement; i++ {
+			for {
+				old, _ := db.Get("counter")
+				n, _ := strconv.Atoi(old)
+				swapped, err := db.CompareAndSwap("counter", old, strconv.Itoa(n+1))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if swapped {
+					break
+				}
+			}
+		}
+	})
+
+	want := strconv.Itoa(stressClients * stressIncrement)
+	if got, _ := db.Get("counter"); got != want {
+		t.Fatalf("counter = %s, want %s", got, want)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	db := NewDatabase(0)
+
+	if swapped, err := db.CompareAndSwap("key", "", "value"); swapped || err != nil {
+		t.Fatalf("missing key: swapped = %v, %v", swapped, err)
+	}
+	if db.Exists("key") {
+		t.Fatal("failed swap created the key")
+	}
+
+	db.Set("key", "a")
+	if swapped, _ := db.CompareAndSwap("key", "b", "c"); swapped {
+		t.Fatal("swapped a different value")
+	}
+	if swapped, _ := db.CompareAndSwap("key", "a", "c"); !swapped {
+		t.Fatal("did not swap a matching value")
+	}
+	if got, _ := db.Get("key"); got != "c" {
+		t.Fatalf("key = %s, want c", got)
+	}
+
+	db.HSet("hash", []string{"field", "value"})
+	if _, err := db.CompareAndSwap("hash", "", "value"); err != ErrWrongType {
+		t.Fatalf("hash key: err = %v, want %v", err, ErrWrongType)
+	}
+}
@@ -1,15 +1,84 @@
 package database
 
 import (
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Errors returned by typed operations. Their text is the reply sent to the
+// client.
+var (
+	ErrWrongType       = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrNoSuchKey       = errors.New("ERR no such key")
+	ErrIndexOutOfRange = errors.New("ERR index out of range")
+)
+
 type Database struct {
-	data     map[string]*Value
-	expiry   map[string]time.Time
-	mu       sync.RWMutex
-	shutdown chan bool
+	id         int // index of the database, as given to SELECT
+	data       *Dict[*Value]
+	expiry     *Dict[time.Time]
+	mu         sync.RWMutex
+	shutdown   chan bool
+	dirty      int64 // writes since the last successful snapshot
+	usedMemory int64 // estimated size of all keys and values
+
+	// watched holds the version of the keys watched by transactions, see
+	// watch.go
+	watched map[string]*watchedKey
+
+	// notifier receives the keyspace events, see notify.go
+	notifier Notifier
+
+	// ready is told about the lists stored, for blocked clients, see
+	// notify.go
+	ready ReadyFunc
+
+	// fieldTTLKeys holds the hashes having fields with an expiration, for
+	// the expiration manager
+	fieldTTLKeys map[string]struct{}
+
+	// loading is set while the AOF is replayed: nothing expires, so that
+	// commands apply to the keys as they were when they were logged
+	loading bool
+
+	// expiredReads holds the expired keys found by readers, which only hold
+	// the read lock, for readUnlock to remove, see expire.go
+	expiredReadsMu sync.Mutex
+	expiredReads   []string
+	hasExpiredRead atomic.Bool
+
+	hz           atomic.Int32 // active expire cycles per second
+	expire       ExpireStats  // guarded by mu
+	expireCursor uint64       // position of the active expire cycle in expiry
+}
+
+// ExpireCondition is the NX/XX/GT/LT option of the expire commands.
+type ExpireCondition int
+
+const (
+	ExpireAlways ExpireCondition = iota
+	ExpireNX                     // only when there is no expiration
+	ExpireXX                     // only when there is an expiration
+	ExpireGT                     // only when later than the current one
+	ExpireLT                     // only when earlier than the current one
+)
+
+// allows reports whether the condition lets at replace current. A missing
+// expiration counts as infinite for GT and LT.
+func (c ExpireCondition) allows(current time.Time, hasTTL bool, at time.Time) bool {
+	switch c {
+	case ExpireNX:
+		return !hasTTL
+	case ExpireXX:
+		return hasTTL
+	case ExpireGT:
+		return hasTTL && at.After(current)
+	case ExpireLT:
+		return !hasTTL || at.Before(current)
+	}
+	return true
 }
 
 type ValueType string
@@ -19,51 +88,73 @@ const (
 	HashType   ValueType = "hash"
 	ListType   ValueType = "list"
 	SetType    ValueType = "set"
+	ZSetType   ValueType = "zset"
 )
 
 type Value struct {
 	Type     ValueType
 	StrVal   string
-	HashVal  map[string]string
-	ListVal  []string
-	SetVal   map[string]struct{}
+	HashVal  *Dict[string]
+	ListVal  *List
+	SetVal   *Dict[struct{}]
+	ZSetVal  *ZSet
 	ExpireAt *time.Time
+
+	// FieldExpiry holds the expiration of hash fields that have one
+	FieldExpiry map[string]time.Time
+
+	mem    int64 // estimated size of the value, see memory.go
+	access accessInfo
 }
 
-func NewDatabase() *Database {
-	return &Database{
-		data:     make(map[string]*Value),
-		expiry:   make(map[string]time.Time),
-		shutdown: make(chan bool),
+// Entry is a point-in-time copy of a key used by persistence.
+type Entry struct {
+	Key      string
+	Value    *Value
+	ExpireAt time.Time // zero when the key has no expiration
+}
+
+func NewDatabase(id int) *Database {
+	db := &Database{
+		id:           id,
+		data:         NewDict[*Value](),
+		expiry:       NewDict[time.Time](),
+		shutdown:     make(chan bool),
+		fieldTTLKeys: make(map[string]struct{}),
+		watched:      make(map[string]*watchedKey),
 	}
+	db.hz.Store(DefaultHz)
+	return db
+}
+
+// ID returns the index of the database.
+func (db *Database) ID() int {
+	return db.id
 }
 
 func (db *Database) Set(key, value string) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	db.data[key] = &Value{
+	db.setKey(key, &Value{
 		Type:   StringType,
 		StrVal: value,
-	}
-	delete(db.expiry, key)
+	})
+	db.expiry.Delete(key)
+	db.modified(key, 1)
+	db.notify(EventString, "set", key)
 }
 
 func (db *Database) Get(key string) (string, bool) {
 	db.mu.RLock()
-	defer db.mu.RUnlock()
-
-	if db.isExpired(key) {
-		delete(db.data, key)
-		delete(db.expiry, key)
-		return "", false
-	}
+	defer db.readUnlock()
 
-	val, exists := db.data[key]
+	val, exists := db.lookup(key)
 	if !exists || val.Type != StringType {
 		return "", false
 	}
 
+	val.access.touch()
 	return val.StrVal, true
 }
 
@@ -71,10 +162,10 @@ func (db *Database) Del(key string) bool {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	_, exists := db.data[key]
-	if exists {
-		delete(db.data, key)
-		delete(db.expiry, key)
+	if _, exists := db.lookupWrite(key); exists {
+		db.removeKey(key)
+		db.modified(key, 1)
+		db.notify(EventGeneric, "del", key)
 		return true
 	}
 	return false
@@ -82,162 +173,348 @@ func (db *Database) Del(key string) bool {
 
 func (db *Database) Exists(key string) bool {
 	db.mu.RLock()
-	defer db.mu.RUnlock()
+	defer db.readUnlock()
 
-	if db.isExpired(key) {
-		delete(db.data, key)
-		delete(db.expiry, key)
-		return false
+	val, exists := db.lookup(key)
+	if exists {
+		val.access.touch()
 	}
-
-	_, exists := db.data[key]
 	return exists
 }
 
-func (db *Database) Expire(key string, seconds int) bool {
+// ExpireAt sets an absolute expiration time on key if cond allows it. A
+// time in the past removes the key immediately.
+func (db *Database) ExpireAt(key string, at time.Time, cond ExpireCondition) bool {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if _, exists := db.data[key]; !exists {
+	if _, exists := db.lookupWrite(key); !exists {
+		return false
+	}
+	current, hasTTL := db.expiry.Get(key)
+	if !cond.allows(current, hasTTL, at) {
 		return false
 	}
 
-	db.expiry[key] = time.Now().Add(time.Duration(seconds) * time.Second)
+	if db.inPast(at) {
+		db.removeKey(key)
+		db.modified(key, 1)
+		db.notify(EventGeneric, "del", key)
+		return true
+	}
+
+	db.expiry.Set(key, at)
+	db.modified(key, 1)
+	db.notify(EventGeneric, "expire", key)
 	return true
 }
 
-func (db *Database) TTL(key string) int64 {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+// Persist removes the expiration of key and reports whether it had one.
+func (db *Database) Persist(key string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
-	// Vérifier si la clé existe
-	if _, exists := db.data[key]; !exists {
-		return -2 // Key doesn't exist
+	if _, exists := db.lookupWrite(key); !exists {
+		return false
 	}
-
-	// Vérifier si elle a une expiration
-	expiry, hasExpiry := db.expiry[key]
-	if !hasExpiry {
-		return -1 // Key exists but no expiry
+	if _, hasTTL := db.expiry.Get(key); !hasTTL {
+		return false
 	}
+	db.expiry.Delete(key)
+	db.modified(key, 1)
+	db.notify(EventGeneric, "persist", key)
+	return true
+}
 
-	// Calculer le temps restant
-	remaining := expiry.Sub(time.Now()).Seconds()
-	if remaining <= 0 {
-		// La clé a expiré, la supprimer
-		delete(db.data, key)
-		delete(db.expiry, key)
-		return -2 // Key expired
-	}
+// ExpireTime returns the expiration of key, zero when it has none, and
+// whether the key exists.
+func (db *Database) ExpireTime(key string) (time.Time, bool) {
+	db.mu.RLock()
+	defer db.readUnlock()
 
-	return int64(remaining)
+	// Une clé expirée n'existe plus
+	if _, exists := db.lookup(key); !exists {
+		return time.Time{}, false
+	}
+	return db.expireTime(key), true
 }
 
 func (db *Database) Keys() []string {
 	db.mu.RLock()
-	defer db.mu.RUnlock()
+	defer db.readUnlock()
 
-	keys := make([]string, 0, len(db.data))
-	for key := range db.data {
-		if !db.isExpired(key) {
+	keys := make([]string, 0, db.data.Len())
+	db.data.Range(func(key string, _ *Value) bool {
+		if _, exists := db.lookup(key); exists {
 			keys = append(keys, key)
 		}
-	}
+		return true
+	})
 	return keys
 }
 
-func (db *Database) isExpired(key string) bool {
-	expiry, exists := db.expiry[key]
-	if !exists {
-		return false
+// Scan returns the live keys of the buckets visited from cursor and the
+// cursor to continue from, 0 once the whole keyspace was visited. Every key
+// present from the start to the end of the iteration is returned at least
+// once. About count keys are visited per call; with typ set, keys of other
+// types are skipped.
+func (db *Database) Scan(cursor uint64, count int, typ ValueType) (uint64, []string) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	keys := []string{}
+	visited := 0
+	for buckets := 0; buckets < count*10; buckets++ {
+		cursor = db.data.Scan(cursor, func(key string, _ *Value) {
+			visited++
+			val, exists := db.lookup(key)
+			if exists && (typ == "" || val.Type == typ) {
+				keys = append(keys, key)
+			}
+		})
+		if cursor == 0 || visited >= count {
+			break
+		}
 	}
-	return time.Now().After(expiry)
+	return cursor, keys
 }
 
-func (db *Database) StartExpirationManager() {
-	ticker := time.NewTicker(1 * time.Second)
-	go func() {
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				db.cleanupExpired()
-			case <-db.shutdown:
-				return
-			}
+// Size returns the number of keys, including expired keys that have not
+// been removed yet.
+func (db *Database) Size() int {
+	db.mu.RLock()
+	defer db.readUnlock()
+	return db.data.Len()
+}
+
+// ExpiresCount returns the number of keys with an expiration set.
+func (db *Database) ExpiresCount() int {
+	db.mu.RLock()
+	defer db.readUnlock()
+	return db.expiry.Len()
+}
+
+// Dirty returns the number of writes since the last successful snapshot.
+func (db *Database) Dirty() int64 {
+	db.mu.RLock()
+	defer db.readUnlock()
+	return db.dirty
+}
+
+// ClearDirty subtracts the writes covered by a snapshot that was just saved.
+// Writes made while the snapshot was being written remain counted.
+func (db *Database) ClearDirty(saved int64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.dirty -= saved
+	if db.dirty < 0 {
+		db.dirty = 0
+	}
+}
+
+// Snapshot returns a deep copy of every live key taken under a single lock,
+// so the result is a consistent view of the dataset that can be written out
+// while clients keep modifying it.
+func (db *Database) Snapshot() []Entry {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	entries := make([]Entry, 0, db.data.Len())
+	db.data.Range(func(key string, val *Value) bool {
+		if !db.isExpired(key) {
+			entries = append(entries, Entry{
+				Key:      key,
+				Value:    val.clone(),
+				ExpireAt: db.expireTime(key),
+			})
 		}
-	}()
+		return true
+	})
+	return entries
 }
 
-func (db *Database) cleanupExpired() {
+// Restore inserts a fully built value, replacing any existing key. A zero
+// expireAt means the key does not expire.
+func (db *Database) Restore(key string, val *Value, expireAt time.Time) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	now := time.Now()
-	expiredKeys := make([]string, 0)
+	db.setKey(key, val)
+	if expireAt.IsZero() {
+		db.expiry.Delete(key)
+	} else {
+		db.expiry.Set(key, expireAt)
+	}
+	if len(val.FieldExpiry) > 0 {
+		db.expireFields(key, val, time.Now())
+	}
+}
 
-	for key, expiry := range db.expiry {
-		if now.After(expiry) {
-			expiredKeys = append(expiredKeys, key)
+func (v *Value) clone() *Value {
+	c := &Value{
+		Type:   v.Type,
+		StrVal: v.StrVal,
+	}
+	if v.HashVal != nil {
+		c.HashVal = NewDict[string]()
+		v.HashVal.Range(func(field, value string) bool {
+			c.HashVal.Set(field, value)
+			return true
+		})
+	}
+	if v.ListVal != nil {
+		c.ListVal = NewListFrom(v.ListVal.Items())
+	}
+	if v.SetVal != nil {
+		c.SetVal = NewDict[struct{}]()
+		v.SetVal.Range(func(member string, _ struct{}) bool {
+			c.SetVal.Set(member, struct{}{})
+			return true
+		})
+	}
+	if v.FieldExpiry != nil {
+		c.FieldExpiry = make(map[string]time.Time, len(v.FieldExpiry))
+		for field, at := range v.FieldExpiry {
+			c.FieldExpiry[field] = at
+		}
+	}
+	if v.ZSetVal != nil {
+		c.ZSetVal = NewZSet()
+		for _, m := range v.ZSetVal.Members() {
+			c.ZSetVal.Add(m.Member, m.Score)
 		}
 	}
+	return c
+}
 
-	for _, key := range expiredKeys {
-		delete(db.data, key)
-		delete(db.expiry, key)
+// lookup returns the live value stored at key. Expired keys, and hashes
+// whose fields all expired, are reported as missing but left in place,
+// since callers only hold the read lock: readUnlock removes them once it is
+// released.
+func (db *Database) lookup(key string) (*Value, bool) {
+	val, exists := db.data.Get(key)
+	if !exists {
+		return nil, false
+	}
+	if db.isExpired(key) || len(val.FieldExpiry) > 0 && !db.loading && val.liveFields(time.Now()) == 0 {
+		db.expiredRead(key)
+		return nil, false
 	}
+	return val, true
 }
 
-// Hash operations
-func (db *Database) HSet(key, field, value string) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// lookupWrite is lookup for callers holding the write lock: an expired key
+// or expired hash fields are removed on the way.
+func (db *Database) lookupWrite(key string) (*Value, bool) {
+	if db.isExpired(key) {
+		db.expireKey(key)
+		return nil, false
+	}
+	val, exists := db.data.Get(key)
+	if exists && len(val.FieldExpiry) > 0 && !db.loading && db.expireFields(key, val, time.Now()) {
+		return nil, false
+	}
+	return val, exists
+}
 
-	val, exists := db.data[key]
+// lookupType is lookup for operations on a given type. A missing key
+// returns a nil value and no error.
+func (db *Database) lookupType(key string, typ ValueType) (*Value, error) {
+	val, exists := db.lookup(key)
 	if !exists {
-		val = &Value{
-			Type:    HashType,
-			HashVal: make(map[string]string),
-		}
-		db.data[key] = val
-	} else if val.Type != HashType {
-		val.Type = HashType
-		val.HashVal = make(map[string]string)
+		return nil, nil
 	}
+	if val.Type != typ {
+		return nil, ErrWrongType
+	}
+	val.access.touch()
+	return val, nil
+}
 
-	val.HashVal[field] = value
+// lookupWriteType is lookupType for callers holding the write lock.
+func (db *Database) lookupWriteType(key string, typ ValueType) (*Value, error) {
+	val, exists := db.lookupWrite(key)
+	if !exists {
+		return nil, nil
+	}
+	if val.Type != typ {
+		return nil, ErrWrongType
+	}
+	val.access.touch()
+	return val, nil
 }
 
-func (db *Database) HGet(key, field string) (string, bool) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+// setKey stores val at key, replacing any previous value, and updates the
+// memory accounting. The expiration of key is left untouched.
+func (db *Database) setKey(key string, val *Value) {
+	if old, exists := db.data.Get(key); exists {
+		db.usedMemory -= entrySize(key, old)
+	}
+	val.mem = valueSize(val)
+	val.access.init()
+	db.data.Set(key, val)
+	db.usedMemory += entrySize(key, val)
+	if val.Type == ListType && db.ready != nil {
+		db.ready(db.id, key)
+	}
 
-	if db.isExpired(key) {
-		return "", false
+	if len(val.FieldExpiry) > 0 {
+		db.fieldTTLKeys[key] = struct{}{}
+	} else {
+		delete(db.fieldTTLKeys, key)
 	}
+}
 
-	val, exists := db.data[key]
-	if !exists || val.Type != HashType {
-		return "", false
+// modified records that key underwent changes: they count towards the next
+// snapshot, and abort the transactions watching the key.
+func (db *Database) modified(key string, changes int) {
+	if changes == 0 {
+		return
 	}
+	db.dirty += int64(changes)
+	db.touchWatched(key)
+}
 
-	value, exists := val.HashVal[field]
-	return value, exists
+// removeKey deletes key and its expiration.
+func (db *Database) removeKey(key string) {
+	if val, exists := db.data.Delete(key); exists {
+		db.usedMemory -= entrySize(key, val)
+	}
+	db.expiry.Delete(key)
+	delete(db.fieldTTLKeys, key)
+	db.touchWatched(key)
 }
 
-func (db *Database) HDel(key, field string) bool {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// grow records that val changed size by delta bytes.
+func (db *Database) grow(val *Value, delta int64) {
+	val.mem += delta
+	db.usedMemory += delta
+}
 
-	val, exists := db.data[key]
-	if !exists || val.Type != HashType {
+func (db *Database) isExpired(key string) bool {
+	expiry, exists := db.expiry.Get(key)
+	if !exists || db.loading {
 		return false
 	}
+	return time.Now().After(expiry)
+}
 
-	_, exists = val.HashVal[field]
-	if exists {
-		delete(val.HashVal, field)
-		return true
-	}
-	return false
+// expireTime returns the expiration of key, zero when it has none.
+func (db *Database) expireTime(key string) time.Time {
+	at, _ := db.expiry.Get(key)
+	return at
+}
+
+// inPast reports whether an expiration set to at removes the key right
+// away.
+func (db *Database) inPast(at time.Time) bool {
+	return !db.loading && !at.After(time.Now())
+}
+
+// SetLoading marks the start and the end of an AOF replay.
+func (db *Database) SetLoading(loading bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.loading = loading
 }
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand"
+)
+
+var dictSeed = maphash.MakeSeed()
+
+// Dict is a hash table with string keys that, unlike a Go map, exposes its
+// buckets. This gives SCAN-style iteration with a cursor that survives
+// resizes between calls, and cheap random sampling.
+type Dict[V any] struct {
+	table []*dictEntry[V] // length is zero or a power of two
+	used  int
+}
+
+type dictEntry[V any] struct {
+	key   string
+	value V
+	next  *dictEntry[V]
+}
+
+const dictMinSize = 4
+
+func NewDict[V any]() *Dict[V] {
+	return &Dict[V]{}
+}
+
+func (d *Dict[V]) Len() int {
+	return d.used
+}
+
+func (d *Dict[V]) mask() uint64 {
+	return uint64(len(d.table) - 1)
+}
+
+func (d *Dict[V]) find(key string) *dictEntry[V] {
+	if d.used == 0 {
+		return nil
+	}
+	for e := d.table[maphash.String(dictSeed, key)&d.mask()]; e != nil; e = e.next {
+		if e.key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+func (d *Dict[V]) Get(key string) (V, bool) {
+	if e := d.find(key); e != nil {
+		return e.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (d *Dict[V]) Has(key string) bool {
+	return d.find(key) != nil
+}
+
+// Set stores value at key and reports whether the key was added.
+func (d *Dict[V]) Set(key string, value V) bool {
+	if e := d.find(key); e != nil {
+		e.value = value
+		return false
+	}
+	if d.used >= len(d.table) {
+		d.resize(max(dictMinSize, len(d.table)*2))
+	}
+	i := maphash.String(dictSeed, key) & d.mask()
+	d.table[i] = &dictEntry[V]{key: key, value: value, next: d.table[i]}
+	d.used++
+	return true
+}
+
+// Delete removes key and returns its value.
+func (d *Dict[V]) Delete(key string) (V, bool) {
+	var zero V
+	if d.used == 0 {
+		return zero, false
+	}
+	i := maphash.String(dictSeed, key) & d.mask()
+	for p := &d.table[i]; *p != nil; p = &(*p).next {
+		if e := *p; e.key == key {
+			*p = e.next
+			d.used--
+			if len(d.table) > dictMinSize && d.used < len(d.table)/8 {
+				d.resize(len(d.table) / 2)
+			}
+			return e.value, true
+		}
+	}
+	return zero, false
+}
+
+func (d *Dict[V]) resize(size int) {
+	table := make([]*dictEntry[V], size)
+	mask := uint64(size - 1)
+	for _, e := range d.table {
+		for e != nil {
+			next := e.next
+			i := maphash.String(dictSeed, e.key) & mask
+			e.next = table[i]
+			table[i] = e
+			e = next
+		}
+	}
+	d.table = table
+}
+
+// clear removes every entry, unlinking the chains one by one.
+func (d *Dict[V]) clear() {
+	for i, e := range d.table {
+		for e != nil {
+			next := e.next
+			e.next = nil
+			e = next
+		}
+		d.table[i] = nil
+	}
+	d.table = nil
+	d.used = 0
+}
+
+// Range calls fn for every entry until it returns false. The dict must not
+// be modified during the iteration.
+func (d *Dict[V]) Range(fn func(key string, value V) bool) {
+	for _, e := range d.table {
+		for ; e != nil; e = e.next {
+			if !fn(e.key, e.value) {
+				return
+			}
+		}
+	}
+}
+
+// Keys returns all the keys in no particular order.
+func (d *Dict[V]) Keys() []string {
+	keys := make([]string, 0, d.used)
+	d.Range(func(key string, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// RandomKey returns a key picked at random, which is only roughly uniform
+// since long chains are as likely to be picked as short ones.
+func (d *Dict[V]) RandomKey() (string, bool) {
+	if d.used == 0 {
+		return "", false
+	}
+	for {
+		e := d.table[rand.Intn(len(d.table))]
+		if e == nil {
+			continue
+		}
+		n := 0
+		for c := e; c != nil; c = c.next {
+			n++
+		}
+		for i := rand.Intn(n); i > 0; i-- {
+			e = e.next
+		}
+		return e.key, true
+	}
+}
+
+// Scan visits the bucket designated by cursor, calling fn for each of its
+// entries, and returns the cursor of the next bucket; 0 means the iteration
+// is complete. As in Redis the cursor is incremented on its reversed bits, so
+// every key present for the whole iteration is returned at least once even
+// if the table is resized between calls.
+func (d *Dict[V]) Scan(cursor uint64, fn func(key string, value V)) uint64 {
+	if d.used == 0 {
+		return 0
+	}
+	mask := d.mask()
+	for e := d.table[cursor&mask]; e != nil; e = e.next {
+		fn(e.key, e.value)
+	}
+
+	// Set the unmasked bits so that incrementing the reversed cursor
+	// carries into the next significant masked bit.
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
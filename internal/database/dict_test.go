@@ -0,0 +1,106 @@
+package database
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// scanAll iterates d from cursor 0, calling between after each step, and
+// returns how many times each key was seen.
+func scanAll(t *testing.T, d *Dict[int], between func()) map[string]int {
+	t.Helper()
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	for steps := 0; ; steps++ {
+		if steps > 1<<20 {
+			t.Fatal("scan did not complete")
+		}
+		cursor = d.Scan(cursor, func(key string, _ int) { seen[key]++ })
+		if cursor == 0 {
+			return seen
+		}
+		between()
+	}
+}
+
+func TestDictScanWithoutChanges(t *testing.T) {
+	d := NewDict[int]()
+	for i := 0; i < 1000; i++ {
+		d.Set(strconv.Itoa(i), i)
+	}
+	seen := scanAll(t, d, func() {})
+	if len(seen) != 1000 {
+		t.Fatalf("scan returned %d keys, want 1000", len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("key %s returned %d times by a scan without changes", key, n)
+		}
+	}
+}
+
+func TestDictScanAcrossResizes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 20; run++ {
+		d := NewDict[int]()
+		for i := 0; i < 200; i++ {
+			d.Set("stable"+strconv.Itoa(i), i)
+		}
+
+		// Between the steps, add or remove batches of other keys so the
+		// table grows and shrinks several times during the iteration
+		var added []string
+		next := 0
+		seen := scanAll(t, d, func() {
+			switch {
+			case rng.Intn(3) > 0 && len(added) < 20000:
+				for i := 0; i < rng.Intn(2000); i++ {
+					key := "temp" + strconv.Itoa(next)
+					next++
+					d.Set(key, next)
+					added = append(added, key)
+				}
+			default:
+				n := rng.Intn(len(added) + 1)
+				for _, key := range added[len(added)-n:] {
+					d.Delete(key)
+				}
+				added = added[:len(added)-n]
+			}
+		})
+
+		for i := 0; i < 200; i++ {
+			if key := "stable" + strconv.Itoa(i); seen[key] == 0 {
+				t.Fatalf("run %d: %s present for the whole scan was not returned", run, key)
+			}
+		}
+	}
+}
+
+func TestScanTypeFilter(t *testing.T) {
+	db := NewDatabase(0)
+	for i := 0; i < 100; i++ {
+		db.Set("string"+strconv.Itoa(i), "value")
+		db.SAdd("set"+strconv.Itoa(i), []string{"member"})
+	}
+
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	for {
+		var keys []string
+		cursor, keys = db.Scan(cursor, 10, SetType)
+		for _, key := range keys {
+			if typ, _ := db.Type(key); typ != SetType {
+				t.Fatalf("scan for sets returned %s of type %s", key, typ)
+			}
+			seen[key] = true
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 100 {
+		t.Errorf("scan for sets returned %d keys, want 100", len(seen))
+	}
+}
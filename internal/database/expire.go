@@ -0,0 +1,188 @@
+package database
+
+import "time"
+
+// Active expiration, as done by Redis: a few times per second, sample keys
+// having an expiration and delete the expired ones. Sampling is repeated
+// while a large part of the sample had expired, until a time budget is
+// spent, so that memory is reclaimed without scanning the whole keyspace
+// under the lock.
+const (
+	DefaultHz = 10
+	MinHz     = 1
+	MaxHz     = 500
+
+	expireKeysPerLoop      = 20 // keys sampled per round
+	expireAcceptableStale  = 10 // percent of expired keys in a round to stop
+	expireCycleCPUPercent  = 25 // share of each period a cycle may use
+	expireStaleSmoothCoeff = 0.05
+)
+
+// ExpireStats are the expiration counters shown by INFO.
+type ExpireStats struct {
+	ExpiredKeys    int64         // keys removed on access or by the cycle
+	ExpiredFields  int64         // hash fields removed
+	StalePerc      float64       // estimated percentage of expired keys left
+	TimeCapReached int64         // cycles stopped by their time budget
+	Cycles         int64         // completed active expire cycles
+	CycleTime      time.Duration // total time spent in cycles
+	LastCycle      time.Duration // duration of the last cycle
+}
+
+// ExpireStats returns the expiration counters.
+func (db *Database) ExpireStats() ExpireStats {
+	db.mu.RLock()
+	defer db.readUnlock()
+	return db.expire
+}
+
+// ResetExpireStats clears the expiration counters.
+func (db *Database) ResetExpireStats() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.expire = ExpireStats{}
+}
+
+// SetHz sets how many active expire cycles run per second, clamped to
+// [MinHz, MaxHz].
+func (db *Database) SetHz(hz int) {
+	db.hz.Store(int32(min(max(hz, MinHz), MaxHz)))
+}
+
+// expireKey removes a key whose expiration is in the past.
+func (db *Database) expireKey(key string) {
+	db.removeKey(key)
+	db.expire.ExpiredKeys++
+	db.notify(EventExpired, "expired", key)
+}
+
+// expiredRead records that a reader found key expired.
+func (db *Database) expiredRead(key string) {
+	db.expiredReadsMu.Lock()
+	db.expiredReads = append(db.expiredReads, key)
+	db.expiredReadsMu.Unlock()
+	db.hasExpiredRead.Store(true)
+}
+
+// readUnlock releases the read lock, then removes the expired keys readers
+// found, as a write would on access. The expired events are thus sent
+// before the reply of the command reading the key, as in Redis.
+func (db *Database) readUnlock() {
+	db.mu.RUnlock()
+	if !db.hasExpiredRead.Load() {
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.expiredReadsMu.Lock()
+	keys := db.expiredReads
+	db.expiredReads = nil
+	db.hasExpiredRead.Store(false)
+	db.expiredReadsMu.Unlock()
+	// Keys found by several readers, or removed in the meantime, are only
+	// expired once
+	for _, key := range keys {
+		db.lookupWrite(key)
+	}
+}
+
+func (db *Database) StartExpirationManager() {
+	timer := time.NewTimer(time.Second / time.Duration(db.hz.Load()))
+	go func() {
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				period := time.Second / time.Duration(db.hz.Load())
+				db.activeExpireCycle(period * expireCycleCPUPercent / 100)
+				timer.Reset(period)
+			case <-db.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// activeExpireCycle samples keys in rounds until few of them are expired or
+// budget is spent. The lock is released between rounds.
+func (db *Database) activeExpireCycle(budget time.Duration) {
+	start := time.Now()
+	sampled, expired := 0, 0
+	timedOut := false
+	for {
+		db.mu.Lock()
+		n, e := db.expireRound(time.Now())
+		db.mu.Unlock()
+
+		sampled += n
+		expired += e
+		if n == 0 || e*100 <= n*expireAcceptableStale {
+			break
+		}
+		if time.Since(start) > budget {
+			timedOut = true
+			break
+		}
+	}
+	elapsed := time.Since(start)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stats := &db.expire
+	stats.Cycles++
+	stats.CycleTime += elapsed
+	stats.LastCycle = elapsed
+	if timedOut {
+		stats.TimeCapReached++
+	}
+	perc := 0.0
+	if sampled > 0 {
+		perc = float64(expired) * 100 / float64(sampled)
+	}
+	stats.StalePerc = perc*expireStaleSmoothCoeff + stats.StalePerc*(1-expireStaleSmoothCoeff)
+}
+
+// expireRound samples at least expireKeysPerLoop keys with an expiration,
+// walking the buckets of expiry with a cursor kept across rounds so that
+// every key is eventually visited, and as many hashes with field
+// expirations. It removes what expired and returns how many keys and hashes
+// were sampled and how many had expired content.
+func (db *Database) expireRound(now time.Time) (sampled, expired int) {
+	if db.loading {
+		return 0, 0
+	}
+
+	var keys []string
+	for buckets := 0; sampled < expireKeysPerLoop && buckets < expireKeysPerLoop*10; buckets++ {
+		db.expireCursor = db.expiry.Scan(db.expireCursor, func(key string, at time.Time) {
+			sampled++
+			if !now.Before(at) {
+				keys = append(keys, key)
+			}
+		})
+		if db.expireCursor == 0 {
+			break
+		}
+	}
+	for _, key := range keys {
+		db.expireKey(key)
+	}
+	expired = len(keys)
+
+	// Hashes with field expirations are usually few, a plain sample will do
+	n := 0
+	for key := range db.fieldTTLKeys {
+		if n == expireKeysPerLoop {
+			break
+		}
+		n++
+		val, _ := db.data.Get(key)
+		before := val.HashVal.Len()
+		db.expireFields(key, val, now)
+		if val.HashVal.Len() < before {
+			expired++
+		}
+	}
+	return sampled + n, expired
+}
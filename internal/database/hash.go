@@ -0,0 +1,312 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Errors of HINCRBY and HINCRBYFLOAT.
+var (
+	ErrHashNotInteger = errors.New("ERR hash value is not an integer")
+	ErrHashNotFloat   = errors.New("ERR hash value is not a float")
+	ErrOverflow       = errors.New("ERR increment or decrement would overflow")
+	ErrNaNOrInfinity  = errors.New("ERR increment would produce NaN or Infinity")
+)
+
+func newHashValue() *Value {
+	return &Value{Type: HashType, HashVal: NewDict[string]()}
+}
+
+// hashForWrite returns the hash at key, creating it if needed.
+func (db *Database) hashForWrite(key string) (*Value, error) {
+	val, err := db.lookupWriteType(key, HashType)
+	if err != nil {
+		return nil, err
+	}
+	if val == nil {
+		val = newHashValue()
+		db.setKey(key, val)
+	}
+	return val, nil
+}
+
+// setField stores a field and reports whether it is new.
+func (db *Database) setField(val *Value, field, value string) bool {
+	if old, exists := val.HashVal.Get(field); exists {
+		db.grow(val, int64(len(value)-len(old)))
+		val.HashVal.Set(field, value)
+		return false
+	}
+	val.HashVal.Set(field, value)
+	db.grow(val, fieldSize(field, value))
+	return true
+}
+
+// HSet sets the fields given as field, value pairs and returns the number
+// of fields that were added.
+func (db *Database) HSet(key string, pairs []string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.hashForWrite(key)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if db.setField(val, pairs[i], pairs[i+1]) {
+			added++
+		}
+		db.clearFieldTTL(val, pairs[i])
+	}
+	db.modified(key, 1)
+	db.notify(EventHash, "hset", key)
+	return added, nil
+}
+
+// HSetNX sets field only if it does not exist yet.
+func (db *Database) HSetNX(key, field, value string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, HashType)
+	if err != nil {
+		return false, err
+	}
+	if val != nil && val.HashVal.Has(field) {
+		return false, nil
+	}
+	if val == nil {
+		val = newHashValue()
+		db.setKey(key, val)
+	}
+	db.setField(val, field, value)
+	db.modified(key, 1)
+	db.notify(EventHash, "hset", key)
+	return true, nil
+}
+
+func (db *Database) HGet(key, field string) (string, bool, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, HashType)
+	if val == nil || val.fieldExpired(field, time.Now()) {
+		return "", false, err
+	}
+	value, exists := val.HashVal.Get(field)
+	return value, exists, nil
+}
+
+// HMGet returns the values of fields; found tells which exist.
+func (db *Database) HMGet(key string, fields []string) (values []string, found []bool, err error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, HashType)
+	if err != nil {
+		return nil, nil, err
+	}
+	values = make([]string, len(fields))
+	found = make([]bool, len(fields))
+	if val != nil {
+		now := time.Now()
+		for i, field := range fields {
+			if !val.fieldExpired(field, now) {
+				values[i], found[i] = val.HashVal.Get(field)
+			}
+		}
+	}
+	return values, found, nil
+}
+
+// HGetAll returns every field of the hash with its value.
+func (db *Database) HGetAll(key string) (fields, values []string, err error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, HashType)
+	if val == nil {
+		return []string{}, []string{}, err
+	}
+	now := time.Now()
+	fields = make([]string, 0, val.HashVal.Len())
+	values = make([]string, 0, val.HashVal.Len())
+	val.HashVal.Range(func(field, value string) bool {
+		if !val.fieldExpired(field, now) {
+			fields = append(fields, field)
+			values = append(values, value)
+		}
+		return true
+	})
+	return fields, values, nil
+}
+
+func (db *Database) HLen(key string) (int, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, HashType)
+	if val == nil {
+		return 0, err
+	}
+	return val.liveFields(time.Now()), nil
+}
+
+// HDel removes fields and returns how many existed. The key is removed
+// with its last field.
+func (db *Database) HDel(key string, fields []string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, HashType)
+	if val == nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, field := range fields {
+		if db.deleteField(val, field) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		db.notify(EventHash, "hdel", key)
+	}
+	if val.HashVal.Len() == 0 {
+		db.removeKey(key)
+		db.notify(EventGeneric, "del", key)
+	}
+	db.modified(key, deleted)
+	return deleted, nil
+}
+
+// HIncrBy adds incr to the integer stored in field, a missing field
+// counting as 0, and returns the new value.
+func (db *Database) HIncrBy(key, field string, incr int64) (int64, error) {
+	var n int64
+	err := db.UpdateField(key, field, "hincrby", func(old string, exists bool) (string, error) {
+		if exists {
+			var err error
+			if n, err = strconv.ParseInt(old, 10, 64); err != nil {
+				return "", ErrHashNotInteger
+			}
+		}
+		if (incr > 0 && n > math.MaxInt64-incr) || (incr < 0 && n < math.MinInt64-incr) {
+			return "", ErrOverflow
+		}
+		n += incr
+		return strconv.FormatInt(n, 10), nil
+	})
+	return n, err
+}
+
+// HIncrByFloat is HIncrBy for floating point values. It returns the new
+// value as stored.
+func (db *Database) HIncrByFloat(key, field string, incr float64) (string, error) {
+	if math.IsInf(incr, 0) {
+		return "", ErrNaNOrInfinity
+	}
+
+	var value string
+	err := db.UpdateField(key, field, "hincrbyfloat", func(old string, exists bool) (string, error) {
+		var f float64
+		if exists {
+			var err error
+			if f, err = strconv.ParseFloat(old, 64); err != nil || math.IsNaN(f) {
+				return "", ErrHashNotFloat
+			}
+		}
+		f += incr
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", ErrNaNOrInfinity
+		}
+		value = strconv.FormatFloat(f, 'f', -1, 64)
+		return value, nil
+	})
+	return value, err
+}
+
+// HRandField returns random fields with their values, following the count
+// rules of SRANDMEMBER.
+func (db *Database) HRandField(key string, count int) (fields, values []string, err error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, HashType)
+	if val == nil {
+		return nil, nil, err
+	}
+
+	hash := val.HashVal
+	if len(val.FieldExpiry) > 0 {
+		// Sampling could return expired fields: work on a copy of the
+		// live ones
+		hash = NewDict[string]()
+		now := time.Now()
+		val.HashVal.Range(func(field, value string) bool {
+			if !val.fieldExpired(field, now) {
+				hash.Set(field, value)
+			}
+			return true
+		})
+	}
+	pick := func(field string) {
+		value, _ := hash.Get(field)
+		fields = append(fields, field)
+		values = append(values, value)
+	}
+
+	switch {
+	case count < 0:
+		for i := 0; i < -count; i++ {
+			field, _ := hash.RandomKey()
+			pick(field)
+		}
+	case count*3 > hash.Len():
+		all := hash.Keys()
+		rand.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+		for _, field := range all[:min(count, len(all))] {
+			pick(field)
+		}
+	default:
+		picked := make(map[string]struct{}, count)
+		for len(fields) < count {
+			field, _ := hash.RandomKey()
+			if _, ok := picked[field]; !ok {
+				picked[field] = struct{}{}
+				pick(field)
+			}
+		}
+	}
+	return fields, values, nil
+}
+
+// HScan is SScan for hashes.
+func (db *Database) HScan(key string, cursor uint64, count int) (next uint64, fields, values []string, err error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, HashType)
+	if val == nil {
+		return 0, nil, nil, err
+	}
+
+	now := time.Now()
+	for visited := 0; visited < count*10; visited++ {
+		cursor = val.HashVal.Scan(cursor, func(field, value string) {
+			if !val.fieldExpired(field, now) {
+				fields = append(fields, field)
+				values = append(values, value)
+			}
+		})
+		if cursor == 0 || len(fields) >= count {
+			break
+		}
+	}
+	return cursor, fields, values, nil
+}
@@ -0,0 +1,190 @@
+package database
+
+import "time"
+
+// Results of HEXPIRE and HPERSIST for each field.
+const (
+	FieldMissing    = -2
+	FieldNoTTL      = -1
+	FieldNotSet     = 0 // the condition was not met
+	FieldTTLSet     = 1
+	FieldTTLDeleted = 2 // the time was in the past: the field was deleted
+)
+
+// liveFields returns the number of fields of a hash that have not expired.
+func (v *Value) liveFields(now time.Time) int {
+	n := v.HashVal.Len()
+	for _, at := range v.FieldExpiry {
+		if !now.Before(at) {
+			n--
+		}
+	}
+	return n
+}
+
+// fieldExpired reports whether field has an expiration in the past.
+func (v *Value) fieldExpired(field string, now time.Time) bool {
+	at, ok := v.FieldExpiry[field]
+	return ok && !now.Before(at)
+}
+
+// deleteField removes a field and its expiration.
+func (db *Database) deleteField(val *Value, field string) bool {
+	value, exists := val.HashVal.Delete(field)
+	if !exists {
+		return false
+	}
+	db.grow(val, -fieldSize(field, value))
+	db.clearFieldTTL(val, field)
+	return true
+}
+
+func (db *Database) clearFieldTTL(val *Value, field string) bool {
+	if _, ok := val.FieldExpiry[field]; !ok {
+		return false
+	}
+	delete(val.FieldExpiry, field)
+	db.grow(val, -fieldTTLSize(field))
+	return true
+}
+
+// expireFields removes the expired fields of the hash at key, and the key
+// itself when no field is left, which it reports. Like HDEL, this counts
+// towards the next snapshot and aborts the transactions watching the key.
+func (db *Database) expireFields(key string, val *Value, now time.Time) bool {
+	expired := 0
+	for field, at := range val.FieldExpiry {
+		if !now.Before(at) {
+			db.deleteField(val, field)
+			db.expire.ExpiredFields++
+			expired++
+		}
+	}
+	if expired > 0 {
+		db.notify(EventHash, "hexpired", key)
+	}
+	db.modified(key, expired)
+	if len(val.FieldExpiry) == 0 {
+		delete(db.fieldTTLKeys, key)
+	}
+	if val.HashVal.Len() == 0 {
+		db.removeKey(key)
+		db.notify(EventGeneric, "del", key)
+		return true
+	}
+	return false
+}
+
+// HExpire sets the expiration of hash fields to at, subject to cond, and
+// returns one of the Field* results for each field. A time in the past
+// deletes the fields.
+func (db *Database) HExpire(key string, at time.Time, cond ExpireCondition, fields []string) ([]int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	results := make([]int, len(fields))
+	val, err := db.lookupWriteType(key, HashType)
+	if val == nil {
+		for i := range results {
+			results[i] = FieldMissing
+		}
+		return results, err
+	}
+
+	past := db.inPast(at)
+	changed := false
+	for i, field := range fields {
+		if !val.HashVal.Has(field) {
+			results[i] = FieldMissing
+			continue
+		}
+		current, hasTTL := val.FieldExpiry[field]
+		if !cond.allows(current, hasTTL, at) {
+			results[i] = FieldNotSet
+			continue
+		}
+		if past {
+			db.deleteField(val, field)
+			results[i] = FieldTTLDeleted
+		} else {
+			if val.FieldExpiry == nil {
+				val.FieldExpiry = make(map[string]time.Time)
+			}
+			if !hasTTL {
+				db.grow(val, fieldTTLSize(field))
+			}
+			val.FieldExpiry[field] = at
+			db.fieldTTLKeys[key] = struct{}{}
+			results[i] = FieldTTLSet
+		}
+		db.modified(key, 1)
+		changed = true
+	}
+
+	switch {
+	case !changed:
+	case past:
+		db.notify(EventHash, "hdel", key)
+	default:
+		db.notify(EventHash, "hexpire", key)
+	}
+	if val.HashVal.Len() == 0 {
+		db.removeKey(key)
+		db.notify(EventGeneric, "del", key)
+	}
+	return results, nil
+}
+
+// HPersist removes the expiration of hash fields and returns FieldTTLSet
+// for each field whose expiration was removed.
+func (db *Database) HPersist(key string, fields []string) ([]int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	results := make([]int, len(fields))
+	val, err := db.lookupWriteType(key, HashType)
+	for i, field := range fields {
+		switch {
+		case val == nil || !val.HashVal.Has(field):
+			results[i] = FieldMissing
+		case db.clearFieldTTL(val, field):
+			results[i] = FieldTTLSet
+			db.modified(key, 1)
+		default:
+			results[i] = FieldNoTTL
+		}
+	}
+	for _, result := range results {
+		if result == FieldTTLSet {
+			db.notify(EventHash, "hpersist", key)
+			break
+		}
+	}
+	if val != nil && len(val.FieldExpiry) == 0 {
+		delete(db.fieldTTLKeys, key)
+	}
+	return results, err
+}
+
+// HFieldExpiry returns the expiration of each field, the zero time when it
+// has none; exists tells which fields exist.
+func (db *Database) HFieldExpiry(key string, fields []string) (expiry []time.Time, exists []bool, err error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, HashType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	expiry = make([]time.Time, len(fields))
+	exists = make([]bool, len(fields))
+	if val != nil {
+		for i, field := range fields {
+			exists[i] = val.HashVal.Has(field) && !val.fieldExpired(field, now)
+			expiry[i] = val.FieldExpiry[field]
+		}
+	}
+	return expiry, exists, nil
+}
@@ -0,0 +1,219 @@
+package database
+
+import "time"
+
+// Flush removes every key of the database. The tables are replaced by empty
+// ones and the old ones are left to the garbage collector, so flushing
+// holds the lock for a constant time whatever the size of the database.
+func (db *Database) Flush() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.dirty += int64(db.data.Len())
+	db.touchAllWatched()
+	db.data = NewDict[*Value]()
+	db.expiry = NewDict[time.Time]()
+	db.fieldTTLKeys = make(map[string]struct{})
+	db.usedMemory = 0
+	db.expireCursor = 0
+}
+
+// Move moves key, with its expiration, to dst. It fails when the key does
+// not exist or when dst already holds it.
+func (db *Database) Move(key string, dst *Database) bool {
+	unlock := lockPair(db, dst)
+	defer unlock()
+
+	val, exists := db.lookupWrite(key)
+	if !exists {
+		return false
+	}
+	if _, exists := dst.lookupWrite(key); exists {
+		return false
+	}
+
+	at := db.expireTime(key)
+	db.removeKey(key)
+	dst.setKey(key, val)
+	if !at.IsZero() {
+		dst.expiry.Set(key, at)
+	}
+	db.modified(key, 1)
+	dst.modified(key, 1)
+	db.notify(EventGeneric, "move_from", key)
+	dst.notify(EventGeneric, "move_to", key)
+	return true
+}
+
+// Swap exchanges the contents of db and other, so that clients using one
+// of them see the keys of the other from now on.
+func (db *Database) Swap(other *Database) {
+	unlock := lockPair(db, other)
+	defer unlock()
+
+	if db == other {
+		return
+	}
+	db.touchAllWatched()
+	other.touchAllWatched()
+	db.data, other.data = other.data, db.data
+	db.expiry, other.expiry = other.expiry, db.expiry
+	db.fieldTTLKeys, other.fieldTTLKeys = other.fieldTTLKeys, db.fieldTTLKeys
+	db.usedMemory, other.usedMemory = other.usedMemory, db.usedMemory
+	db.expireCursor, other.expireCursor = other.expireCursor, db.expireCursor
+	db.touchAllWatched()
+	other.touchAllWatched()
+	db.dirty++
+	other.dirty++
+}
+
+// lockPair takes the write lock of two databases, always in the order of
+// their ids so that concurrent callers cannot deadlock, and returns the
+// function releasing them.
+func lockPair(a, b *Database) func() {
+	if a == b {
+		a.mu.Lock()
+		return a.mu.Unlock
+	}
+	if a.id > b.id {
+		a, b = b, a
+	}
+	a.mu.Lock()
+	b.mu.Lock()
+	return func() {
+		b.mu.Unlock()
+		a.mu.Unlock()
+	}
+}
+
+// Type returns the type of the value stored at key, and false when the key
+// does not exist.
+func (db *Database) Type(key string) (ValueType, bool) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, exists := db.lookup(key)
+	if !exists {
+		return "", false
+	}
+	return val.Type, true
+}
+
+// Touch updates the access time of the existing keys among keys and returns
+// how many there are, counting repeated keys each time.
+func (db *Database) Touch(keys []string) int {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	n := 0
+	for _, key := range keys {
+		if val, exists := db.lookup(key); exists {
+			val.access.touch()
+			n++
+		}
+	}
+	return n
+}
+
+// Unlink deletes keys like Del but releases large values in the background,
+// see lazyfree.go. It returns the number of keys deleted.
+func (db *Database) Unlink(keys []string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	n := 0
+	for _, key := range keys {
+		val, exists := db.lookupWrite(key)
+		if !exists {
+			continue
+		}
+		db.removeKey(key)
+		db.modified(key, 1)
+		db.notify(EventGeneric, "del", key)
+		freeValue(val)
+		n++
+	}
+	return n
+}
+
+// randomKeyTries bounds the expired keys RandomKey removes in one call.
+const randomKeyTries = 100
+
+// RandomKey returns a key picked at random. Expired keys drawn on the way
+// are removed; as in Redis, when too many of them are drawn in a row the
+// last one is returned anyway, rather than holding the lock for long.
+func (db *Database) RandomKey() (string, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for tries := 1; ; tries++ {
+		key, ok := db.data.RandomKey()
+		if !ok {
+			return "", false
+		}
+		if _, exists := db.lookup(key); exists || tries == randomKeyTries {
+			return key, true
+		}
+		db.expireKey(key)
+	}
+}
+
+// Rename renames src to dst, replacing dst unless nx is set. The key keeps
+// its expiration, if any. It returns ErrNoSuchKey when src does not exist,
+// and false when nx prevented the rename.
+func (db *Database) Rename(src, dst string, nx bool) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, exists := db.lookupWrite(src)
+	if !exists {
+		return false, ErrNoSuchKey
+	}
+	if src == dst {
+		return !nx, nil
+	}
+	if _, exists := db.lookupWrite(dst); exists && nx {
+		return false, nil
+	}
+
+	at := db.expireTime(src)
+	db.removeKey(src)
+	db.setKey(dst, val)
+	if at.IsZero() {
+		db.expiry.Delete(dst)
+	} else {
+		db.expiry.Set(dst, at)
+	}
+	db.modified(src, 1)
+	db.modified(dst, 1)
+	db.notify(EventGeneric, "rename_from", src)
+	db.notify(EventGeneric, "rename_to", dst)
+	return true, nil
+}
+
+// Copy copies the value and the expiration of src to the key dst of
+// dstDB, which may be db itself. An existing dst is only overwritten with
+// replace. It reports whether the key was copied.
+func (db *Database) Copy(src string, dstDB *Database, dst string, replace bool) bool {
+	unlock := lockPair(db, dstDB)
+	defer unlock()
+
+	val, exists := db.lookupWrite(src)
+	if !exists {
+		return false
+	}
+	if _, exists := dstDB.lookupWrite(dst); exists && !replace {
+		return false
+	}
+
+	at := db.expireTime(src)
+	dstDB.setKey(dst, val.clone())
+	if at.IsZero() {
+		dstDB.expiry.Delete(dst)
+	} else {
+		dstDB.expiry.Set(dst, at)
+	}
+	dstDB.modified(dst, 1)
+	dstDB.notify(EventGeneric, "copy_to", dst)
+	return true
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// UNLINK removes keys right away but, as Redis does, leaves the release of
+// values having more than lazyFreeThreshold elements to a background
+// goroutine, so that deleting a large value never delays the commands
+// waiting for the lock.
+const (
+	lazyFreeThreshold = 64
+	lazyFreeQueueLen  = 1024
+)
+
+var lazyFree struct {
+	once    sync.Once
+	queue   chan *Value
+	pending atomic.Int64 // values queued and not released yet
+	freed   atomic.Int64 // values released by the goroutine
+}
+
+// LazyFreeStats returns the number of values waiting to be released in the
+// background and the number released so far.
+func LazyFreeStats() (pending, freed int64) {
+	return lazyFree.pending.Load(), lazyFree.freed.Load()
+}
+
+// freeValue releases a value removed from the keyspace, from the background
+// goroutine when it is large. When the queue is full the value is simply
+// dropped.
+func freeValue(val *Value) {
+	if val.elements() <= lazyFreeThreshold {
+		return
+	}
+	lazyFree.once.Do(func() {
+		lazyFree.queue = make(chan *Value, lazyFreeQueueLen)
+		go func() {
+			for val := range lazyFree.queue {
+				val.release()
+				lazyFree.pending.Add(-1)
+				lazyFree.freed.Add(1)
+			}
+		}()
+	})
+
+	lazyFree.pending.Add(1)
+	select {
+	case lazyFree.queue <- val:
+	default:
+		lazyFree.pending.Add(-1)
+	}
+}
+
+// elements returns the number of elements held by a value, 1 for strings.
+func (v *Value) elements() int {
+	switch v.Type {
+	case HashType:
+		return v.HashVal.Len()
+	case ListType:
+		return v.ListVal.Len()
+	case SetType:
+		return v.SetVal.Len()
+	case ZSetType:
+		return v.ZSetVal.Len()
+	}
+	return 1
+}
+
+// release drops everything v holds. It must only be called once v is no
+// longer reachable from the keyspace.
+func (v *Value) release() {
+	if v.HashVal != nil {
+		v.HashVal.clear()
+	}
+	if v.SetVal != nil {
+		v.SetVal.clear()
+	}
+	*v = Value{}
+}
@@ -0,0 +1,84 @@
+package database
+
+// LCSMatch is a run of the longest common subsequence found in both
+// strings, as inclusive byte ranges.
+type LCSMatch struct {
+	AStart, AEnd int
+	BStart, BEnd int
+}
+
+func (m LCSMatch) Len() int {
+	return m.AEnd - m.AStart + 1
+}
+
+// LCS returns the longest common subsequence of the strings at key1 and
+// key2, and its runs from the end of the strings to the start. Missing keys
+// are handled as empty strings.
+func (db *Database) LCS(key1, key2 string) (string, []LCSMatch, error) {
+	db.mu.RLock()
+	var values [2]string
+	for i, key := range []string{key1, key2} {
+		val, exists := db.lookup(key)
+		if !exists {
+			continue
+		}
+		if val.Type != StringType {
+			db.readUnlock()
+			return "", nil, ErrLCSNotString
+		}
+		val.access.touch()
+		values[i] = val.StrVal
+	}
+	db.readUnlock()
+
+	return lcs(values[0], values[1])
+}
+
+// lcs runs the dynamic programming algorithm: table[i][j] is the length of
+// the LCS of a[:i] and b[:j].
+func lcs(a, b string) (string, []LCSMatch, error) {
+	width := len(b) + 1
+	if uint64(len(a)+1)*uint64(width) > MaxStringLength/4 {
+		return "", nil, ErrLCSTooLarge
+	}
+	table := make([]uint32, (len(a)+1)*width)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			switch {
+			case a[i-1] == b[j-1]:
+				table[i*width+j] = table[(i-1)*width+j-1] + 1
+			case table[(i-1)*width+j] > table[i*width+j-1]:
+				table[i*width+j] = table[(i-1)*width+j]
+			default:
+				table[i*width+j] = table[i*width+j-1]
+			}
+		}
+	}
+
+	// Walk back from the end, collecting the runs of matching bytes
+	seq := make([]byte, table[len(table)-1])
+	var matches []LCSMatch
+	run := -1 // index of the run being extended, if any
+	k := len(seq)
+	for i, j := len(a), len(b); i > 0 && j > 0; {
+		if a[i-1] == b[j-1] {
+			k--
+			seq[k] = a[i-1]
+			i, j = i-1, j-1
+			if run < 0 {
+				run = len(matches)
+				matches = append(matches, LCSMatch{AStart: i, AEnd: i, BStart: j, BEnd: j})
+			} else {
+				matches[run].AStart, matches[run].BStart = i, j
+			}
+			continue
+		}
+		run = -1
+		if table[(i-1)*width+j] > table[i*width+j-1] {
+			i--
+		} else {
+			j--
+		}
+	}
+	return string(seq), matches, nil
+}
@@ -0,0 +1,548 @@
+package database
+
+// List is a double-ended queue of strings backed by a ring buffer, so that
+// pushing and popping at either end is O(1) and indexing is O(1).
+type List struct {
+	items []string
+	head  int // index of the first element in items
+	size  int
+}
+
+func NewList() *List {
+	return &List{}
+}
+
+// NewListFrom builds a list holding items, in order.
+func NewListFrom(items []string) *List {
+	l := &List{}
+	l.reset(items)
+	return l
+}
+
+func (l *List) Len() int {
+	return l.size
+}
+
+// at converts a position in the list to an index in the ring buffer.
+func (l *List) at(i int) int {
+	return (l.head + i) & (len(l.items) - 1)
+}
+
+// grow doubles the ring buffer when it is full. Its length is always a power
+// of two so that at can use a mask.
+func (l *List) grow() {
+	if l.size < len(l.items) {
+		return
+	}
+	capacity := len(l.items) * 2
+	if capacity == 0 {
+		capacity = 8
+	}
+	items := make([]string, capacity)
+	l.copyTo(items)
+	l.items = items
+	l.head = 0
+}
+
+// shrink halves the ring buffer when it is mostly empty.
+func (l *List) shrink() {
+	if len(l.items) <= 8 || l.size > len(l.items)/4 {
+		return
+	}
+	items := make([]string, len(l.items)/2)
+	l.copyTo(items)
+	l.items = items
+	l.head = 0
+}
+
+func (l *List) copyTo(dst []string) {
+	if l.size == 0 {
+		return
+	}
+	end := l.head + l.size
+	if end <= len(l.items) {
+		copy(dst, l.items[l.head:end])
+		return
+	}
+	n := copy(dst, l.items[l.head:])
+	copy(dst[n:], l.items[:end-len(l.items)])
+}
+
+func (l *List) reset(items []string) {
+	capacity := 8
+	for capacity < len(items) {
+		capacity *= 2
+	}
+	l.items = make([]string, capacity)
+	copy(l.items, items)
+	l.head = 0
+	l.size = len(items)
+}
+
+func (l *List) PushFront(item string) {
+	l.grow()
+	l.head = (l.head - 1) & (len(l.items) - 1)
+	l.items[l.head] = item
+	l.size++
+}
+
+func (l *List) PushBack(item string) {
+	l.grow()
+	l.items[l.at(l.size)] = item
+	l.size++
+}
+
+func (l *List) PopFront() (string, bool) {
+	if l.size == 0 {
+		return "", false
+	}
+	item := l.items[l.head]
+	l.items[l.head] = ""
+	l.head = l.at(1)
+	l.size--
+	l.shrink()
+	return item, true
+}
+
+func (l *List) PopBack() (string, bool) {
+	if l.size == 0 {
+		return "", false
+	}
+	i := l.at(l.size - 1)
+	item := l.items[i]
+	l.items[i] = ""
+	l.size--
+	l.shrink()
+	return item, true
+}
+
+// Index returns the element at position i, which must be in [0, Len).
+func (l *List) Index(i int) string {
+	return l.items[l.at(i)]
+}
+
+// Set replaces the element at position i, which must be in [0, Len).
+func (l *List) Set(i int, item string) {
+	l.items[l.at(i)] = item
+}
+
+// Range returns the elements from start to stop inclusive. Both must be
+// valid positions.
+func (l *List) Range(start, stop int) []string {
+	if start > stop {
+		return []string{}
+	}
+	items := make([]string, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		items = append(items, l.Index(i))
+	}
+	return items
+}
+
+// Items returns a copy of all the elements.
+func (l *List) Items() []string {
+	items := make([]string, l.size)
+	l.copyTo(items)
+	return items
+}
+
+// Insert adds item before position i, shifting the following elements.
+func (l *List) Insert(i int, item string) {
+	items := l.Items()
+	items = append(items[:i], append([]string{item}, items[i:]...)...)
+	l.reset(items)
+}
+
+// Filter keeps only the elements for which keep returns true.
+func (l *List) Filter(keep func(i int, item string) bool) {
+	items := l.Items()
+	kept := items[:0]
+	for i, item := range items {
+		if keep(i, item) {
+			kept = append(kept, item)
+		}
+	}
+	l.reset(kept)
+}
+
+// List operations
+
+// listRange converts Redis start and stop indexes, where negative values
+// count from the end, to positions in a list of the given size. ok is false
+// when the range is empty.
+func listRange(start, stop, size int) (int, int, bool) {
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	return start, stop, start <= stop
+}
+
+// Push adds items at the head (left) or the tail of the list at key and
+// returns its new length. The list is created unless onlyIfExists is set, in
+// which case a missing key is left alone and 0 is returned.
+func (db *Database) Push(key string, items []string, left, onlyIfExists bool) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, ListType)
+	if err != nil {
+		return 0, err
+	}
+	if val == nil {
+		if onlyIfExists {
+			return 0, nil
+		}
+		val = &Value{Type: ListType, ListVal: NewList()}
+		db.setKey(key, val)
+	}
+
+	for _, item := range items {
+		if left {
+			val.ListVal.PushFront(item)
+		} else {
+			val.ListVal.PushBack(item)
+		}
+		db.grow(val, itemSize(item))
+	}
+	db.modified(key, len(items))
+	db.notify(EventList, listEvent("push", left), key)
+	return val.ListVal.Len(), nil
+}
+
+// listEvent returns the keyspace event of a push or a pop at the head (left)
+// or the tail of a list.
+func listEvent(op string, left bool) string {
+	if left {
+		return "l" + op
+	}
+	return "r" + op
+}
+
+// Pop removes and returns up to count items from the head (left) or the tail
+// of the list at key. It returns nil when the key does not exist.
+func (db *Database) Pop(key string, left bool, count int) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, ListType)
+	if val == nil {
+		return nil, err
+	}
+	return db.popList(key, val, left, count), nil
+}
+
+// popList pops up to count items and removes the key once the list is empty.
+func (db *Database) popList(key string, val *Value, left bool, count int) []string {
+	items := make([]string, 0, min(count, val.ListVal.Len()))
+	for len(items) < count {
+		var item string
+		var ok bool
+		if left {
+			item, ok = val.ListVal.PopFront()
+		} else {
+			item, ok = val.ListVal.PopBack()
+		}
+		if !ok {
+			break
+		}
+		db.grow(val, -itemSize(item))
+		items = append(items, item)
+	}
+
+	if len(items) > 0 {
+		db.notify(EventList, listEvent("pop", left), key)
+	}
+	if val.ListVal.Len() == 0 {
+		db.removeKey(key)
+		db.notify(EventGeneric, "del", key)
+	}
+	db.modified(key, len(items))
+	return items
+}
+
+func (db *Database) LLen(key string) (int, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, ListType)
+	if val == nil {
+		return 0, err
+	}
+	return val.ListVal.Len(), nil
+}
+
+// LRange returns the items between start and stop inclusive.
+func (db *Database) LRange(key string, start, stop int) ([]string, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, ListType)
+	if val == nil {
+		return []string{}, err
+	}
+	start, stop, ok := listRange(start, stop, val.ListVal.Len())
+	if !ok {
+		return []string{}, nil
+	}
+	return val.ListVal.Range(start, stop), nil
+}
+
+// LIndex returns the item at index, negative indexes counting from the tail.
+func (db *Database) LIndex(key string, index int) (string, bool, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, ListType)
+	if val == nil {
+		return "", false, err
+	}
+	if index < 0 {
+		index += val.ListVal.Len()
+	}
+	if index < 0 || index >= val.ListVal.Len() {
+		return "", false, nil
+	}
+	return val.ListVal.Index(index), true, nil
+}
+
+func (db *Database) LSet(key string, index int, item string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, ListType)
+	if err != nil {
+		return err
+	}
+	if val == nil {
+		return ErrNoSuchKey
+	}
+	if index < 0 {
+		index += val.ListVal.Len()
+	}
+	if index < 0 || index >= val.ListVal.Len() {
+		return ErrIndexOutOfRange
+	}
+
+	db.grow(val, int64(len(item)-len(val.ListVal.Index(index))))
+	val.ListVal.Set(index, item)
+	db.modified(key, 1)
+	db.notify(EventList, "lset", key)
+	return nil
+}
+
+// LInsert inserts item before or after the first occurrence of pivot. It
+// returns the new length, -1 when pivot is not found and 0 when the key does
+// not exist.
+func (db *Database) LInsert(key string, before bool, pivot, item string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, ListType)
+	if val == nil {
+		return 0, err
+	}
+
+	list := val.ListVal
+	for i := 0; i < list.Len(); i++ {
+		if list.Index(i) != pivot {
+			continue
+		}
+		if !before {
+			i++
+		}
+		list.Insert(i, item)
+		db.grow(val, itemSize(item))
+		db.modified(key, 1)
+		db.notify(EventList, "linsert", key)
+		return list.Len(), nil
+	}
+	return -1, nil
+}
+
+// LRem removes occurrences of item: the first count from the head when count
+// is positive, the last -count from the tail when negative, all of them when
+// zero. It returns the number of removed items.
+func (db *Database) LRem(key string, count int, item string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, ListType)
+	if val == nil {
+		return 0, err
+	}
+
+	list := val.ListVal
+	remove := make(map[int]bool)
+	if count >= 0 {
+		for i := 0; i < list.Len() && (count == 0 || len(remove) < count); i++ {
+			if list.Index(i) == item {
+				remove[i] = true
+			}
+		}
+	} else {
+		for i := list.Len() - 1; i >= 0 && len(remove) < -count; i-- {
+			if list.Index(i) == item {
+				remove[i] = true
+			}
+		}
+	}
+	if len(remove) == 0 {
+		return 0, nil
+	}
+
+	list.Filter(func(i int, _ string) bool { return !remove[i] })
+	db.grow(val, -int64(len(remove))*itemSize(item))
+	db.notify(EventList, "lrem", key)
+	if list.Len() == 0 {
+		db.removeKey(key)
+		db.notify(EventGeneric, "del", key)
+	}
+	db.modified(key, len(remove))
+	return len(remove), nil
+}
+
+// LTrim keeps only the items between start and stop inclusive.
+func (db *Database) LTrim(key string, start, stop int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, ListType)
+	if val == nil {
+		return err
+	}
+
+	list := val.ListVal
+	start, stop, ok := listRange(start, stop, list.Len())
+	if !ok {
+		db.removeKey(key)
+		db.modified(key, 1)
+		db.notify(EventList, "ltrim", key)
+		db.notify(EventGeneric, "del", key)
+		return nil
+	}
+
+	for removed := list.Len() - 1 - stop; removed > 0; removed-- {
+		item, _ := list.PopBack()
+		db.grow(val, -itemSize(item))
+	}
+	for removed := start; removed > 0; removed-- {
+		item, _ := list.PopFront()
+		db.grow(val, -itemSize(item))
+	}
+	db.modified(key, 1)
+	db.notify(EventList, "ltrim", key)
+	return nil
+}
+
+// LPos returns the positions of item in the list. rank selects the first
+// match to return, negative ranks searching from the tail; count limits the
+// number of positions (0 for all) and maxlen the number of items compared
+// (0 for the whole list).
+func (db *Database) LPos(key, item string, rank, count, maxlen int) ([]int, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, ListType)
+	if val == nil {
+		return nil, err
+	}
+
+	list := val.ListVal
+	step, i := 1, 0
+	if rank < 0 {
+		step, i = -1, list.Len()-1
+		rank = -rank
+	}
+
+	var positions []int
+	for compared := 0; i >= 0 && i < list.Len(); i += step {
+		if maxlen > 0 && compared >= maxlen {
+			break
+		}
+		compared++
+		if list.Index(i) != item {
+			continue
+		}
+		if rank > 1 {
+			rank--
+			continue
+		}
+		positions = append(positions, i)
+		if count > 0 && len(positions) == count {
+			break
+		}
+	}
+	return positions, nil
+}
+
+// LMove atomically pops an item from one end of src and pushes it to one
+// end of dst. It returns false when src does not exist.
+func (db *Database) LMove(src, dst string, fromLeft, toLeft bool) (string, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	srcVal, err := db.lookupWriteType(src, ListType)
+	if srcVal == nil {
+		return "", false, err
+	}
+	dstVal, err := db.lookupWriteType(dst, ListType)
+	if err != nil {
+		return "", false, err
+	}
+
+	var item string
+	if fromLeft {
+		item, _ = srcVal.ListVal.PopFront()
+	} else {
+		item, _ = srcVal.ListVal.PopBack()
+	}
+	db.grow(srcVal, -itemSize(item))
+
+	if dstVal == nil {
+		dstVal = &Value{Type: ListType, ListVal: NewList()}
+		db.setKey(dst, dstVal)
+	}
+	if toLeft {
+		dstVal.ListVal.PushFront(item)
+	} else {
+		dstVal.ListVal.PushBack(item)
+	}
+	db.grow(dstVal, itemSize(item))
+
+	db.notify(EventList, listEvent("pop", fromLeft), src)
+	db.notify(EventList, listEvent("push", toLeft), dst)
+	if srcVal.ListVal.Len() == 0 {
+		db.removeKey(src)
+		db.notify(EventGeneric, "del", src)
+	}
+	db.modified(src, 1)
+	db.modified(dst, 1)
+	return item, true, nil
+}
+
+// LMPop pops up to count items from the first non-empty list among keys. It
+// returns the name of that list, or an empty name when all lists are empty.
+func (db *Database) LMPop(keys []string, left bool, count int) (string, []string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, key := range keys {
+		val, err := db.lookupWriteType(key, ListType)
+		if err != nil {
+			return "", nil, err
+		}
+		if val != nil {
+			return key, db.popList(key, val, left, count), nil
+		}
+	}
+	return "", nil, nil
+}
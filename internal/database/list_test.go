@@ -0,0 +1,147 @@
+package database
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// checkList compares l with the expected elements and checks the
+// invariants of its ring buffer.
+func checkList(t *testing.T, l *List, want []string) {
+	t.Helper()
+	if got := l.Items(); !reflect.DeepEqual(got, want) && len(got)+len(want) > 0 {
+		t.Fatalf("list holds %q, want %q", got, want)
+	}
+	for i, item := range want {
+		if got := l.Index(i); got != item {
+			t.Fatalf("Index(%d) = %q, want %q", i, got, item)
+		}
+	}
+	capacity := len(l.items)
+	if capacity&(capacity-1) != 0 || capacity < l.size {
+		t.Fatalf("capacity %d for %d elements", capacity, l.size)
+	}
+	if capacity > 8 && l.size <= capacity/4 {
+		t.Fatalf("capacity %d not shrunk for %d elements", capacity, l.size)
+	}
+}
+
+func TestListWrapsAround(t *testing.T) {
+	l := NewList()
+	var want []string
+	for i := 0; i < 6; i++ {
+		l.PushBack(strconv.Itoa(i))
+		want = append(want, strconv.Itoa(i))
+	}
+	for i := 0; i < 5; i++ {
+		l.PopFront()
+		want = want[1:]
+	}
+	// The next pushes wrap around the end of the buffer
+	for i := 6; i < 13; i++ {
+		l.PushBack(strconv.Itoa(i))
+		want = append(want, strconv.Itoa(i))
+	}
+	if l.head+l.size <= len(l.items) {
+		t.Fatalf("head %d, size %d: the buffer of %d did not wrap", l.head, l.size, len(l.items))
+	}
+	checkList(t, l, want)
+
+	// Growing a wrapped buffer keeps the order
+	for i := 13; i < 20; i++ {
+		l.PushFront(strconv.Itoa(i))
+		want = append([]string{strconv.Itoa(i)}, want...)
+	}
+	checkList(t, l, want)
+	if got := l.Range(2, 4); !reflect.DeepEqual(got, want[2:5]) {
+		t.Errorf("Range(2, 4) = %q, want %q", got, want[2:5])
+	}
+}
+
+func TestListShrinks(t *testing.T) {
+	l := NewList()
+	for i := 0; i < 1000; i++ {
+		l.PushBack(strconv.Itoa(i))
+	}
+	if len(l.items) != 1024 {
+		t.Fatalf("capacity %d for 1000 elements", len(l.items))
+	}
+	for i := 0; i < 990; i++ {
+		if item, _ := l.PopBack(); item != strconv.Itoa(999-i) {
+			t.Fatalf("popped %q, want %d", item, 999-i)
+		}
+	}
+	if len(l.items) > 32 {
+		t.Errorf("capacity %d left for 10 elements", len(l.items))
+	}
+	for l.Len() > 0 {
+		l.PopFront()
+	}
+	if _, ok := l.PopFront(); ok {
+		t.Error("popped from an empty list")
+	}
+	checkList(t, l, nil)
+}
+
+func TestListMatchesSlice(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	l := NewList()
+	var want []string
+	for i := 0; i < 20000; i++ {
+		item := strconv.Itoa(i)
+		switch op := rng.Intn(10); {
+		case op < 3:
+			l.PushFront(item)
+			want = append([]string{item}, want...)
+		case op < 6:
+			l.PushBack(item)
+			want = append(want, item)
+		case op < 8:
+			got, ok := l.PopFront()
+			if ok != (len(want) > 0) || ok && got != want[0] {
+				t.Fatalf("PopFront = %q, %v with %q", got, ok, want)
+			}
+			if ok {
+				want = want[1:]
+			}
+		default:
+			got, ok := l.PopBack()
+			if ok != (len(want) > 0) || ok && got != want[len(want)-1] {
+				t.Fatalf("PopBack = %q, %v with %q", got, ok, want)
+			}
+			if ok {
+				want = want[:len(want)-1]
+			}
+		}
+		if i%97 == 0 {
+			checkList(t, l, want)
+		}
+	}
+	checkList(t, l, want)
+}
+
+func TestListRange(t *testing.T) {
+	tests := []struct {
+		start, stop, size int
+		wantStart         int
+		wantStop          int
+		ok                bool
+	}{
+		{0, -1, 5, 0, 4, true},
+		{-3, -2, 5, 2, 3, true},
+		{-10, 2, 5, 0, 2, true},
+		{1, 100, 5, 1, 4, true},
+		{3, 1, 5, 3, 1, false},
+		{5, 10, 5, 5, 4, false},
+		{0, -1, 0, 0, -1, false},
+	}
+	for _, tt := range tests {
+		start, stop, ok := listRange(tt.start, tt.stop, tt.size)
+		if start != tt.wantStart || stop != tt.wantStop || ok != tt.ok {
+			t.Errorf("listRange(%d, %d, %d) = %d, %d, %v, want %d, %d, %v",
+				tt.start, tt.stop, tt.size, start, stop, ok, tt.wantStart, tt.wantStop, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Estimated bookkeeping cost, in bytes, of the structures holding keys and
+// values. Only the dataset is counted, not the Go runtime overhead.
+const (
+	keyOverhead   = 96 // map entry, key header, Value struct
+	fieldOverhead = 48 // map entry and string headers of a hash field
+
+	skiplistNodeOverhead = 64 // skiplist node of a sorted set member
+)
+
+func entrySize(key string, val *Value) int64 {
+	return keyOverhead + int64(len(key)) + val.mem
+}
+
+func fieldSize(field, value string) int64 {
+	return fieldOverhead + int64(len(field)) + int64(len(value))
+}
+
+func fieldTTLSize(field string) int64 {
+	return fieldOverhead + int64(len(field))
+}
+
+func itemSize(item string) int64 {
+	return fieldOverhead + int64(len(item))
+}
+
+// valueSize computes the size of a value from scratch. Commands that modify
+// a value in place adjust it incrementally through Database.grow.
+func valueSize(val *Value) int64 {
+	size := int64(len(val.StrVal))
+	if val.HashVal != nil {
+		val.HashVal.Range(func(field, value string) bool {
+			size += fieldSize(field, value)
+			return true
+		})
+	}
+	if val.ListVal != nil {
+		for _, item := range val.ListVal.Items() {
+			size += itemSize(item)
+		}
+	}
+	if val.SetVal != nil {
+		val.SetVal.Range(func(member string, _ struct{}) bool {
+			size += itemSize(member)
+			return true
+		})
+	}
+	for field := range val.FieldExpiry {
+		size += fieldTTLSize(field)
+	}
+	if val.ZSetVal != nil {
+		for _, m := range val.ZSetVal.Members() {
+			size += zsetItemSize(m.Member)
+		}
+	}
+	return size
+}
+
+// UsedMemory returns the estimated size of the dataset in bytes.
+func (db *Database) UsedMemory() int64 {
+	db.mu.RLock()
+	defer db.readUnlock()
+	return db.usedMemory
+}
+
+// LFU counter parameters, as in Redis: new keys start at lfuInitVal, the
+// counter grows logarithmically and loses one point per idle minute.
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = time.Minute
+)
+
+// accessInfo tracks how recently and how often a key is used. Reads update
+// it while holding only the read lock, so every field is accessed
+// atomically.
+type accessInfo struct {
+	lastAccess int64  // unix nanoseconds
+	lfuCounter uint32 // logarithmic access frequency
+	lfuDecayAt int64  // unix nanoseconds of the last counter decay
+}
+
+func (a *accessInfo) init() {
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&a.lastAccess, now)
+	atomic.StoreUint32(&a.lfuCounter, lfuInitVal)
+	atomic.StoreInt64(&a.lfuDecayAt, now)
+}
+
+// touch records an access to the key.
+func (a *accessInfo) touch() {
+	atomic.StoreInt64(&a.lastAccess, time.Now().UnixNano())
+
+	counter := a.decayedCounter()
+	if counter < 255 {
+		base := float64(counter) - lfuInitVal
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1.0/(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+	atomic.StoreUint32(&a.lfuCounter, counter)
+}
+
+// decayedCounter returns the LFU counter after applying the decay for the
+// time elapsed since it was last decayed.
+func (a *accessInfo) decayedCounter() uint32 {
+	now := time.Now().UnixNano()
+	counter := atomic.LoadUint32(&a.lfuCounter)
+	periods := uint32((now - atomic.LoadInt64(&a.lfuDecayAt)) / int64(lfuDecayTime))
+	if periods == 0 {
+		return counter
+	}
+	atomic.StoreInt64(&a.lfuDecayAt, now)
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
+
+func (a *accessInfo) idle() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&a.lastAccess))
+}
+
+// Evict removes one key chosen by an eviction policy, looking at samples
+// keys picked at random as Redis does. Policies starting with "volatile-"
+// only consider keys with an expiration. It returns the evicted key, or
+// false when no key qualifies.
+func (db *Database) Evict(policy string, samples int) (string, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if samples < 1 {
+		samples = 1
+	}
+
+	candidates := db.sampleKeys(strings.HasPrefix(policy, "volatile-"), samples)
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	best := candidates[0]
+	bestScore := db.evictionScore(policy, best)
+	for _, key := range candidates[1:] {
+		if score := db.evictionScore(policy, key); score > bestScore {
+			best, bestScore = key, score
+		}
+	}
+
+	db.removeKey(best)
+	db.modified(best, 1)
+	db.notify(EventEvicted, "evicted", best)
+	return best, true
+}
+
+// sampleKeys returns up to n keys picked at random in the keyspace, or among
+// the keys with an expiration when volatile is set.
+func (db *Database) sampleKeys(volatile bool, n int) []string {
+	keys := make([]string, 0, n)
+	for len(keys) < n {
+		var key string
+		var ok bool
+		if volatile {
+			key, ok = db.expiry.RandomKey()
+		} else {
+			key, ok = db.data.RandomKey()
+		}
+		if !ok {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// evictionScore rates how good a candidate key is for eviction; the key
+// with the highest score is evicted.
+func (db *Database) evictionScore(policy, key string) int64 {
+	// Keys that already expired are always the best choice
+	if db.isExpired(key) {
+		return 1<<63 - 1
+	}
+
+	val, _ := db.data.Get(key)
+	switch {
+	case strings.HasSuffix(policy, "-lru"):
+		return int64(val.access.idle())
+	case strings.HasSuffix(policy, "-lfu"):
+		return 255 - int64(val.access.decayedCounter())
+	case policy == "volatile-ttl":
+		return -db.expireTime(key).UnixNano()
+	default:
+		// Random policies: the sample is already random
+		return 0
+	}
+}
@@ -0,0 +1,57 @@
+package database
+
+// EventClass is a class of keyspace events, one of the characters of the
+// notify-keyspace-events directive.
+type EventClass int
+
+const (
+	EventGeneric EventClass = 1 << iota // g: DEL, EXPIRE, RENAME...
+	EventString                         // $
+	EventList                           // l
+	EventSet                            // s
+	EventHash                           // h
+	EventZSet                           // z
+	EventExpired                        // x: a key expired
+	EventEvicted                        // e: a key was evicted for maxmemory
+)
+
+// Notifier receives the keyspace events of a database: event happened to
+// key in the database with index db. It is called with the database lock
+// held and must not call back into the database.
+type Notifier func(db int, class EventClass, event, key string)
+
+// SetNotifier installs the function receiving the keyspace events.
+func (db *Database) SetNotifier(fn Notifier) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.notifier = fn
+}
+
+// notify reports an event on key. Nothing is reported while loading, the
+// dataset is only being rebuilt.
+func (db *Database) notify(class EventClass, event, key string) {
+	if db.notifier != nil && !db.loading {
+		db.notifier(db.id, class, event, key)
+	}
+}
+
+// notifyIfRemoved reports the deletion of key when its value val, which
+// just lost elements, was removed from the keyspace with the last one.
+func (db *Database) notifyIfRemoved(key string, val *Value) {
+	if val.elements() == 0 {
+		db.notify(EventGeneric, "del", key)
+	}
+}
+
+// ReadyFunc is told that a list was stored at key in the database with
+// index db, so that clients blocked on the key can be served. Unlike the
+// keyspace events it is also called while loading. It is called with the
+// database lock held and must not call back into the database.
+type ReadyFunc func(db int, key string)
+
+// SetReadyFunc installs the function told about new lists.
+func (db *Database) SetReadyFunc(fn ReadyFunc) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.ready = fn
+}
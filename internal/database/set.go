@@ -0,0 +1,401 @@
+package database
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// SetOp selects the operation of SetCompute and SetStore.
+type SetOp int
+
+const (
+	SetInter SetOp = iota
+	SetUnion
+	SetDiff
+)
+
+// storeEvent is the keyspace event of the STORE variant of op.
+func (op SetOp) storeEvent() string {
+	switch op {
+	case SetInter:
+		return "sinterstore"
+	case SetUnion:
+		return "sunionstore"
+	}
+	return "sdiffstore"
+}
+
+func newSetValue() *Value {
+	return &Value{Type: SetType, SetVal: NewDict[struct{}]()}
+}
+
+// SAdd adds members to the set at key and returns how many were not
+// already present.
+func (db *Database) SAdd(key string, members []string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, SetType)
+	if err != nil {
+		return 0, err
+	}
+	if val == nil {
+		val = newSetValue()
+		db.setKey(key, val)
+	}
+
+	added := 0
+	for _, member := range members {
+		if val.SetVal.Set(member, struct{}{}) {
+			db.grow(val, itemSize(member))
+			added++
+		}
+	}
+	if added > 0 {
+		db.notify(EventSet, "sadd", key)
+	}
+	db.modified(key, added)
+	return added, nil
+}
+
+// SRem removes members from the set at key and returns how many were
+// present. The key is removed with its last member.
+func (db *Database) SRem(key string, members []string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, SetType)
+	if val == nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		if db.removeMember(key, val, member) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		db.notify(EventSet, "srem", key)
+		db.notifyIfRemoved(key, val)
+	}
+	db.modified(key, removed)
+	return removed, nil
+}
+
+// removeMember deletes member from a set value, and the key when the set
+// becomes empty, which the caller reports with notifyIfRemoved.
+func (db *Database) removeMember(key string, val *Value, member string) bool {
+	if _, ok := val.SetVal.Delete(member); !ok {
+		return false
+	}
+	db.grow(val, -itemSize(member))
+	if val.SetVal.Len() == 0 {
+		db.removeKey(key)
+	}
+	return true
+}
+
+// SMIsMember reports, for each member, whether it belongs to the set at key.
+func (db *Database) SMIsMember(key string, members []string) ([]bool, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, SetType)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]bool, len(members))
+	if val != nil {
+		for i, member := range members {
+			result[i] = val.SetVal.Has(member)
+		}
+	}
+	return result, nil
+}
+
+func (db *Database) SMembers(key string) ([]string, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, SetType)
+	if val == nil {
+		return []string{}, err
+	}
+	return val.SetVal.Keys(), nil
+}
+
+func (db *Database) SCard(key string) (int, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, SetType)
+	if val == nil {
+		return 0, err
+	}
+	return val.SetVal.Len(), nil
+}
+
+// SPop removes and returns up to count random members.
+func (db *Database) SPop(key string, count int) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, SetType)
+	if val == nil {
+		return nil, err
+	}
+
+	if count >= val.SetVal.Len() {
+		members := val.SetVal.Keys()
+		db.removeKey(key)
+		db.modified(key, len(members))
+		db.notify(EventSet, "spop", key)
+		db.notify(EventGeneric, "del", key)
+		return members, nil
+	}
+
+	members := make([]string, 0, count)
+	for len(members) < count {
+		member, _ := val.SetVal.RandomKey()
+		db.removeMember(key, val, member)
+		members = append(members, member)
+	}
+	if len(members) > 0 {
+		db.notify(EventSet, "spop", key)
+	}
+	db.modified(key, len(members))
+	return members, nil
+}
+
+// SRandMember returns random members without removing them. A positive count
+// returns up to count distinct members, a negative count returns exactly
+// -count members that may repeat.
+func (db *Database) SRandMember(key string, count int) ([]string, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, SetType)
+	if val == nil {
+		return nil, err
+	}
+
+	set := val.SetVal
+	if count < 0 {
+		members := make([]string, -count)
+		for i := range members {
+			members[i], _ = set.RandomKey()
+		}
+		return members, nil
+	}
+
+	// Picking distinct members at random gets slow when most of the set is
+	// requested: shuffle a copy instead.
+	if count*3 > set.Len() {
+		members := set.Keys()
+		rand.Shuffle(len(members), func(i, j int) {
+			members[i], members[j] = members[j], members[i]
+		})
+		return members[:min(count, len(members))], nil
+	}
+
+	picked := make(map[string]struct{}, count)
+	members := make([]string, 0, count)
+	for len(members) < count {
+		member, _ := set.RandomKey()
+		if _, ok := picked[member]; !ok {
+			picked[member] = struct{}{}
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+// SMove moves member from the set at src to the set at dst. It returns
+// false when member is not in src.
+func (db *Database) SMove(src, dst, member string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	srcVal, err := db.lookupWriteType(src, SetType)
+	if srcVal == nil {
+		return false, err
+	}
+	dstVal, err := db.lookupWriteType(dst, SetType)
+	if err != nil {
+		return false, err
+	}
+	if !srcVal.SetVal.Has(member) {
+		return false, nil
+	}
+	if src == dst {
+		return true, nil
+	}
+
+	db.removeMember(src, srcVal, member)
+	if dstVal == nil {
+		dstVal = newSetValue()
+		db.setKey(dst, dstVal)
+	}
+	if dstVal.SetVal.Set(member, struct{}{}) {
+		db.grow(dstVal, itemSize(member))
+	}
+	db.notify(EventSet, "srem", src)
+	db.notifyIfRemoved(src, srcVal)
+	db.notify(EventSet, "sadd", dst)
+	db.modified(src, 1)
+	db.modified(dst, 1)
+	return true, nil
+}
+
+// setsOf returns the sets stored at keys, nil for missing keys. Every key
+// is checked so that a wrong type is reported even when the result would be
+// empty anyway.
+func (db *Database) setsOf(keys []string) ([]*Dict[struct{}], error) {
+	sets := make([]*Dict[struct{}], len(keys))
+	for i, key := range keys {
+		val, err := db.lookupType(key, SetType)
+		if err != nil {
+			return nil, err
+		}
+		if val != nil {
+			sets[i] = val.SetVal
+		}
+	}
+	return sets, nil
+}
+
+// computeSet applies op to sets. limit stops an intersection once that many
+// members were found, 0 meaning no limit.
+func computeSet(op SetOp, sets []*Dict[struct{}], limit int) []string {
+	result := []string{}
+	switch op {
+	case SetInter:
+		for _, set := range sets {
+			if set == nil {
+				return result
+			}
+		}
+		// Iterate the smallest set and probe the others, smallest first,
+		// so the work is bounded by the size of the smallest set.
+		sorted := append([]*Dict[struct{}](nil), sets...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Len() < sorted[j].Len() })
+		sorted[0].Range(func(member string, _ struct{}) bool {
+			for _, other := range sorted[1:] {
+				if !other.Has(member) {
+					return true
+				}
+			}
+			result = append(result, member)
+			return limit == 0 || len(result) < limit
+		})
+
+	case SetUnion:
+		seen := make(map[string]struct{})
+		for _, set := range sets {
+			if set == nil {
+				continue
+			}
+			set.Range(func(member string, _ struct{}) bool {
+				if _, ok := seen[member]; !ok {
+					seen[member] = struct{}{}
+					result = append(result, member)
+				}
+				return true
+			})
+		}
+
+	case SetDiff:
+		if sets[0] == nil {
+			return result
+		}
+		sets[0].Range(func(member string, _ struct{}) bool {
+			for _, other := range sets[1:] {
+				if other != nil && other.Has(member) {
+					return true
+				}
+			}
+			result = append(result, member)
+			return true
+		})
+	}
+	return result
+}
+
+// SetCompute returns the intersection, union or difference of the sets at
+// keys. Missing keys are empty sets.
+func (db *Database) SetCompute(op SetOp, keys []string) ([]string, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	sets, err := db.setsOf(keys)
+	if err != nil {
+		return nil, err
+	}
+	return computeSet(op, sets, 0), nil
+}
+
+// SInterCard returns the size of the intersection of the sets at keys,
+// stopping at limit when it is not 0.
+func (db *Database) SInterCard(keys []string, limit int) (int, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	sets, err := db.setsOf(keys)
+	if err != nil {
+		return 0, err
+	}
+	return len(computeSet(SetInter, sets, limit)), nil
+}
+
+// SetStore stores the result of SetCompute at dst, replacing any existing
+// value, and returns its size. An empty result deletes dst.
+func (db *Database) SetStore(op SetOp, dst string, keys []string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	sets, err := db.setsOf(keys)
+	if err != nil {
+		return 0, err
+	}
+	members := computeSet(op, sets, 0)
+
+	_, existed := db.data.Get(dst)
+	db.removeKey(dst)
+	if len(members) > 0 {
+		val := newSetValue()
+		for _, member := range members {
+			val.SetVal.Set(member, struct{}{})
+		}
+		db.setKey(dst, val)
+		db.notify(EventSet, op.storeEvent(), dst)
+	} else if existed {
+		db.notify(EventGeneric, "del", dst)
+	}
+	db.modified(dst, 1)
+	return len(members), nil
+}
+
+// SScan returns the members in the bucket at cursor and the following ones,
+// until at least count members were collected or 10*count buckets visited,
+// and the cursor to resume from.
+func (db *Database) SScan(key string, cursor uint64, count int) (uint64, []string, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, SetType)
+	if val == nil {
+		return 0, []string{}, err
+	}
+
+	members := []string{}
+	for visited := 0; visited < count*10; visited++ {
+		cursor = val.SetVal.Scan(cursor, func(member string, _ struct{}) {
+			members = append(members, member)
+		})
+		if cursor == 0 || len(members) >= count {
+			break
+		}
+	}
+	return cursor, members, nil
+}
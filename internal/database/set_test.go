@@ -0,0 +1,150 @@
+package database
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// sorted returns members in order, for comparing set replies.
+func sorted(members []string) []string {
+	sort.Strings(members)
+	return members
+}
+
+// newSetDatabase returns a database holding the sets a = {1..6},
+// b = {4..9} and c = {5, 6, 10}, and the string s.
+func newSetDatabase(t *testing.T) *Database {
+	t.Helper()
+	db := NewDatabase(0)
+	for key, members := range map[string][]string{
+		"a": {"1", "2", "3", "4", "5", "6"},
+		"b": {"4", "5", "6", "7", "8", "9"},
+		"c": {"5", "6", "10"},
+	} {
+		if _, err := db.SAdd(key, members); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Set("s", "string")
+	return db
+}
+
+func TestSetCompute(t *testing.T) {
+	db := newSetDatabase(t)
+	tests := []struct {
+		op   SetOp
+		keys []string
+		want []string
+	}{
+		{SetInter, []string{"a", "b"}, []string{"4", "5", "6"}},
+		{SetInter, []string{"a", "b", "c"}, []string{"5", "6"}},
+		{SetInter, []string{"a", "missing"}, []string{}},
+		{SetUnion, []string{"c", "missing", "a"}, []string{"1", "10", "2", "3", "4", "5", "6"}},
+		{SetDiff, []string{"a", "b"}, []string{"1", "2", "3"}},
+		{SetDiff, []string{"b", "missing", "c"}, []string{"4", "7", "8", "9"}},
+		{SetDiff, []string{"missing", "a"}, []string{}},
+	}
+	for _, tt := range tests {
+		got, err := db.SetCompute(tt.op, tt.keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got = sorted(got); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SetCompute(%d, %q) = %q, want %q", tt.op, tt.keys, got, tt.want)
+		}
+	}
+
+	// A key of another type fails even when the result is known empty
+	for _, op := range []SetOp{SetInter, SetUnion, SetDiff} {
+		if _, err := db.SetCompute(op, []string{"missing", "s"}); err != ErrWrongType {
+			t.Errorf("SetCompute(%d) with a string returned %v", op, err)
+		}
+	}
+}
+
+func TestSInterCard(t *testing.T) {
+	db := newSetDatabase(t)
+	for _, tt := range []struct{ limit, want int }{{0, 3}, {2, 2}, {10, 3}} {
+		if got, err := db.SInterCard([]string{"b", "a"}, tt.limit); err != nil || got != tt.want {
+			t.Errorf("SInterCard with limit %d = %d, %v, want %d", tt.limit, got, err, tt.want)
+		}
+	}
+
+	// The order of the keys does not matter when the sizes differ
+	large := make([]string, 10000)
+	for i := range large {
+		large[i] = strconv.Itoa(i)
+	}
+	db.SAdd("large", large)
+	if got, _ := db.SInterCard([]string{"large", "c"}, 0); got != 3 {
+		t.Errorf("SInterCard(large, c) = %d, want 3", got)
+	}
+}
+
+func TestSetStore(t *testing.T) {
+	db := newSetDatabase(t)
+
+	// The destination may be one of the sources, and its type is replaced
+	for _, dst := range []string{"a", "s"} {
+		n, err := db.SetStore(SetInter, dst, []string{"a", "c"})
+		if err != nil || n != 2 {
+			t.Fatalf("SetStore into %s = %d, %v, want 2", dst, n, err)
+		}
+		if got, _ := db.SMembers(dst); !reflect.DeepEqual(sorted(got), []string{"5", "6"}) {
+			t.Errorf("%s holds %q after SetStore", dst, got)
+		}
+	}
+
+	// An empty result deletes the destination
+	if n, err := db.SetStore(SetInter, "b", []string{"b", "missing"}); err != nil || n != 0 {
+		t.Fatalf("SetStore of an empty intersection = %d, %v", n, err)
+	}
+	if got, _ := db.SCard("b"); got != 0 || db.Exists("b") {
+		t.Errorf("b still exists with %d members", got)
+	}
+
+	// A wrong type among the sources leaves the destination alone
+	db.Set("s", "string")
+	if _, err := db.SetStore(SetUnion, "c", []string{"a", "s"}); err != ErrWrongType {
+		t.Fatalf("SetStore with a string source returned %v", err)
+	}
+	if got, _ := db.SCard("c"); got != 3 {
+		t.Errorf("c has %d members after a failed SetStore", got)
+	}
+}
+
+func TestSPopAndSRandMember(t *testing.T) {
+	db := newSetDatabase(t)
+
+	members, _ := db.SRandMember("a", 4)
+	if len(members) != 4 || len(distinct(members)) != 4 {
+		t.Errorf("SRandMember(4) = %q, want 4 distinct members", members)
+	}
+	if members, _ = db.SRandMember("a", 10); len(members) != 6 {
+		t.Errorf("SRandMember(10) = %q, want the 6 members", members)
+	}
+	if members, _ = db.SRandMember("c", -10); len(members) != 10 {
+		t.Errorf("SRandMember(-10) = %q, want 10 members", members)
+	}
+
+	popped, _ := db.SPop("a", 4)
+	left, _ := db.SMembers("a")
+	all := append(popped, left...)
+	if len(left) != 2 || !reflect.DeepEqual(sorted(all), []string{"1", "2", "3", "4", "5", "6"}) {
+		t.Errorf("SPop(4) = %q, leaving %q", popped, left)
+	}
+	if popped, _ = db.SPop("a", 5); len(popped) != 2 || db.Exists("a") {
+		t.Errorf("SPop(5) = %q, a exists: %v", popped, db.Exists("a"))
+	}
+}
+
+// distinct returns the set of items.
+func distinct(items []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		set[item] = struct{}{}
+	}
+	return set
+}
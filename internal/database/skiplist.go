@@ -0,0 +1,295 @@
+package database
+
+import "math/rand"
+
+// Skiplist parameters, as in Redis.
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+// skiplist keeps the members of a sorted set ordered by score, then by
+// member. Each link records how many nodes it skips so that the rank of a
+// node can be computed while searching for it.
+type skiplist struct {
+	header *skiplistNode
+	tail   *skiplistNode
+	length int
+	level  int
+}
+
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	level    []skiplistLevel
+}
+
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &skiplistNode{level: make([]skiplistLevel, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// less reports whether the node sorts before (score, member).
+func (n *skiplistNode) less(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// insert adds a member that must not already be in the list.
+func (sl *skiplist) insert(score float64, member string) *skiplistNode {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skiplistNode{member: member, score: score, level: make([]skiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+	return x
+}
+
+// delete removes the node holding (score, member) and reports whether it
+// was found.
+func (sl *skiplist) delete(score float64, member string) bool {
+	var update [skiplistMaxLevel]*skiplistNode
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	sl.unlink(x, update[:sl.level])
+	return true
+}
+
+func (sl *skiplist) unlink(x *skiplistNode, update []*skiplistNode) {
+	for i := range update {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+// rank returns the 1-based rank of (score, member), or 0 if absent.
+func (sl *skiplist) rank(score float64, member string) int {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !(score < x.level[i].forward.score ||
+			(score == x.level[i].forward.score && member < x.level[i].forward.member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != sl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node at the 1-based rank, or nil if out of range.
+func (sl *skiplist) byRank(rank int) *skiplistNode {
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstInRange returns the first node whose score is in r.
+func (sl *skiplist) firstInRange(r ScoreRange) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.aboveMin(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if x == nil || !r.belowMax(x.score) {
+		return nil
+	}
+	return x
+}
+
+// lastInRange returns the last node whose score is in r.
+func (sl *skiplist) lastInRange(r ScoreRange) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.belowMax(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+	if x == sl.header || !r.aboveMin(x.score) {
+		return nil
+	}
+	return x
+}
+
+// firstInLexRange returns the first node whose member is in r.
+func (sl *skiplist) firstInLexRange(r LexRange) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.aboveMin(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if x == nil || !r.belowMax(x.member) {
+		return nil
+	}
+	return x
+}
+
+// lastInLexRange returns the last node whose member is in r.
+func (sl *skiplist) lastInLexRange(r LexRange) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.belowMax(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+	if x == sl.header || !r.aboveMin(x.member) {
+		return nil
+	}
+	return x
+}
+
+// ScoreRange is a score interval; each bound may be exclusive.
+type ScoreRange struct {
+	Min, Max     float64
+	MinEx, MaxEx bool
+}
+
+func (r ScoreRange) aboveMin(score float64) bool {
+	if r.MinEx {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r ScoreRange) belowMax(score float64) bool {
+	if r.MaxEx {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+// empty reports whether no score can be in the range.
+func (r ScoreRange) empty() bool {
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinEx || r.MaxEx))
+}
+
+// LexRange is an interval of members, for sorted sets whose members all
+// have the same score. MinInf and MaxInf stand for "-" and "+".
+type LexRange struct {
+	Min, Max       string
+	MinEx, MaxEx   bool
+	MinInf, MaxInf bool
+}
+
+func (r LexRange) aboveMin(member string) bool {
+	switch {
+	case r.MinInf:
+		return true
+	case r.MinEx:
+		return member > r.Min
+	default:
+		return member >= r.Min
+	}
+}
+
+func (r LexRange) belowMax(member string) bool {
+	switch {
+	case r.MaxInf:
+		return true
+	case r.MaxEx:
+		return member < r.Max
+	default:
+		return member <= r.Max
+	}
+}
+
+func (r LexRange) empty() bool {
+	if r.MinInf || r.MaxInf {
+		return false
+	}
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinEx || r.MaxEx))
+}
@@ -0,0 +1,263 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+// MaxStringLength is the largest string APPEND and SETRANGE may build.
+const MaxStringLength = 512 << 20
+
+// Errors of the string commands.
+var (
+	ErrNotInteger    = errors.New("ERR value is not an integer or out of range")
+	ErrNotFloat      = errors.New("ERR value is not a valid float")
+	ErrStringTooLong = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	ErrLCSNotString  = errors.New("ERR The specified keys must contain string values")
+	ErrLCSTooLarge   = errors.New("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+)
+
+// SetOptions are the conditions and expiration of a SET.
+type SetOptions struct {
+	NX, XX   bool      // only set a missing, or an existing, key
+	Get      bool      // return the old value, which must be a string
+	KeepTTL  bool      // keep the expiration of the existing key
+	ExpireAt time.Time // zero when the key does not expire
+}
+
+// SetResult reports the outcome of a SET.
+type SetResult struct {
+	Old     string // previous string value, when Get was requested
+	Existed bool   // whether the key held a string before, when Get was requested
+	Stored  bool
+}
+
+func newStringValue(value string) *Value {
+	return &Value{Type: StringType, StrVal: value}
+}
+
+// SetString sets key to a string value under the conditions of opts.
+func (db *Database) SetString(key, value string, opts SetOptions) (SetResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var res SetResult
+	old, exists := db.lookupWrite(key)
+	if opts.Get && exists {
+		if old.Type != StringType {
+			return res, ErrWrongType
+		}
+		res.Old, res.Existed = old.StrVal, true
+	}
+	if (opts.NX && exists) || (opts.XX && !exists) {
+		return res, nil
+	}
+
+	db.setKey(key, newStringValue(value))
+	switch {
+	case !opts.ExpireAt.IsZero():
+		db.expiry.Set(key, opts.ExpireAt)
+	case !opts.KeepTTL:
+		db.expiry.Delete(key)
+	}
+	db.modified(key, 1)
+	db.notify(EventString, "set", key)
+	if !opts.ExpireAt.IsZero() {
+		db.notify(EventGeneric, "expire", key)
+	}
+	res.Stored = true
+	return res, nil
+}
+
+// GetString returns the string stored at key.
+func (db *Database) GetString(key string) (string, bool, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, StringType)
+	if val == nil {
+		return "", false, err
+	}
+	return val.StrVal, true, nil
+}
+
+// MGet returns the values of keys. Missing keys and keys that do not hold a
+// string are reported as not found.
+func (db *Database) MGet(keys []string) ([]string, []bool) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	values := make([]string, len(keys))
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		if val, exists := db.lookup(key); exists && val.Type == StringType {
+			val.access.touch()
+			values[i], found[i] = val.StrVal, true
+		}
+	}
+	return values, found
+}
+
+// MSet sets the keys given as key, value pairs. With nx, nothing is set if
+// any of the keys exists, and the result tells whether the keys were set.
+func (db *Database) MSet(pairs []string, nx bool) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if nx {
+		for i := 0; i < len(pairs); i += 2 {
+			if _, exists := db.lookupWrite(pairs[i]); exists {
+				return false
+			}
+		}
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		db.setKey(pairs[i], newStringValue(pairs[i+1]))
+		db.expiry.Delete(pairs[i])
+		db.modified(pairs[i], 1)
+		db.notify(EventString, "set", pairs[i])
+	}
+	return true
+}
+
+// GetDel returns the string stored at key and deletes the key.
+func (db *Database) GetDel(key string) (string, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, StringType)
+	if val == nil {
+		return "", false, err
+	}
+	db.removeKey(key)
+	db.modified(key, 1)
+	db.notify(EventGeneric, "del", key)
+	return val.StrVal, true, nil
+}
+
+// GetEx returns the string stored at key and sets its expiration to at, or
+// removes it with persist. A zero at without persist leaves it unchanged,
+// and a time in the past deletes the key.
+func (db *Database) GetEx(key string, at time.Time, persist bool) (string, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, StringType)
+	if val == nil {
+		return "", false, err
+	}
+
+	switch {
+	case persist:
+		if _, exists := db.expiry.Get(key); exists {
+			db.expiry.Delete(key)
+			db.modified(key, 1)
+			db.notify(EventGeneric, "persist", key)
+		}
+	case at.IsZero():
+	case db.inPast(at):
+		db.removeKey(key)
+		db.modified(key, 1)
+		db.notify(EventGeneric, "del", key)
+	default:
+		db.expiry.Set(key, at)
+		db.modified(key, 1)
+		db.notify(EventGeneric, "expire", key)
+	}
+	return val.StrVal, true, nil
+}
+
+// addString stores an empty string at key and returns it.
+func (db *Database) addString(key string) *Value {
+	val := newStringValue("")
+	db.setKey(key, val)
+	return val
+}
+
+// setStr replaces the string held by val in place, keeping its expiration.
+func (db *Database) setStr(val *Value, s string) {
+	db.grow(val, int64(len(s)-len(val.StrVal)))
+	val.StrVal = s
+}
+
+// Append appends value to the string at key and returns its new length.
+func (db *Database) Append(key, value string) (int, error) {
+	var n int
+	err := db.UpdateString(key, "append", func(old string, exists bool) (string, error) {
+		if len(old)+len(value) > MaxStringLength {
+			return "", ErrStringTooLong
+		}
+		n = len(old) + len(value)
+		return old + value, nil
+	})
+	return n, err
+}
+
+// SetRange overwrites the string at key from offset, padding it with zero
+// bytes if needed, and returns its new length. An empty value does not
+// create the key.
+func (db *Database) SetRange(key string, offset int, value string) (int, error) {
+	if offset+len(value) > MaxStringLength {
+		return 0, ErrStringTooLong
+	}
+
+	var n int
+	err := db.updateString(key, "setrange", func(old string, exists bool) (string, bool, error) {
+		n = len(old)
+		if value == "" {
+			return "", false, nil
+		}
+		s := []byte(old)
+		if end := offset + len(value); end > len(s) {
+			s = append(s, make([]byte, end-len(s))...)
+		}
+		copy(s[offset:], value)
+		n = len(s)
+		return string(s), true, nil
+	})
+	return n, err
+}
+
+// IncrBy adds incr to the integer stored at key, which starts at 0 when
+// missing. The expiration of the key is kept.
+func (db *Database) IncrBy(key string, incr int64) (int64, error) {
+	var n int64
+	err := db.UpdateString(key, "incrby", func(old string, exists bool) (string, error) {
+		if exists {
+			var err error
+			if n, err = strconv.ParseInt(old, 10, 64); err != nil {
+				return "", ErrNotInteger
+			}
+		}
+		if (incr > 0 && n > math.MaxInt64-incr) || (incr < 0 && n < math.MinInt64-incr) {
+			return "", ErrOverflow
+		}
+		n += incr
+		return strconv.FormatInt(n, 10), nil
+	})
+	return n, err
+}
+
+// IncrByFloat is IncrBy for floating point values. It returns the new value
+// as stored.
+func (db *Database) IncrByFloat(key string, incr float64) (string, error) {
+	var value string
+	err := db.UpdateString(key, "incrbyfloat", func(old string, exists bool) (string, error) {
+		var f float64
+		if exists {
+			var err error
+			if f, err = strconv.ParseFloat(old, 64); err != nil || math.IsNaN(f) {
+				return "", ErrNotFloat
+			}
+		}
+		f += incr
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", ErrNaNOrInfinity
+		}
+		value = strconv.FormatFloat(f, 'f', -1, 64)
+		return value, nil
+	})
+	return value, err
+}
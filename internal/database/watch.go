@@ -0,0 +1,71 @@
+package database
+
+// Optimistic locking for WATCH: every watched key has a version, bumped by
+// each change to the key, including its deletion when it expires or is
+// evicted. A transaction remembers the versions at WATCH time and is
+// aborted when one of them moved. Keys nobody watches carry no version.
+
+type watchedKey struct {
+	version  uint64
+	watchers int
+}
+
+// Watch starts tracking the changes to key and returns its current version.
+// Each call must be balanced by a call to Unwatch.
+func (db *Database) Watch(key string) uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// A key that expired before being watched is simply missing
+	db.lookupWrite(key)
+
+	w, exists := db.watched[key]
+	if !exists {
+		w = &watchedKey{}
+		db.watched[key] = w
+	}
+	w.watchers++
+	return w.version
+}
+
+// Unwatch stops tracking key for one of its watchers.
+func (db *Database) Unwatch(key string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if w, exists := db.watched[key]; exists {
+		if w.watchers--; w.watchers == 0 {
+			delete(db.watched, key)
+		}
+	}
+}
+
+// WatchedVersion returns the current version of a watched key. A key that
+// expired since it was watched counts as changed.
+func (db *Database) WatchedVersion(key string) uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.lookupWrite(key)
+	if w, exists := db.watched[key]; exists {
+		return w.version
+	}
+	return 0
+}
+
+// touchWatched bumps the version of key if it is watched.
+func (db *Database) touchWatched(key string) {
+	if w, exists := db.watched[key]; exists {
+		w.version++
+	}
+}
+
+// touchAllWatched bumps the version of the watched keys that exist, when
+// the whole content of the database is about to change.
+func (db *Database) touchAllWatched() {
+	for key, w := range db.watched {
+		if db.data.Has(key) {
+			w.version++
+		}
+	}
+}
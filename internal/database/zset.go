@@ -0,0 +1,642 @@
+package database
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrNaNScore is returned when an increment produces a NaN score.
+var ErrNaNScore = errors.New("ERR resulting score is not a number (NaN)")
+
+// ZSet is a sorted set: a dict gives the score of a member in O(1) and a
+// skiplist keeps the members ordered by score.
+type ZSet struct {
+	dict *Dict[float64]
+	zsl  *skiplist
+}
+
+// ZMember is a member of a sorted set with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+func NewZSet() *ZSet {
+	return &ZSet{dict: NewDict[float64](), zsl: newSkiplist()}
+}
+
+func (z *ZSet) Len() int {
+	return z.dict.Len()
+}
+
+func (z *ZSet) Score(member string) (float64, bool) {
+	return z.dict.Get(member)
+}
+
+// Add sets the score of member and reports whether it was added.
+func (z *ZSet) Add(member string, score float64) bool {
+	if old, exists := z.dict.Get(member); exists {
+		if old != score {
+			z.zsl.delete(old, member)
+			z.zsl.insert(score, member)
+			z.dict.Set(member, score)
+		}
+		return false
+	}
+	z.zsl.insert(score, member)
+	z.dict.Set(member, score)
+	return true
+}
+
+func (z *ZSet) Remove(member string) bool {
+	score, exists := z.dict.Delete(member)
+	if exists {
+		z.zsl.delete(score, member)
+	}
+	return exists
+}
+
+// Members returns all the members in order.
+func (z *ZSet) Members() []ZMember {
+	members := make([]ZMember, 0, z.Len())
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		members = append(members, ZMember{x.member, x.score})
+	}
+	return members
+}
+
+// Scan iterates the dict of the sorted set, see Dict.Scan.
+func (z *ZSet) Scan(cursor uint64, fn func(member string, score float64)) uint64 {
+	return z.dict.Scan(cursor, fn)
+}
+
+func zsetItemSize(member string) int64 {
+	return itemSize(member) + skiplistNodeOverhead
+}
+
+func newZSetValue() *Value {
+	return &Value{Type: ZSetType, ZSetVal: NewZSet()}
+}
+
+// ZAddFlags are the options of ZADD.
+type ZAddFlags struct {
+	NX, XX, GT, LT bool
+	Incr           bool // add the score to the current one
+}
+
+// ZAddResult reports what ZAdd did.
+type ZAddResult struct {
+	Added   int
+	Changed int // members whose score changed, not counting added ones
+	// Score is the new score of the member with Incr; Applied is false
+	// when a flag prevented the update.
+	Score   float64
+	Applied bool
+}
+
+// ZAdd adds members or updates their scores according to flags.
+func (db *Database) ZAdd(key string, flags ZAddFlags, members []ZMember) (ZAddResult, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var result ZAddResult
+	val, err := db.lookupWriteType(key, ZSetType)
+	if err != nil {
+		return result, err
+	}
+	if val == nil {
+		if flags.XX {
+			return result, nil
+		}
+		val = newZSetValue()
+		db.setKey(key, val)
+	}
+
+	zset := val.ZSetVal
+	for _, m := range members {
+		score := m.Score
+		cur, exists := zset.Score(m.Member)
+		if exists {
+			if flags.NX {
+				continue
+			}
+			if flags.Incr {
+				score += cur
+				if math.IsNaN(score) {
+					db.removeIfEmpty(key, val)
+					return result, ErrNaNScore
+				}
+			}
+			if (flags.GT && score <= cur) || (flags.LT && score >= cur) {
+				continue
+			}
+			if score != cur {
+				zset.Add(m.Member, score)
+				result.Changed++
+			}
+		} else {
+			if flags.XX {
+				continue
+			}
+			zset.Add(m.Member, score)
+			db.grow(val, zsetItemSize(m.Member))
+			result.Added++
+		}
+		result.Score = score
+		result.Applied = true
+	}
+
+	db.removeIfEmpty(key, val)
+	db.modified(key, result.Added+result.Changed)
+	switch {
+	case result.Added+result.Changed == 0:
+	case flags.Incr:
+		db.notify(EventZSet, "zincr", key)
+	default:
+		db.notify(EventZSet, "zadd", key)
+	}
+	return result, nil
+}
+
+// removeIfEmpty removes a sorted set that was created for a ZADD which
+// finally added nothing.
+func (db *Database) removeIfEmpty(key string, val *Value) {
+	if val.ZSetVal.Len() == 0 {
+		db.removeKey(key)
+	}
+}
+
+func (db *Database) ZRem(key string, members []string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, ZSetType)
+	if val == nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		if val.ZSetVal.Remove(member) {
+			db.grow(val, -zsetItemSize(member))
+			removed++
+		}
+	}
+	db.removeIfEmpty(key, val)
+	db.modified(key, removed)
+	if removed > 0 {
+		db.notify(EventZSet, "zrem", key)
+		db.notifyIfRemoved(key, val)
+	}
+	return removed, nil
+}
+
+// ZMScore returns the score of each member; found tells which exist.
+func (db *Database) ZMScore(key string, members []string) (scores []float64, found []bool, err error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, ZSetType)
+	if err != nil {
+		return nil, nil, err
+	}
+	scores = make([]float64, len(members))
+	found = make([]bool, len(members))
+	if val != nil {
+		for i, member := range members {
+			scores[i], found[i] = val.ZSetVal.Score(member)
+		}
+	}
+	return scores, found, nil
+}
+
+func (db *Database) ZCard(key string) (int, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, ZSetType)
+	if val == nil {
+		return 0, err
+	}
+	return val.ZSetVal.Len(), nil
+}
+
+// ZCount returns the number of members with a score in r.
+func (db *Database) ZCount(key string, r ScoreRange) (int, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, ZSetType)
+	if val == nil || r.empty() {
+		return 0, err
+	}
+
+	zsl := val.ZSetVal.zsl
+	first := zsl.firstInRange(r)
+	if first == nil {
+		return 0, nil
+	}
+	last := zsl.lastInRange(r)
+	return zsl.rank(last.score, last.member) - zsl.rank(first.score, first.member) + 1, nil
+}
+
+// ZRank returns the 0-based rank of member, counting from the highest score
+// when rev is set.
+func (db *Database) ZRank(key, member string, rev bool) (int, bool, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, ZSetType)
+	if val == nil {
+		return 0, false, err
+	}
+	score, exists := val.ZSetVal.Score(member)
+	if !exists {
+		return 0, false, nil
+	}
+	rank := val.ZSetVal.zsl.rank(score, member) - 1
+	if rev {
+		rank = val.ZSetVal.Len() - 1 - rank
+	}
+	return rank, true, nil
+}
+
+// ZRangeBy selects how a ZRangeSpec is interpreted.
+type ZRangeBy int
+
+const (
+	ZRangeByRank ZRangeBy = iota
+	ZRangeByScore
+	ZRangeByLex
+)
+
+// remEvent is the keyspace event of the ZREMRANGEBY command for by.
+func (by ZRangeBy) remEvent() string {
+	switch by {
+	case ZRangeByScore:
+		return "zremrangebyscore"
+	case ZRangeByLex:
+		return "zremrangebylex"
+	}
+	return "zremrangebyrank"
+}
+
+// ZRangeSpec describes a range of a sorted set, as given to ZRANGE.
+type ZRangeSpec struct {
+	By          ZRangeBy
+	Rev         bool
+	Start, Stop int // ranks, negative counting from the end
+	Score       ScoreRange
+	Lex         LexRange
+	// Offset and Count apply to score and lex ranges; a negative Count
+	// returns everything after Offset.
+	Offset, Count int
+}
+
+// selectRange returns the members of zset in spec, in the requested order.
+func (z *ZSet) selectRange(spec ZRangeSpec) []ZMember {
+	members := []ZMember{}
+	zsl := z.zsl
+
+	if spec.By == ZRangeByRank {
+		start, stop, ok := listRange(spec.Start, spec.Stop, z.Len())
+		if !ok {
+			return members
+		}
+		if spec.Rev {
+			start, stop = z.Len()-1-stop, z.Len()-1-start
+			for x := zsl.byRank(stop + 1); x != nil && len(members) <= stop-start; x = x.backward {
+				members = append(members, ZMember{x.member, x.score})
+			}
+			return members
+		}
+		for x := zsl.byRank(start + 1); x != nil && len(members) <= stop-start; x = x.level[0].forward {
+			members = append(members, ZMember{x.member, x.score})
+		}
+		return members
+	}
+
+	var x *skiplistNode
+	var inRange func(x *skiplistNode) bool
+	if spec.By == ZRangeByScore {
+		if spec.Score.empty() {
+			return members
+		}
+		if spec.Rev {
+			x = zsl.lastInRange(spec.Score)
+			inRange = func(x *skiplistNode) bool { return spec.Score.aboveMin(x.score) }
+		} else {
+			x = zsl.firstInRange(spec.Score)
+			inRange = func(x *skiplistNode) bool { return spec.Score.belowMax(x.score) }
+		}
+	} else {
+		if spec.Lex.empty() {
+			return members
+		}
+		if spec.Rev {
+			x = zsl.lastInLexRange(spec.Lex)
+			inRange = func(x *skiplistNode) bool { return spec.Lex.aboveMin(x.member) }
+		} else {
+			x = zsl.firstInLexRange(spec.Lex)
+			inRange = func(x *skiplistNode) bool { return spec.Lex.belowMax(x.member) }
+		}
+	}
+
+	next := func(x *skiplistNode) *skiplistNode {
+		if spec.Rev {
+			return x.backward
+		}
+		return x.level[0].forward
+	}
+	for offset := spec.Offset; x != nil && offset > 0; offset-- {
+		x = next(x)
+	}
+	for ; x != nil && inRange(x) && (spec.Count < 0 || len(members) < spec.Count); x = next(x) {
+		members = append(members, ZMember{x.member, x.score})
+	}
+	return members
+}
+
+// ZRange returns the members in spec.
+func (db *Database) ZRange(key string, spec ZRangeSpec) ([]ZMember, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, ZSetType)
+	if val == nil {
+		return []ZMember{}, err
+	}
+	return val.ZSetVal.selectRange(spec), nil
+}
+
+// storeZSet replaces dst with a sorted set holding members, or deletes it
+// when members is empty. event is the keyspace event of the command.
+func (db *Database) storeZSet(dst string, members []ZMember, event string) {
+	_, existed := db.data.Get(dst)
+	db.removeKey(dst)
+	if len(members) > 0 {
+		val := newZSetValue()
+		for _, m := range members {
+			val.ZSetVal.Add(m.Member, m.Score)
+		}
+		db.setKey(dst, val)
+		db.notify(EventZSet, event, dst)
+	} else if existed {
+		db.notify(EventGeneric, "del", dst)
+	}
+	db.modified(dst, 1)
+}
+
+// ZRangeStore stores the members of src in spec at dst and returns their
+// number.
+func (db *Database) ZRangeStore(dst, src string, spec ZRangeSpec) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(src, ZSetType)
+	if err != nil {
+		return 0, err
+	}
+	var members []ZMember
+	if val != nil {
+		members = val.ZSetVal.selectRange(spec)
+	}
+	db.storeZSet(dst, members, "zrangestore")
+	return len(members), nil
+}
+
+// ZRemRange removes the members in spec, ignoring Rev, Offset and Count.
+func (db *Database) ZRemRange(key string, spec ZRangeSpec) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, ZSetType)
+	if val == nil {
+		return 0, err
+	}
+
+	spec.Rev, spec.Offset, spec.Count = false, 0, -1
+	members := val.ZSetVal.selectRange(spec)
+	for _, m := range members {
+		val.ZSetVal.Remove(m.Member)
+		db.grow(val, -zsetItemSize(m.Member))
+	}
+	db.removeIfEmpty(key, val)
+	db.modified(key, len(members))
+	if len(members) > 0 {
+		db.notify(EventZSet, spec.By.remEvent(), key)
+		db.notifyIfRemoved(key, val)
+	}
+	return len(members), nil
+}
+
+// ZPop removes and returns up to count members with the lowest scores, or
+// the highest when max is set.
+func (db *Database) ZPop(key string, count int, max bool) ([]ZMember, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	val, err := db.lookupWriteType(key, ZSetType)
+	if val == nil {
+		return []ZMember{}, err
+	}
+	return db.popZSet(key, val, count, max), nil
+}
+
+func (db *Database) popZSet(key string, val *Value, count int, max bool) []ZMember {
+	zsl := val.ZSetVal.zsl
+	members := make([]ZMember, 0, min(count, val.ZSetVal.Len()))
+	for len(members) < count {
+		x := zsl.header.level[0].forward
+		if max {
+			x = zsl.tail
+		}
+		if x == nil {
+			break
+		}
+		members = append(members, ZMember{x.member, x.score})
+		val.ZSetVal.Remove(x.member)
+		db.grow(val, -zsetItemSize(x.member))
+	}
+	db.removeIfEmpty(key, val)
+	db.modified(key, len(members))
+	if len(members) > 0 {
+		event := "zpopmin"
+		if max {
+			event = "zpopmax"
+		}
+		db.notify(EventZSet, event, key)
+		db.notifyIfRemoved(key, val)
+	}
+	return members
+}
+
+// Aggregate selects how ZUNIONSTORE and ZINTERSTORE combine scores.
+type Aggregate int
+
+const (
+	AggregateSum Aggregate = iota
+	AggregateMin
+	AggregateMax
+)
+
+func (a Aggregate) apply(x, y float64) float64 {
+	switch a {
+	case AggregateMin:
+		return math.Min(x, y)
+	case AggregateMax:
+		return math.Max(x, y)
+	default:
+		// inf + -inf is NaN; Redis uses 0 instead
+		if sum := x + y; !math.IsNaN(sum) {
+			return sum
+		}
+		return 0
+	}
+}
+
+// zsetInput is a source of ZUNIONSTORE/ZINTERSTORE; plain sets count as
+// sorted sets where every member has a score of 1.
+type zsetInput struct {
+	zset *ZSet
+	set  *Dict[struct{}]
+}
+
+func (in zsetInput) len() int {
+	switch {
+	case in.zset != nil:
+		return in.zset.Len()
+	case in.set != nil:
+		return in.set.Len()
+	}
+	return 0
+}
+
+func (in zsetInput) score(member string) (float64, bool) {
+	if in.zset != nil {
+		return in.zset.Score(member)
+	}
+	if in.set != nil && in.set.Has(member) {
+		return 1, true
+	}
+	return 0, false
+}
+
+func (in zsetInput) each(fn func(member string, score float64)) {
+	if in.zset != nil {
+		in.zset.dict.Range(func(member string, score float64) bool {
+			fn(member, score)
+			return true
+		})
+	} else if in.set != nil {
+		in.set.Range(func(member string, _ struct{}) bool {
+			fn(member, 1)
+			return true
+		})
+	}
+}
+
+// ZStore computes the union, or the intersection when inter is set, of the
+// sets and sorted sets at keys and stores it at dst. Scores are multiplied
+// by the weight of their key, nil weights meaning 1.
+func (db *Database) ZStore(dst string, keys []string, weights []float64, agg Aggregate, inter bool) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	inputs := make([]zsetInput, len(keys))
+	for i, key := range keys {
+		val, exists := db.lookupWrite(key)
+		if !exists {
+			continue
+		}
+		switch val.Type {
+		case ZSetType:
+			inputs[i].zset = val.ZSetVal
+		case SetType:
+			inputs[i].set = val.SetVal
+		default:
+			return 0, ErrWrongType
+		}
+	}
+
+	weight := func(i int) float64 {
+		if weights == nil {
+			return 1
+		}
+		return weights[i]
+	}
+	weighted := func(score, w float64) float64 {
+		if s := score * w; !math.IsNaN(s) {
+			return s
+		}
+		return 0
+	}
+
+	result := make(map[string]float64)
+	if inter {
+		// Start from the smallest input, as SINTER does
+		smallest := 0
+		for i, in := range inputs {
+			if in.len() < inputs[smallest].len() {
+				smallest = i
+			}
+		}
+		inputs[smallest].each(func(member string, score float64) {
+			acc := weighted(score, weight(smallest))
+			for i, in := range inputs {
+				if i == smallest {
+					continue
+				}
+				s, ok := in.score(member)
+				if !ok {
+					return
+				}
+				acc = agg.apply(acc, weighted(s, weight(i)))
+			}
+			result[member] = acc
+		})
+	} else {
+		for i, in := range inputs {
+			in.each(func(member string, score float64) {
+				s := weighted(score, weight(i))
+				if acc, ok := result[member]; ok {
+					s = agg.apply(acc, s)
+				}
+				result[member] = s
+			})
+		}
+	}
+
+	members := make([]ZMember, 0, len(result))
+	for member, score := range result {
+		members = append(members, ZMember{member, score})
+	}
+	event := "zunionstore"
+	if inter {
+		event = "zinterstore"
+	}
+	db.storeZSet(dst, members, event)
+	return len(members), nil
+}
+
+// ZScan is SScan for sorted sets.
+func (db *Database) ZScan(key string, cursor uint64, count int) (uint64, []ZMember, error) {
+	db.mu.RLock()
+	defer db.readUnlock()
+
+	val, err := db.lookupType(key, ZSetType)
+	if val == nil {
+		return 0, []ZMember{}, err
+	}
+
+	members := []ZMember{}
+	for visited := 0; visited < count*10; visited++ {
+		cursor = val.ZSetVal.Scan(cursor, func(member string, score float64) {
+			members = append(members, ZMember{member, score})
+		})
+		if cursor == 0 || len(members) >= count {
+			break
+		}
+	}
+	return cursor, members, nil
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// checkZSet compares z with the expected scores, checking the order of the
+// skiplist in both directions and the rank of every member.
+func checkZSet(t *testing.T, z *ZSet, scores map[string]float64) {
+	t.Helper()
+	want := make([]ZMember, 0, len(scores))
+	for member, score := range scores {
+		want = append(want, ZMember{member, score})
+	}
+	sort.Slice(want, func(i, j int) bool {
+		if want[i].Score != want[j].Score {
+			return want[i].Score < want[j].Score
+		}
+		return want[i].Member < want[j].Member
+	})
+
+	if got := z.Members(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sorted set holds %v, want %v", got, want)
+	}
+	if z.zsl.length != len(want) {
+		t.Fatalf("skiplist length %d, want %d", z.zsl.length, len(want))
+	}
+	i := len(want) - 1
+	for x := z.zsl.tail; x != nil; x = x.backward {
+		if i < 0 || x.member != want[i].Member {
+			t.Fatalf("backward link to %q at position %d", x.member, i)
+		}
+		i--
+	}
+	for rank, m := range want {
+		if got := z.zsl.rank(m.Score, m.Member); got != rank+1 {
+			t.Fatalf("rank of %q = %d, want %d", m.Member, got, rank+1)
+		}
+		if x := z.zsl.byRank(rank + 1); x == nil || x.member != m.Member {
+			t.Fatalf("byRank(%d) = %v, want %q", rank+1, x, m.Member)
+		}
+	}
+}
+
+func TestZSetMatchesModel(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	z := NewZSet()
+	scores := make(map[string]float64)
+	for i := 0; i < 5000; i++ {
+		member := "m" + strconv.Itoa(rng.Intn(500))
+		// Few distinct scores, so that members often tie
+		score := float64(rng.Intn(50))
+		if rng.Intn(3) == 0 {
+			_, exists := scores[member]
+			if z.Remove(member) != exists {
+				t.Fatalf("Remove(%q) with the member present: %v", member, exists)
+			}
+			delete(scores, member)
+		} else {
+			_, exists := scores[member]
+			if z.Add(member, score) == exists {
+				t.Fatalf("Add(%q) with the member present: %v", member, exists)
+			}
+			scores[member] = score
+		}
+		if i%250 == 0 {
+			checkZSet(t, z, scores)
+		}
+	}
+	checkZSet(t, z, scores)
+}
+
+// newZSetDatabase returns a database holding the sorted set z with the
+// members a to f, scored 1 to 6.
+func newZSetDatabase(t *testing.T) *Database {
+	t.Helper()
+	db := NewDatabase(0)
+	var members []ZMember
+	for i, member := range []string{"a", "b", "c", "d", "e", "f"} {
+		members = append(members, ZMember{member, float64(i + 1)})
+	}
+	if _, err := db.ZAdd("z", ZAddFlags{}, members); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// memberNames returns the members without their scores.
+func memberNames(members []ZMember) []string {
+	names := make([]string, len(members))
+	for i, m := range members {
+		names[i] = m.Member
+	}
+	return names
+}
+
+func TestZRankAndZCount(t *testing.T) {
+	db := newZSetDatabase(t)
+	for _, tt := range []struct {
+		member string
+		rev    bool
+		want   int
+	}{{"a", false, 0}, {"d", false, 3}, {"d", true, 2}, {"f", true, 0}} {
+		if rank, ok, err := db.ZRank("z", tt.member, tt.rev); err != nil || !ok || rank != tt.want {
+			t.Errorf("ZRank(%s, rev %v) = %d, %v, %v, want %d", tt.member, tt.rev, rank, ok, err, tt.want)
+		}
+	}
+	if _, ok, _ := db.ZRank("z", "missing", false); ok {
+		t.Error("ZRank found a missing member")
+	}
+
+	for _, tt := range []struct {
+		r    ScoreRange
+		want int
+	}{
+		{ScoreRange{Min: 2, Max: 4}, 3},
+		{ScoreRange{Min: 2, Max: 4, MinEx: true, MaxEx: true}, 1},
+		{ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}, 6},
+		{ScoreRange{Min: 3.5, Max: 3.7}, 0},
+		{ScoreRange{Min: 4, Max: 4, MinEx: true}, 0},
+		{ScoreRange{Min: 5, Max: 2}, 0},
+	} {
+		if got, err := db.ZCount("z", tt.r); err != nil || got != tt.want {
+			t.Errorf("ZCount(%+v) = %d, %v, want %d", tt.r, got, err, tt.want)
+		}
+	}
+}
+
+func TestZRange(t *testing.T) {
+	db := newZSetDatabase(t)
+	inf := math.Inf(1)
+	tests := []struct {
+		name string
+		spec ZRangeSpec
+		want []string
+	}{
+		{"ranks", ZRangeSpec{Start: 1, Stop: -2}, []string{"b", "c", "d", "e"}},
+		{"reversed ranks", ZRangeSpec{Rev: true, Start: 0, Stop: 1}, []string{"f", "e"}},
+		{"ranks out of range", ZRangeSpec{Start: 4, Stop: 100}, []string{"e", "f"}},
+		{"empty ranks", ZRangeSpec{Start: 3, Stop: 2}, []string{}},
+		{"scores", ZRangeSpec{By: ZRangeByScore, Score: ScoreRange{Min: 2, Max: 5, MinEx: true}, Count: -1}, []string{"c", "d", "e"}},
+		{"scores with limit", ZRangeSpec{By: ZRangeByScore, Score: ScoreRange{Min: -inf, Max: inf}, Offset: 1, Count: 2}, []string{"b", "c"}},
+		{"reversed scores", ZRangeSpec{By: ZRangeByScore, Rev: true, Score: ScoreRange{Min: 2, Max: 4}, Count: -1}, []string{"d", "c", "b"}},
+		{"offset past the end", ZRangeSpec{By: ZRangeByScore, Score: ScoreRange{Min: 5, Max: inf}, Offset: 3, Count: -1}, []string{}},
+		{"lex", ZRangeSpec{By: ZRangeByLex, Lex: LexRange{Min: "b", MaxEx: true, Max: "e"}, Count: -1}, []string{"b", "c", "d"}},
+		{"reversed lex", ZRangeSpec{By: ZRangeByLex, Rev: true, Lex: LexRange{MinInf: true, Max: "c"}, Count: -1}, []string{"c", "b", "a"}},
+	}
+	for _, tt := range tests {
+		members, err := db.ZRange("z", tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := memberNames(members); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ZRange = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestZRemRange(t *testing.T) {
+	db := newZSetDatabase(t)
+	// Rev, Offset and Count do not apply to removals
+	n, err := db.ZRemRange("z", ZRangeSpec{By: ZRangeByScore, Rev: true, Score: ScoreRange{Min: 2, Max: 3}, Offset: 1, Count: 1})
+	if err != nil || n != 2 {
+		t.Fatalf("ZRemRange by score = %d, %v, want 2", n, err)
+	}
+	if n, _ = db.ZRemRange("z", ZRangeSpec{Start: -2, Stop: -1}); n != 2 {
+		t.Fatalf("ZRemRange by rank = %d, want 2", n)
+	}
+	members, _ := db.ZRange("z", ZRangeSpec{Start: 0, Stop: -1})
+	if got := memberNames(members); !reflect.DeepEqual(got, []string{"a", "d"}) {
+		t.Errorf("z holds %q after the removals", got)
+	}
+
+	// Removing the last members deletes the key
+	if n, _ = db.ZRemRange("z", ZRangeSpec{By: ZRangeByLex, Lex: LexRange{MinInf: true, MaxInf: true}}); n != 2 || db.Exists("z") {
+		t.Errorf("ZRemRange of everything = %d, z exists: %v", n, db.Exists("z"))
+	}
+}
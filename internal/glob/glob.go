@@ -0,0 +1,93 @@
+package glob
+
+// Match reports whether str matches the Redis style glob pattern. It
+// supports '*', '?', character classes such as "[a-z]" or "[^abc]", and
+// backslash escapes.
+func Match(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if Match(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			matched, rest := matchClass(pattern[1:], str[0])
+			if !matched {
+				return false
+			}
+			pattern = rest
+			str = str[1:]
+			continue
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0
+}
+
+// matchClass matches c against the class starting after '['. It returns the
+// pattern following the closing ']'.
+func matchClass(pattern string, c byte) (bool, string) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			if pattern[1] == c {
+				matched = true
+			}
+			pattern = pattern[2:]
+		case len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			pattern = pattern[3:]
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		// Skip the closing ']'
+		pattern = pattern[1:]
+	}
+
+	return matched != negate, pattern
+}
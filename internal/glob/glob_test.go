@@ -0,0 +1,38 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, str string
+		want         bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:42", true},
+		{"user:*", "users:42", false},
+		{"*:*:name", "user:42:name", true},
+		{"**a", "bba", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h[\]]llo`, "h]llo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h\?`, "h?", true},
+		{"abc", "abcd", false},
+		{"abcd", "abc", false},
+		{"[abc", "a", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.str); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.str, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	Debug Level = iota
+	Verbose
+	Notice
+	Warning
+	Nothing
+)
+
+var levelNames = map[string]Level{
+	"debug":   Debug,
+	"verbose": Verbose,
+	"notice":  Notice,
+	"warning": Warning,
+	"nothing": Nothing,
+}
+
+// Markers used in front of each line, as in the Redis log format.
+var levelMarks = [...]string{".", "-", "*", "#"}
+
+var (
+	mu     sync.Mutex
+	level            = Notice
+	output io.Writer = os.Stdout
+	file   *os.File
+)
+
+// ParseLevel converts a loglevel directive value to a Level.
+func ParseLevel(name string) (Level, error) {
+	l, ok := levelNames[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid log level '%s'", name)
+	}
+	return l, nil
+}
+
+func SetLevel(l Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	level = l
+}
+
+// SetFile sends log lines to the named file, or to stdout when path is
+// empty.
+func SetFile(path string) error {
+	var next *os.File
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		next = f
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if file != nil {
+		file.Close()
+	}
+	file = next
+	if next != nil {
+		output = next
+	} else {
+		output = os.Stdout
+	}
+	return nil
+}
+
+func logf(l Level, format string, args ...interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if l < level {
+		return
+	}
+	timestamp := time.Now().Format("02 Jan 2006 15:04:05.000")
+	fmt.Fprintf(output, "%d:M %s %s %s\n", os.Getpid(), timestamp, levelMarks[l], fmt.Sprintf(format, args...))
+}
+
+func Debugf(format string, args ...interface{}) {
+	logf(Debug, format, args...)
+}
+
+func Verbosef(format string, args ...interface{}) {
+	logf(Verbose, format, args...)
+}
+
+func Noticef(format string, args ...interface{}) {
+	logf(Notice, format, args...)
+}
+
+func Warningf(format string, args ...interface{}) {
+	logf(Warning, format, args...)
+}
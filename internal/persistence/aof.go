@@ -0,0 +1,538 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"redis-clone/internal/logger"
+	"redis-clone/internal/protocol"
+)
+
+// appendfsync policies
+const (
+	FsyncAlways   = "always"
+	FsyncEverySec = "everysec"
+	FsyncNo       = "no"
+)
+
+// fsyncSlowThreshold is how long a background fsync may take before it is
+// counted as delayed in INFO.
+const fsyncSlowThreshold = 2 * time.Second
+
+// AOFStatus describes the durability state of the AOF for INFO.
+type AOFStatus struct {
+	Enabled      bool
+	FsyncPolicy  string
+	Lag          time.Duration // age of the oldest write not yet fsynced
+	LastFsync    time.Time
+	LastError    error // of the last fsync
+	LastWriteErr error // of the last write, nil once it was written again
+	DelayedFsync int64
+
+	CurrentSize       int64
+	BaseSize          int64
+	RewriteInProgress bool
+	LastRewriteError  error
+	LastRewriteTook   time.Duration
+}
+
+// ReplayFunc executes a single logged command against the live dataset.
+// It returns the command as it must be logged to reproduce its effect, with
+// relative expirations made absolute, or nil when it changed nothing. It
+// returns an error when the command is rejected.
+type ReplayFunc func(args []string) ([]string, error)
+
+// AOFRepairedError is returned by LoadAOF when the file was loaded but had
+// to be repaired first: a command cut off at its end was truncated away, or
+// legacy lines were dropped while converting it. The server can start with
+// what was loaded, unlike with any other error.
+type AOFRepairedError struct {
+	Reason string
+}
+
+func (e *AOFRepairedError) Error() string {
+	return "aof: " + e.Reason
+}
+
+// AOFEntry is a command to log, with the database it ran against.
+type AOFEntry struct {
+	DB   int
+	Args []string
+}
+
+// WriteAOF appends a command run against database db to the AOF as a RESP
+// multi-bulk array, so that arguments containing spaces, newlines or binary
+// data survive a reload. A SELECT is logged first whenever db differs from
+// the database of the previous command.
+//
+// With the "always" policy the data is fsynced before WriteAOF returns, so
+// callers must log a command before replying to the client. When the data
+// cannot be written, it is kept and written again by the background sync,
+// so that the AOF still follows the dataset once the disk recovers; until
+// then AOFError reports the error and writes should be refused.
+func (m *Manager) WriteAOF(db int, args []string) error {
+	return m.appendAOF([]AOFEntry{{DB: db, Args: args}}, false)
+}
+
+// WriteAOFTransaction appends the writes of a transaction between MULTI and
+// EXEC, in a single write, so that they are replayed as one unit.
+func (m *Manager) WriteAOFTransaction(entries []AOFEntry) error {
+	return m.appendAOF(entries, true)
+}
+
+func (m *Manager) appendAOF(entries []AOFEntry, multi bool) error {
+	if !m.aofEnabled || m.loading {
+		return nil
+	}
+
+	m.aofMu.Lock()
+	defer m.aofMu.Unlock()
+
+	var data []byte
+	if multi {
+		data = protocol.Serialize(protocol.NewBulkArray([]string{"MULTI"}))
+	}
+	for _, entry := range entries {
+		if entry.DB != m.aofSelectedDB {
+			data = append(data, protocol.Serialize(selectCommand(entry.DB))...)
+			m.aofSelectedDB = entry.DB
+		}
+		data = append(data, protocol.Serialize(protocol.NewBulkArray(entry.Args))...)
+	}
+	if multi {
+		data = append(data, protocol.Serialize(protocol.NewBulkArray([]string{"EXEC"}))...)
+	}
+	if m.rewriting {
+		m.rewriteBuf = append(m.rewriteBuf, data...)
+	}
+	// Data waiting to be written again goes first
+	if m.writeErr != nil {
+		m.retryBuf = append(m.retryBuf, data...)
+		return m.writeErr
+	}
+	if err := m.writeData(data); err != nil {
+		return err
+	}
+
+	if m.fsyncPolicy == FsyncAlways {
+		if err := m.aofFile.Sync(); err != nil {
+			m.fsyncErr = err
+			m.markPending()
+			return err
+		}
+		m.lastFsync = time.Now()
+		return nil
+	}
+	m.markPending()
+	return nil
+}
+
+// writeData writes data at the end of the AOF, opening it if needed. On
+// failure, what reached the file is cut so that it still ends with a
+// complete command, and data is kept in retryBuf. Callers must hold aofMu.
+func (m *Manager) writeData(data []byte) error {
+	err := m.prepareAOF()
+	if err == nil {
+		_, err = m.aofWriter.Write(data)
+		if err == nil {
+			err = m.aofWriter.Flush()
+		}
+		if err != nil {
+			// A failed writer keeps failing: start over with a new one
+			m.aofWriter = bufio.NewWriter(m.aofFile)
+			m.aofTorn = true
+			m.prepareAOF()
+		}
+	}
+	if err != nil {
+		m.writeErr = err
+		m.retryBuf = data
+		return err
+	}
+	m.aofSize += int64(len(data))
+	m.writeErr = nil
+	m.retryBuf = nil
+	return nil
+}
+
+// prepareAOF opens the AOF if needed and cuts what a failed write left at
+// its end. Callers must hold aofMu.
+func (m *Manager) prepareAOF() error {
+	if m.aofFile == nil {
+		if err := m.openAOF(); err != nil {
+			return err
+		}
+	}
+	if m.aofTorn {
+		if err := m.aofFile.Truncate(m.aofSize); err != nil {
+			return err
+		}
+		m.aofTorn = false
+	}
+	return nil
+}
+
+// retryAOFWrite writes again the data a failed write kept, in the
+// background. Callers must hold aofMu.
+func (m *Manager) retryAOFWrite() {
+	if m.writeErr == nil {
+		return
+	}
+	if err := m.writeData(m.retryBuf); err != nil {
+		logger.Warningf("Writing the AOF failed again: %v", err)
+		return
+	}
+	logger.Noticef("AOF write error recovered")
+	m.markPending()
+}
+
+// markPending records that written data waits for an fsync. Callers must
+// hold aofMu.
+func (m *Manager) markPending() {
+	if m.pendingSince.IsZero() {
+		m.pendingSince = time.Now()
+	}
+}
+
+// AOFError returns the error that makes the AOF miss writes, or not have
+// them on disk, nil when it is healthy.
+func (m *Manager) AOFError() error {
+	m.aofMu.Lock()
+	defer m.aofMu.Unlock()
+	if m.writeErr != nil {
+		return m.writeErr
+	}
+	return m.fsyncErr
+}
+
+// selectCommand returns the command switching the AOF to database db.
+func selectCommand(db int) *protocol.RESPValue {
+	return protocol.NewBulkArray([]string{"SELECT", strconv.Itoa(db)})
+}
+
+// openAOF opens the AOF for appending. Callers must hold aofMu.
+func (m *Manager) openAOF() error {
+	file, err := os.OpenFile(m.aofPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	m.aofFile = file
+	m.aofWriter = bufio.NewWriter(file)
+	m.aofSize = info.Size()
+	if m.aofBaseSize == 0 {
+		m.aofBaseSize = m.aofSize
+	}
+	return nil
+}
+
+// SetFsyncPolicy selects when the AOF is flushed to disk: on every write
+// ("always"), once per second from a background goroutine ("everysec"), or
+// whenever the operating system decides ("no").
+func (m *Manager) SetFsyncPolicy(policy string) error {
+	switch policy {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+	default:
+		return fmt.Errorf("invalid appendfsync policy '%s'", policy)
+	}
+
+	m.aofMu.Lock()
+	defer m.aofMu.Unlock()
+	m.fsyncPolicy = policy
+	return nil
+}
+
+// StartAOFSync runs the background fsync used by the "everysec" policy,
+// which also retries the writes and fsyncs that failed, whatever the
+// policy.
+func (m *Manager) StartAOFSync() {
+	if !m.aofEnabled {
+		return
+	}
+
+	ticker := time.NewTicker(1 * time.Second)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.fsyncPending()
+			case <-m.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// fsyncPending fsyncs the AOF if writes are waiting, after writing again
+// the data of a failed write. The sync itself runs without holding aofMu so
+// that clients can keep appending meanwhile.
+func (m *Manager) fsyncPending() {
+	m.aofMu.Lock()
+	m.retryAOFWrite()
+	file := m.aofFile
+	since := m.pendingSince
+	retry := m.fsyncErr != nil && m.writeErr == nil
+	if file == nil || since.IsZero() || (m.fsyncPolicy != FsyncEverySec && !retry) || (m.rewriting && m.noFsyncOnRewrite) {
+		m.aofMu.Unlock()
+		return
+	}
+	m.pendingSince = time.Time{}
+	m.aofMu.Unlock()
+
+	start := time.Now()
+	err := file.Sync()
+	elapsed := time.Since(start)
+
+	m.aofMu.Lock()
+	defer m.aofMu.Unlock()
+
+	// A rewrite finishing meanwhile closed file, after syncing the new one
+	if file != m.aofFile {
+		return
+	}
+	if elapsed > fsyncSlowThreshold {
+		m.delayedFsync++
+	}
+	if err != nil {
+		m.fsyncErr = err
+		if m.pendingSince.IsZero() || since.Before(m.pendingSince) {
+			m.pendingSince = since
+		}
+		return
+	}
+	m.fsyncErr = nil
+	m.lastFsync = start
+}
+
+// ResetStats clears the counters reported in INFO.
+func (m *Manager) ResetStats() {
+	m.aofMu.Lock()
+	defer m.aofMu.Unlock()
+	m.delayedFsync = 0
+}
+
+// AOFStatus reports the current fsync state of the AOF.
+func (m *Manager) AOFStatus() AOFStatus {
+	m.aofMu.Lock()
+	defer m.aofMu.Unlock()
+
+	status := AOFStatus{
+		Enabled:      m.aofEnabled,
+		FsyncPolicy:  m.fsyncPolicy,
+		LastFsync:    m.lastFsync,
+		LastError:    m.fsyncErr,
+		LastWriteErr: m.writeErr,
+		DelayedFsync: m.delayedFsync,
+
+		CurrentSize:       m.aofSize,
+		BaseSize:          m.aofBaseSize,
+		RewriteInProgress: m.rewriting,
+		LastRewriteError:  m.lastRewriteErr,
+		LastRewriteTook:   m.lastRewriteTook,
+	}
+	if !m.pendingSince.IsZero() {
+		status.Lag = time.Since(m.pendingSince)
+	}
+	return status
+}
+
+// LoadAOF replays every command stored in the append-only file through
+// replay. Commands are not logged again while loading. A command cut off by
+// a crash at the end of the file is dropped and the file is truncated back to
+// the last complete command so that new writes are not glued onto it; a
+// transaction missing its EXEC is dropped as a whole the same way, and an
+// *AOFRepairedError reports it. Any other error means the file could not be
+// loaded as a whole, and the dataset must not be used.
+//
+// Files written by older versions, with one space separated command per
+// line, are replayed and then converted to the RESP format in place.
+func (m *Manager) LoadAOF(replay ReplayFunc) error {
+	if !m.aofEnabled {
+		return nil
+	}
+
+	file, err := os.OpenFile(m.aofPath(), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	m.loading = true
+	for _, db := range m.dbs {
+		db.SetLoading(true)
+	}
+	defer func() {
+		m.loading = false
+		for _, db := range m.dbs {
+			db.SetLoading(false)
+		}
+	}()
+
+	first := make([]byte, 1)
+	if _, err := file.Read(first); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("aof: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("aof: %w", err)
+	}
+
+	if first[0] != byte(protocol.Array) {
+		return m.migrateLegacyAOF(file, replay)
+	}
+
+	reader := protocol.NewReader(file)
+	multiOffset := int64(-1) // offset of the MULTI of an open transaction
+	for n := 1; ; n++ {
+		offset := reader.Offset()
+		value, err := reader.ReadValue()
+		if err == io.EOF && multiOffset < 0 {
+			return nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || err == io.EOF {
+			if multiOffset >= 0 {
+				offset = multiOffset
+			}
+			return truncateAOF(file, n, offset)
+		}
+		if err != nil {
+			return fmt.Errorf("aof: corrupt command #%d at offset %d: %w", n, offset, err)
+		}
+
+		args, err := commandArgs(value)
+		if err != nil {
+			return fmt.Errorf("aof: corrupt command #%d at offset %d: %w", n, offset, err)
+		}
+		if _, err := replay(args); err != nil {
+			return fmt.Errorf("aof: bad command #%d at offset %d: %w", n, offset, err)
+		}
+		switch strings.ToUpper(args[0]) {
+		case "MULTI":
+			multiOffset = offset
+		case "EXEC":
+			multiOffset = -1
+		}
+	}
+}
+
+// commandArgs checks that value is a non-empty array of bulk strings and
+// returns its elements.
+func commandArgs(value *protocol.RESPValue) ([]string, error) {
+	if value.Type != protocol.Array || value.Null || len(value.Array) == 0 {
+		return nil, errors.New("expected a non-empty array")
+	}
+
+	args := make([]string, len(value.Array))
+	for i, item := range value.Array {
+		if item.Type != protocol.BulkString || item.Null {
+			return nil, fmt.Errorf("argument %d is not a bulk string", i)
+		}
+		args[i] = item.Str
+	}
+	return args, nil
+}
+
+// truncateAOF drops an incomplete trailing command or transaction starting
+// at offset.
+func truncateAOF(file *os.File, n int, offset int64) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("aof: truncated command #%d at offset %d: %w", n, offset, err)
+	}
+	if err := file.Truncate(offset); err != nil {
+		return fmt.Errorf("aof: truncated command #%d at offset %d: %w", n, offset, err)
+	}
+	return &AOFRepairedError{fmt.Sprintf("truncated command #%d at offset %d, discarded %d trailing bytes", n, offset, info.Size()-offset)}
+}
+
+// legacyArity is the number of fields, command name included, of the
+// legacy commands taking a fixed number of arguments. Legacy lines joined
+// the arguments with spaces, so a line with more fields had an argument
+// containing spaces, which can no longer be told apart.
+var legacyArity = map[string]int{
+	"SET":    3,
+	"HSET":   4,
+	"EXPIRE": 3,
+	"INCR":   2,
+	"DECR":   2,
+}
+
+// migrateLegacyAOF replays a line based AOF and rewrites it in the RESP
+// format. Older versions logged commands before running them, so commands
+// that fail on replay are dropped rather than aborting the load, as are
+// the ambiguous ones, see legacyArity. Each command is written back as
+// replay logs it, so that relative expirations become absolute. The
+// original file is only replaced once the converted copy is safely on disk.
+func (m *Manager) migrateLegacyAOF(file *os.File, replay ReplayFunc) error {
+	var commands [][]string
+	var warnings []string
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for line := 1; ; line++ {
+		text, err := reader.ReadString('\n')
+		if err == io.EOF {
+			if text != "" {
+				warnings = append(warnings, fmt.Sprintf("dropped truncated command at line %d (offset %d)", line, offset))
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("aof: read error at line %d (offset %d): %w", line, offset, err)
+		}
+
+		args := strings.Fields(text)
+		if len(args) > 0 {
+			if n, fixed := legacyArity[strings.ToUpper(args[0])]; fixed && len(args) > n {
+				warnings = append(warnings, fmt.Sprintf("dropped ambiguous command at line %d: an argument contains spaces", line))
+			} else if entry, err := replay(args); err != nil {
+				warnings = append(warnings, fmt.Sprintf("dropped failing command at line %d: %v", line, err))
+			} else if entry != nil {
+				commands = append(commands, entry)
+			}
+		}
+		offset += int64(len(text))
+	}
+
+	tmpName := m.aofPath() + ".migrate"
+	tmp, err := os.Create(tmpName)
+	if err != nil {
+		return fmt.Errorf("aof: migration failed: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	for _, args := range commands {
+		writer.Write(protocol.Serialize(protocol.NewBulkArray(args)))
+	}
+	if err := writer.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, m.aofPath())
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("aof: migration failed: %w", err)
+	}
+
+	logger.Noticef("Converted legacy AOF to RESP format (%d commands)", len(commands))
+	if len(warnings) > 0 {
+		return &AOFRepairedError{"legacy migration: " + strings.Join(warnings, "; ")}
+	}
+	return nil
+}
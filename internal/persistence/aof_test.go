@@ -0,0 +1,194 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/protocol"
+)
+
+// newTestManager returns a manager with the AOF enabled, keeping its files
+// in a temporary directory.
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	m := NewManager([]*database.Database{database.NewDatabase(0)}, true)
+	m.SetFiles(t.TempDir(), "dump.rdb", "appendonly.aof")
+	t.Cleanup(m.Close)
+	return m
+}
+
+// encodeCommands returns the commands in the RESP format.
+func encodeCommands(commands [][]string) []byte {
+	var data []byte
+	for _, args := range commands {
+		data = append(data, protocol.Serialize(protocol.NewBulkArray(args))...)
+	}
+	return data
+}
+
+// writeAOF writes the commands to the AOF of m, followed by tail.
+func writeAOF(t *testing.T, m *Manager, commands [][]string, tail string) {
+	t.Helper()
+	data := append(encodeCommands(commands), tail...)
+	if err := os.WriteFile(m.aofPath(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recordReplay returns a replay function recording the commands it gets.
+func recordReplay(replayed *[][]string) ReplayFunc {
+	return func(args []string) ([]string, error) {
+		*replayed = append(*replayed, args)
+		return args, nil
+	}
+}
+
+func TestLoadAOFReplaysCommands(t *testing.T) {
+	m := newTestManager(t)
+	commands := [][]string{
+		{"SET", "greeting", "Hello World\r\n"},
+		{"SELECT", "1"},
+		{"HSET", "user", "name", ""},
+	}
+	writeAOF(t, m, commands, "")
+
+	var replayed [][]string
+	if err := m.LoadAOF(recordReplay(&replayed)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayed, commands) {
+		t.Fatalf("replayed %q, want %q", replayed, commands)
+	}
+}
+
+func TestLoadAOFTruncatesTornTail(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands [][]string
+		tail     string
+		kept     int // commands left in the file
+	}{
+		{"cut command", [][]string{{"SET", "a", "1"}}, "*3\r\n$3\r\nSET\r\n$1\r\nb", 1},
+		{"open transaction", [][]string{{"SET", "a", "1"}, {"MULTI"}, {"SET", "b", "2"}}, "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t)
+			writeAOF(t, m, tt.commands, tt.tail)
+
+			var replayed [][]string
+			err := m.LoadAOF(recordReplay(&replayed))
+			var repaired *AOFRepairedError
+			if !errors.As(err, &repaired) {
+				t.Fatalf("LoadAOF returned %v, want an AOFRepairedError", err)
+			}
+			info, err := os.Stat(m.aofPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := int64(len(encodeCommands(tt.commands[:tt.kept]))); info.Size() != size {
+				t.Errorf("file truncated to %d bytes, want %d", info.Size(), size)
+			}
+			if len(replayed) != len(tt.commands) {
+				t.Errorf("replayed %q, want the complete commands %q", replayed, tt.commands)
+			}
+		})
+	}
+}
+
+func TestLoadAOFRejectsCorruptFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		replay ReplayFunc
+	}{
+		{
+			name: "corrupt record",
+			data: "*2\r\n$4\r\nINCR\r\n$1\r\na\r\n+OK\r\n*2\r\n$4\r\nINCR\r\n$1\r\na\r\n",
+		},
+		{
+			name: "rejected command",
+			data: "*2\r\n$4\r\nINCR\r\n$1\r\na\r\n*1\r\n$5\r\nBOGUS\r\n*2\r\n$4\r\nINCR\r\n$1\r\na\r\n",
+			replay: func(args []string) ([]string, error) {
+				if args[0] == "BOGUS" {
+					return nil, errors.New("ERR unknown command 'BOGUS'")
+				}
+				return args, nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t)
+			path := m.aofPath()
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			replay := tt.replay
+			if replay == nil {
+				replay = func(args []string) ([]string, error) { return args, nil }
+			}
+
+			err := m.LoadAOF(replay)
+			var repaired *AOFRepairedError
+			if err == nil || errors.As(err, &repaired) {
+				t.Fatalf("LoadAOF returned %v, want an error", err)
+			}
+			if !strings.Contains(err.Error(), "#2") {
+				t.Errorf("error %q does not name command #2", err)
+			}
+			// The file is left for the operator to repair
+			data, _ := os.ReadFile(path)
+			if string(data) != tt.data {
+				t.Errorf("file changed to %q", data)
+			}
+		})
+	}
+}
+
+func TestAOFWriteErrorRetried(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.WriteAOF(0, []string{"SET", "a", "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make writes fail as on a broken disk
+	m.aofMu.Lock()
+	file := m.aofFile
+	broken, err := os.Open(m.aofPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken.Close()
+	m.aofFile = broken
+	m.aofWriter = bufio.NewWriter(broken)
+	m.aofMu.Unlock()
+
+	for _, args := range [][]string{{"SET", "b", "2"}, {"SET", "c", "3"}} {
+		if err := m.WriteAOF(0, args); err == nil {
+			t.Fatalf("writing %q to a broken AOF succeeded", args)
+		}
+	}
+	if m.AOFError() == nil {
+		t.Fatal("no AOF error reported after a failed write")
+	}
+
+	// Once the disk is back, the failed writes land in order
+	m.aofMu.Lock()
+	m.aofFile = file
+	m.aofWriter = bufio.NewWriter(file)
+	m.aofMu.Unlock()
+	m.fsyncPending()
+	if err := m.AOFError(); err != nil {
+		t.Fatalf("AOF error %v after a successful retry", err)
+	}
+	want := encodeCommands([][]string{{"SELECT", "0"}, {"SET", "a", "1"}, {"SET", "b", "2"}, {"SET", "c", "3"}})
+	if data, _ := os.ReadFile(m.aofPath()); string(data) != string(want) {
+		t.Errorf("AOF holds %q, want %q", data, want)
+	}
+}
@@ -2,168 +2,143 @@ package persistence
 
 import (
 	"bufio"
-	"encoding/gob"
-	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"redis-clone/internal/database"
 )
 
 type Manager struct {
-	db         *database.Database
-	aofEnabled bool
-	rdbEnabled bool
-	aofFile    *os.File
-	aofWriter  *bufio.Writer
+	dbs         []*database.Database
+	aofEnabled  bool
+	dir         string
+	rdbFilename string
+	aofFilename string
+	aofFile     *os.File
+	aofWriter   *bufio.Writer
+	loading     bool
+	shutdown    chan struct{}
+
+	// aofMu guards the AOF writer and the fsync bookkeeping below
+	aofMu            sync.Mutex
+	fsyncPolicy      string
+	noFsyncOnRewrite bool
+	pendingSince     time.Time // first write not yet fsynced, zero if none
+	lastFsync        time.Time
+	fsyncErr         error
+	delayedFsync     int64
+	aofSelectedDB    int // database of the last logged command, -1 to log a SELECT first
+
+	// writeErr is the error of the last write to the AOF, if it failed.
+	// The data it did not write is kept in retryBuf, ahead of any later
+	// data, for the background sync to write again. aofTorn is set when
+	// part of it could not be cut from the file yet. Also guarded by aofMu.
+	writeErr error
+	retryBuf []byte
+	aofTorn  bool
+
+	// AOF rewrite state, also guarded by aofMu
+	aofSize         int64
+	aofBaseSize     int64
+	rewriting       bool
+	rewriteBuf      []byte
+	lastRewriteErr  error
+	lastRewriteTook time.Duration
+
+	// rdbMu guards the snapshot bookkeeping; rdbWriteMu serializes writers
+	// of the snapshot file
+	rdbMu            sync.Mutex
+	rdbWriteMu       sync.Mutex
+	bgsaveInProgress bool
+	lastSave         time.Time
+	lastSaveErr      error
+	lastSaveAttempt  time.Time
+	lastBgsaveTook   time.Duration
 }
 
-func NewManager(db *database.Database, aofEnabled, rdbEnabled bool) *Manager {
+func NewManager(dbs []*database.Database, aofEnabled bool) *Manager {
 	return &Manager{
-		db:         db,
-		aofEnabled: aofEnabled,
-		rdbEnabled: rdbEnabled,
+		dbs:           dbs,
+		aofEnabled:    aofEnabled,
+		dir:           ".",
+		rdbFilename:   "dump.rdb",
+		aofFilename:   "appendonly.aof",
+		shutdown:      make(chan struct{}),
+		fsyncPolicy:   FsyncEverySec,
+		aofSelectedDB: -1,
+		lastSave:      time.Now(),
 	}
 }
 
-func (m *Manager) StartBackgroundSave(interval time.Duration) {
-	if !m.rdbEnabled {
-		return
-	}
-
-	ticker := time.NewTicker(interval)
-	go func() {
-		defer ticker.Stop()
-		for range ticker.C {
-			m.SaveRDB()
-		}
-	}()
+// SetFiles sets the directory and file names used for the snapshot and the
+// AOF. It must be called before any data is loaded or written.
+func (m *Manager) SetFiles(dir, rdbFilename, aofFilename string) {
+	m.dir = dir
+	m.rdbFilename = rdbFilename
+	m.aofFilename = aofFilename
 }
 
-func (m *Manager) SaveRDB() error {
-	if !m.rdbEnabled {
-		return nil
-	}
-
-	file, err := os.Create("dump.rdb")
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	encoder := gob.NewEncoder(file)
-
-	// Save metadata
-	metadata := map[string]interface{}{
-		"version":   "1.0",
-		"timestamp": time.Now().Unix(),
-	}
-
-	if err := encoder.Encode(metadata); err != nil {
-		return err
-	}
-
-	// Save database data
-	keys := m.db.Keys()
-	data := make(map[string]interface{})
-
-	for _, key := range keys {
-		if val, exists := m.db.Get(key); exists {
-			data[key] = val
-		}
-
-		// Save TTL information
-		if ttl := m.db.TTL(key); ttl > 0 {
-			data[key+"__ttl__"] = ttl
-		}
-	}
-
-	return encoder.Encode(data)
+// SetNoFsyncOnRewrite skips the background fsync while an AOF rewrite is
+// running, trading durability for less disk contention.
+func (m *Manager) SetNoFsyncOnRewrite(skip bool) {
+	m.aofMu.Lock()
+	defer m.aofMu.Unlock()
+	m.noFsyncOnRewrite = skip
 }
 
-func (m *Manager) LoadRDB() error {
-	if !m.rdbEnabled {
-		return nil
-	}
-
-	file, err := os.Open("dump.rdb")
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	decoder := gob.NewDecoder(file)
-
-	// Load metadata
-	var metadata map[string]interface{}
-	if err := decoder.Decode(&metadata); err != nil {
-		return err
-	}
-
-	// Load data
-	var data map[string]interface{}
-	if err := decoder.Decode(&data); err != nil {
-		return err
-	}
-
-	for key, value := range data {
-		if strVal, ok := value.(string); ok {
-			m.db.Set(key, strVal)
-		}
-	}
-
-	return nil
+// snapshot copies every database, as a point in time view that can be
+// written out while clients keep modifying them. The dirty counters are
+// read first: a write landing in between is then counted as still dirty
+// rather than lost.
+//
+// Each database is copied under its own lock only. The view is consistent
+// across databases, and with the AOF, only if the caller made sure no write
+// command is in flight, which the server does by holding its write lock
+// exclusively. Every exported method taking a snapshot requires this.
+func (m *Manager) snapshot() ([]int64, [][]database.Entry) {
+	dirty := make([]int64, len(m.dbs))
+	for i, db := range m.dbs {
+		dirty[i] = db.Dirty()
+	}
+	entries := make([][]database.Entry, len(m.dbs))
+	for i, db := range m.dbs {
+		entries[i] = db.Snapshot()
+	}
+	return dirty, entries
 }
 
-func (m *Manager) WriteAOF(command string) error {
-	if !m.aofEnabled {
-		return nil
+// dirty returns the number of writes since the last successful snapshot,
+// over all databases.
+func (m *Manager) dirty() int64 {
+	var dirty int64
+	for _, db := range m.dbs {
+		dirty += db.Dirty()
 	}
-
-	if m.aofFile == nil {
-		file, err := os.OpenFile("appendonly.aof", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-		if err != nil {
-			return err
-		}
-		m.aofFile = file
-		m.aofWriter = bufio.NewWriter(file)
-	}
-
-	_, err := m.aofWriter.WriteString(command + "\n")
-	if err != nil {
-		return err
-	}
-
-	return m.aofWriter.Flush()
+	return dirty
 }
 
-func (m *Manager) LoadAOF() error {
-	if !m.aofEnabled {
-		return nil
-	}
-
-	file, err := os.Open("appendonly.aof")
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		command := scanner.Text()
-		// Here you would replay the command
-		// This is a simplified version
-		fmt.Printf("Replaying command: %s\n", command)
-	}
+func (m *Manager) rdbPath() string {
+	return filepath.Join(m.dir, m.rdbFilename)
+}
 
-	return scanner.Err()
+func (m *Manager) aofPath() string {
+	return filepath.Join(m.dir, m.aofFilename)
 }
 
 func (m *Manager) Close() {
+	close(m.shutdown)
+
+	m.aofMu.Lock()
+	defer m.aofMu.Unlock()
+
+	m.retryAOFWrite()
 	if m.aofWriter != nil {
 		m.aofWriter.Flush()
 	}
 	if m.aofFile != nil {
+		m.aofFile.Sync()
 		m.aofFile.Close()
 	}
 }
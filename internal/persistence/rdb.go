@@ -0,0 +1,625 @@
+package persistence
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/logger"
+)
+
+// Snapshot file layout:
+//
+//	magic "REDISGO" | version uint16
+//	records: opcode byte followed by its payload
+//	opEOF | CRC-64 (ECMA, big endian) of every preceding byte
+//
+// Strings are encoded as a uvarint length followed by the raw bytes. Entries
+// belong to the database selected by the last opSelectDB, database 0 before
+// any; version 1 files have none.
+const (
+	rdbMagic   = "REDISGO"
+	rdbVersion = 2
+
+	opAux      byte = 0xFA // aux field: name string, value string
+	opEntry    byte = 0xFB // key: expire ms varint (0 = none), type, key, value
+	opSelectDB byte = 0xFE // database number uvarint
+	opEOF      byte = 0xFF
+
+	// rdbMaxStringLen guards against allocating huge buffers for a length
+	// read from a damaged file.
+	rdbMaxStringLen = 512 << 20
+)
+
+// Value type codes used in entries.
+const (
+	rdbTypeString byte = 0
+	rdbTypeHash   byte = 1
+	rdbTypeList   byte = 2
+	rdbTypeSet    byte = 3
+	rdbTypeZSet   byte = 4
+	// Hash with per-field expirations: each value is followed by the
+	// expiration in unix milliseconds, 0 for none
+	rdbTypeHashTTL byte = 5
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+var ErrRDBChecksum = errors.New("rdb: checksum mismatch")
+
+var ErrBgsaveInProgress = errors.New("ERR Background save already in progress")
+
+// bgsaveRetryDelay is how long save rules wait before retrying after a
+// failed background save.
+const bgsaveRetryDelay = 5 * time.Second
+
+// SaveRule triggers a background save once at least Changes writes happened
+// and Seconds have elapsed since the last successful save.
+type SaveRule struct {
+	Seconds int
+	Changes int64
+}
+
+// RDBStatus describes the snapshot state for INFO.
+type RDBStatus struct {
+	ChangesSinceSave int64
+	BgsaveInProgress bool
+	LastSave         time.Time
+	LastError        error
+	LastBgsaveTook   time.Duration
+}
+
+// SaveRDB writes a snapshot, waiting for a background save in progress to
+// finish first. It is used on shutdown, whatever the save rules. No write
+// command may be in flight, see snapshot.
+func (m *Manager) SaveRDB() error {
+	return m.saveSnapshot(m.snapshot())
+}
+
+// Save writes a snapshot synchronously, as the SAVE command does. No write
+// command may be in flight, see snapshot.
+func (m *Manager) Save() error {
+	m.rdbMu.Lock()
+	inProgress := m.bgsaveInProgress
+	m.rdbMu.Unlock()
+	if inProgress {
+		return ErrBgsaveInProgress
+	}
+
+	return m.saveSnapshot(m.snapshot())
+}
+
+// BackgroundSave takes a point-in-time copy of the dataset and writes it to
+// disk from a goroutine, so clients are only blocked while the copy is made.
+// No write command may be in flight while the copy is made, see snapshot.
+func (m *Manager) BackgroundSave() error {
+	m.rdbMu.Lock()
+	if m.bgsaveInProgress {
+		m.rdbMu.Unlock()
+		return ErrBgsaveInProgress
+	}
+	m.bgsaveInProgress = true
+	m.rdbMu.Unlock()
+
+	dirty, entries := m.snapshot()
+
+	go func() {
+		start := time.Now()
+		err := m.saveSnapshot(dirty, entries)
+		if err != nil {
+			logger.Warningf("Background saving error: %v", err)
+		}
+
+		m.rdbMu.Lock()
+		m.bgsaveInProgress = false
+		m.lastBgsaveTook = time.Since(start)
+		m.rdbMu.Unlock()
+	}()
+	return nil
+}
+
+// LastSave returns the time of the last successful snapshot.
+func (m *Manager) LastSave() time.Time {
+	m.rdbMu.Lock()
+	defer m.rdbMu.Unlock()
+	return m.lastSave
+}
+
+// SaveRulesDue reports whether any of rules calls for a background save.
+// The rules only drive these automatic saves: without any, SAVE, BGSAVE,
+// shutdown and loading at startup still use the snapshot file.
+func (m *Manager) SaveRulesDue(rules []SaveRule) bool {
+	if len(rules) == 0 {
+		return false
+	}
+
+	m.rdbMu.Lock()
+	defer m.rdbMu.Unlock()
+
+	if m.bgsaveInProgress {
+		return false
+	}
+	if m.lastSaveErr != nil && time.Since(m.lastSaveAttempt) < bgsaveRetryDelay {
+		return false
+	}
+
+	dirty := m.dirty()
+	elapsed := time.Since(m.lastSave)
+	for _, rule := range rules {
+		if dirty >= rule.Changes && elapsed >= time.Duration(rule.Seconds)*time.Second {
+			return true
+		}
+	}
+	return false
+}
+
+// RDBStatus reports the current snapshot state.
+func (m *Manager) RDBStatus() RDBStatus {
+	m.rdbMu.Lock()
+	defer m.rdbMu.Unlock()
+
+	return RDBStatus{
+		ChangesSinceSave: m.dirty(),
+		BgsaveInProgress: m.bgsaveInProgress,
+		LastSave:         m.lastSave,
+		LastError:        m.lastSaveErr,
+		LastBgsaveTook:   m.lastBgsaveTook,
+	}
+}
+
+// saveSnapshot writes entries, given per database, to a temporary file and
+// renames it over the snapshot, so a crash part way through never leaves a
+// damaged dump.rdb.
+func (m *Manager) saveSnapshot(dirty []int64, entries [][]database.Entry) error {
+	m.rdbWriteMu.Lock()
+	defer m.rdbWriteMu.Unlock()
+
+	err := writeRDBFile(m.rdbPath(), entries)
+
+	m.rdbMu.Lock()
+	defer m.rdbMu.Unlock()
+
+	m.lastSaveAttempt = time.Now()
+	m.lastSaveErr = err
+	if err != nil {
+		return err
+	}
+	m.lastSave = time.Now()
+	for i, db := range m.dbs {
+		db.ClearDirty(dirty[i])
+	}
+	return nil
+}
+
+func writeRDBFile(name string, entries [][]database.Entry) error {
+	tmpName := filepath.Join(filepath.Dir(name), fmt.Sprintf("temp-%d.rdb", os.Getpid()))
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+
+	err = writeRDB(file, entries)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, name)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+func writeRDB(file io.Writer, entries [][]database.Entry) error {
+	buf := bufio.NewWriter(file)
+	crc := crc64.New(crcTable)
+	w := &rdbWriter{w: io.MultiWriter(buf, crc)}
+
+	w.write([]byte(rdbMagic))
+	w.write(binary.BigEndian.AppendUint16(nil, rdbVersion))
+	w.aux("ctime", fmt.Sprint(time.Now().Unix()))
+
+	for db, dbEntries := range entries {
+		if len(dbEntries) == 0 {
+			continue
+		}
+		w.write([]byte{opSelectDB})
+		w.writeUvarint(uint64(db))
+		for _, entry := range dbEntries {
+			w.entry(entry)
+		}
+	}
+
+	w.write([]byte{opEOF})
+	if w.err != nil {
+		return w.err
+	}
+	if _, err := buf.Write(crc.Sum(nil)); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// LoadRDB restores a snapshot. The whole file is decoded and its checksum
+// verified before anything is applied, so a truncated or damaged file is
+// rejected instead of being half loaded. Keys that expired while the server
+// was down are skipped, and so is a file using more databases than are
+// configured.
+func (m *Manager) LoadRDB() error {
+	file, err := os.Open(m.rdbPath())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic, err := reader.Peek(len(rdbMagic))
+	if err != nil || string(magic) != rdbMagic {
+		return m.loadLegacyRDB(reader)
+	}
+
+	entries, err := readRDB(reader)
+	if err != nil {
+		return err
+	}
+
+	for db := range entries {
+		if db >= len(m.dbs) {
+			return fmt.Errorf("rdb: keys in database %d but only %d databases are configured", db, len(m.dbs))
+		}
+	}
+
+	now := time.Now()
+	for db, dbEntries := range entries {
+		for _, entry := range dbEntries {
+			if !entry.ExpireAt.IsZero() && !entry.ExpireAt.After(now) {
+				continue
+			}
+			m.dbs[db].Restore(entry.Key, entry.Value, entry.ExpireAt)
+		}
+	}
+	return nil
+}
+
+// readRDB decodes a snapshot and returns its entries per database.
+func readRDB(reader *bufio.Reader) (map[int][]database.Entry, error) {
+	r := &rdbReader{r: reader, crc: crc64.New(crcTable)}
+
+	header := make([]byte, len(rdbMagic)+2)
+	r.readFull(header)
+	if version := binary.BigEndian.Uint16(header[len(rdbMagic):]); r.err == nil && version > rdbVersion {
+		return nil, fmt.Errorf("rdb: unsupported version %d", version)
+	}
+
+	entries := make(map[int][]database.Entry)
+	db := 0
+	for r.err == nil {
+		op := r.readByte()
+		switch {
+		case r.err != nil:
+		case op == opEOF:
+			sum := r.crc.Sum64()
+			var stored [8]byte
+			if _, err := io.ReadFull(reader, stored[:]); err != nil {
+				return nil, fmt.Errorf("rdb: truncated checksum: %w", io.ErrUnexpectedEOF)
+			}
+			if binary.BigEndian.Uint64(stored[:]) != sum {
+				return nil, ErrRDBChecksum
+			}
+			return entries, nil
+		case op == opAux:
+			r.readString()
+			r.readString()
+		case op == opSelectDB:
+			n := r.readUvarint()
+			if n > math.MaxInt32 && r.err == nil {
+				r.err = fmt.Errorf("database number %d too large", n)
+			}
+			db = int(n)
+		case op == opEntry:
+			if entry, ok := r.entry(); ok {
+				entries[db] = append(entries[db], entry)
+			}
+		default:
+			return nil, fmt.Errorf("rdb: unknown opcode 0x%02x", op)
+		}
+	}
+
+	if r.err == io.EOF {
+		r.err = io.ErrUnexpectedEOF
+	}
+	return nil, fmt.Errorf("rdb: truncated file: %w", r.err)
+}
+
+// rdbWriter encodes snapshot records, remembering the first error.
+type rdbWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *rdbWriter) write(p []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+}
+
+func (w *rdbWriter) writeUvarint(n uint64) {
+	w.write(binary.AppendUvarint(nil, n))
+}
+
+// writeFloat writes the IEEE 754 bits of f, little endian.
+func (w *rdbWriter) writeFloat(f float64) {
+	w.write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(f)))
+}
+
+func (w *rdbWriter) writeString(s string) {
+	w.writeUvarint(uint64(len(s)))
+	w.write([]byte(s))
+}
+
+func (w *rdbWriter) aux(name, value string) {
+	w.write([]byte{opAux})
+	w.writeString(name)
+	w.writeString(value)
+}
+
+func (w *rdbWriter) entry(entry database.Entry) {
+	var expireMs int64
+	if !entry.ExpireAt.IsZero() {
+		expireMs = entry.ExpireAt.UnixMilli()
+	}
+
+	val := entry.Value
+	var typ byte
+	switch val.Type {
+	case database.StringType:
+		typ = rdbTypeString
+	case database.HashType:
+		typ = rdbTypeHash
+		if len(val.FieldExpiry) > 0 {
+			typ = rdbTypeHashTTL
+		}
+	case database.ListType:
+		typ = rdbTypeList
+	case database.SetType:
+		typ = rdbTypeSet
+	case database.ZSetType:
+		typ = rdbTypeZSet
+	default:
+		w.err = fmt.Errorf("rdb: cannot encode type %s", val.Type)
+		return
+	}
+
+	w.write([]byte{opEntry})
+	w.write(binary.AppendVarint(nil, expireMs))
+	w.write([]byte{typ})
+	w.writeString(entry.Key)
+
+	switch typ {
+	case rdbTypeString:
+		w.writeString(val.StrVal)
+	case rdbTypeHash:
+		w.writeUvarint(uint64(val.HashVal.Len()))
+		val.HashVal.Range(func(field, value string) bool {
+			w.writeString(field)
+			w.writeString(value)
+			return true
+		})
+	case rdbTypeHashTTL:
+		w.writeUvarint(uint64(val.HashVal.Len()))
+		val.HashVal.Range(func(field, value string) bool {
+			w.writeString(field)
+			w.writeString(value)
+			var ms int64
+			if at, ok := val.FieldExpiry[field]; ok {
+				ms = at.UnixMilli()
+			}
+			w.write(binary.AppendVarint(nil, ms))
+			return true
+		})
+	case rdbTypeList:
+		w.writeUvarint(uint64(val.ListVal.Len()))
+		for _, item := range val.ListVal.Items() {
+			w.writeString(item)
+		}
+	case rdbTypeSet:
+		w.writeUvarint(uint64(val.SetVal.Len()))
+		val.SetVal.Range(func(member string, _ struct{}) bool {
+			w.writeString(member)
+			return true
+		})
+	case rdbTypeZSet:
+		w.writeUvarint(uint64(val.ZSetVal.Len()))
+		for _, m := range val.ZSetVal.Members() {
+			w.writeString(m.Member)
+			w.writeFloat(m.Score)
+		}
+	}
+}
+
+// rdbReader decodes snapshot records and feeds every consumed byte to the
+// checksum. After the first error all reads return zero values.
+type rdbReader struct {
+	r   *bufio.Reader
+	crc hash.Hash64
+	err error
+}
+
+func (r *rdbReader) readByte() byte {
+	if r.err != nil {
+		return 0
+	}
+	b, err := r.r.ReadByte()
+	if err != nil {
+		r.err = err
+		return 0
+	}
+	r.crc.Write([]byte{b})
+	return b
+}
+
+func (r *rdbReader) readFull(p []byte) {
+	if r.err != nil {
+		return
+	}
+	if _, err := io.ReadFull(r.r, p); err != nil {
+		r.err = io.ErrUnexpectedEOF
+		return
+	}
+	r.crc.Write(p)
+}
+
+func (r *rdbReader) readUvarint() uint64 {
+	n, err := binary.ReadUvarint(byteReader{r})
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return n
+}
+
+func (r *rdbReader) readVarint() int64 {
+	n, err := binary.ReadVarint(byteReader{r})
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return n
+}
+
+func (r *rdbReader) readFloat() float64 {
+	var p [8]byte
+	r.readFull(p[:])
+	return math.Float64frombits(binary.LittleEndian.Uint64(p[:]))
+}
+
+func (r *rdbReader) readString() string {
+	n := r.readUvarint()
+	if r.err != nil {
+		return ""
+	}
+	if n > rdbMaxStringLen {
+		r.err = fmt.Errorf("string length %d too large", n)
+		return ""
+	}
+	p := make([]byte, n)
+	r.readFull(p)
+	return string(p)
+}
+
+func (r *rdbReader) entry() (database.Entry, bool) {
+	expireMs := r.readVarint()
+	typ := r.readByte()
+	key := r.readString()
+
+	val := &database.Value{}
+	switch typ {
+	case rdbTypeString:
+		val.Type = database.StringType
+		val.StrVal = r.readString()
+	case rdbTypeHash:
+		val.Type = database.HashType
+		n := r.readUvarint()
+		val.HashVal = database.NewDict[string]()
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			field := r.readString()
+			val.HashVal.Set(field, r.readString())
+		}
+	case rdbTypeHashTTL:
+		val.Type = database.HashType
+		n := r.readUvarint()
+		val.HashVal = database.NewDict[string]()
+		val.FieldExpiry = make(map[string]time.Time)
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			field := r.readString()
+			val.HashVal.Set(field, r.readString())
+			if ms := r.readVarint(); ms != 0 {
+				val.FieldExpiry[field] = time.UnixMilli(ms)
+			}
+		}
+	case rdbTypeList:
+		val.Type = database.ListType
+		n := r.readUvarint()
+		val.ListVal = database.NewList()
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			val.ListVal.PushBack(r.readString())
+		}
+	case rdbTypeSet:
+		val.Type = database.SetType
+		n := r.readUvarint()
+		val.SetVal = database.NewDict[struct{}]()
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			val.SetVal.Set(r.readString(), struct{}{})
+		}
+	case rdbTypeZSet:
+		val.Type = database.ZSetType
+		n := r.readUvarint()
+		val.ZSetVal = database.NewZSet()
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			member := r.readString()
+			score := r.readFloat()
+			if math.IsNaN(score) && r.err == nil {
+				r.err = fmt.Errorf("NaN score for member of %q", key)
+			}
+			val.ZSetVal.Add(member, score)
+		}
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("unknown value type %d", typ)
+		}
+	}
+	if r.err != nil {
+		return database.Entry{}, false
+	}
+
+	entry := database.Entry{Key: key, Value: val}
+	if expireMs != 0 {
+		entry.ExpireAt = time.UnixMilli(expireMs)
+	}
+	return entry, true
+}
+
+// byteReader adapts rdbReader to io.ByteReader for the varint decoders.
+type byteReader struct {
+	r *rdbReader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	c := b.r.readByte()
+	return c, b.r.err
+}
+
+// loadLegacyRDB reads snapshots written by older versions, which were two
+// gob encoded maps holding only string values.
+func (m *Manager) loadLegacyRDB(reader io.Reader) error {
+	decoder := gob.NewDecoder(reader)
+
+	var metadata map[string]interface{}
+	if err := decoder.Decode(&metadata); err != nil {
+		return fmt.Errorf("rdb: unrecognized file format: %w", err)
+	}
+
+	var data map[string]interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return fmt.Errorf("rdb: unrecognized file format: %w", err)
+	}
+
+	for key, value := range data {
+		if strVal, ok := value.(string); ok {
+			m.dbs[0].Restore(key, &database.Value{Type: database.StringType, StrVal: strVal}, time.Time{})
+		}
+	}
+	return nil
+}
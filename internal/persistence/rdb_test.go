@@ -0,0 +1,236 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-clone/internal/database"
+)
+
+// newRDBManager returns a manager without AOF over n fresh databases,
+// keeping its files in dir.
+func newRDBManager(dir string, n int) *Manager {
+	dbs := make([]*database.Database, n)
+	for i := range dbs {
+		dbs[i] = database.NewDatabase(i)
+	}
+	m := NewManager(dbs, false)
+	m.SetFiles(dir, "dump.rdb", "appendonly.aof")
+	return m
+}
+
+// dumpDatabase describes every key of db, whatever the order of its
+// collections.
+func dumpDatabase(db *database.Database) map[string]string {
+	dump := make(map[string]string)
+	for _, entry := range db.Snapshot() {
+		val := entry.Value
+		var items []string
+		switch val.Type {
+		case database.StringType:
+			items = []string{val.StrVal}
+		case database.HashType:
+			val.HashVal.Range(func(field, value string) bool {
+				item := field + "=" + value
+				if at, ok := val.FieldExpiry[field]; ok {
+					item += fmt.Sprintf("@%d", at.UnixMilli())
+				}
+				items = append(items, item)
+				return true
+			})
+			sort.Strings(items)
+		case database.ListType:
+			items = val.ListVal.Items()
+		case database.SetType:
+			items = val.SetVal.Keys()
+			sort.Strings(items)
+		case database.ZSetType:
+			for _, m := range val.ZSetVal.Members() {
+				items = append(items, fmt.Sprintf("%s:%g", m.Member, m.Score))
+			}
+		}
+		var expire int64
+		if !entry.ExpireAt.IsZero() {
+			expire = entry.ExpireAt.UnixMilli()
+		}
+		dump[entry.Key] = fmt.Sprintf("%s %q @%d", val.Type, items, expire)
+	}
+	return dump
+}
+
+// fillDatabases stores a key of every type in the databases of m.
+func fillDatabases(t *testing.T, m *Manager) {
+	t.Helper()
+	at := time.Now().Add(time.Hour)
+	db := m.dbs[0]
+	db.Set("string", "binary\x00value\r\n")
+	db.ExpireAt("string", at, database.ExpireAlways)
+	if _, err := db.HSet("hash", []string{"a", "1", "b", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HExpire("hash", at, database.ExpireAlways, []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Push("list", []string{"x", "y", "z"}, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SAdd("set", []string{"m1", "m2", "m3"}); err != nil {
+		t.Fatal(err)
+	}
+	members := []database.ZMember{{Member: "a", Score: 1.5}, {Member: "b", Score: -2}}
+	if _, err := db.ZAdd("zset", database.ZAddFlags{}, members); err != nil {
+		t.Fatal(err)
+	}
+	m.dbs[2].Set("other", "in database 2")
+}
+
+func TestRDBRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	m := newRDBManager(dir, 3)
+	fillDatabases(t, m)
+	if err := m.SaveRDB(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newRDBManager(dir, 3)
+	if err := loaded.LoadRDB(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range m.dbs {
+		want, got := dumpDatabase(m.dbs[i]), dumpDatabase(loaded.dbs[i])
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("database %d loaded as %v, want %v", i, got, want)
+		}
+	}
+
+	// A snapshot using more databases than configured is refused
+	if err := newRDBManager(dir, 2).LoadRDB(); err == nil {
+		t.Error("snapshot with keys in database 2 loaded into 2 databases")
+	}
+}
+
+func TestRDBRejectsDamagedFile(t *testing.T) {
+	dir := t.TempDir()
+	m := newRDBManager(dir, 3)
+	fillDatabases(t, m)
+	if err := m.SaveRDB(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(m.rdbPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := bytes.Clone(data)
+	flipped[bytes.Index(flipped, []byte("in database 2"))] ^= 0x20
+	newerVersion := bytes.Clone(data)
+	binary.BigEndian.PutUint16(newerVersion[len(rdbMagic):], rdbVersion+1)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"flipped byte", flipped, ErrRDBChecksum.Error()},
+		{"truncated", data[:len(data)-20], "truncated"},
+		{"newer version", newerVersion, "unsupported version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(m.rdbPath(), tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			loaded := newRDBManager(dir, 3)
+			err := loaded.LoadRDB()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("LoadRDB returned %v, want %q", err, tt.want)
+			}
+			// Nothing is applied from a damaged file
+			for i, db := range loaded.dbs {
+				if db.Size() != 0 {
+					t.Errorf("database %d has %d keys", i, db.Size())
+				}
+			}
+		})
+	}
+}
+
+// waitBgsave waits for the background save in progress to finish.
+func waitBgsave(t *testing.T, m *Manager) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.RDBStatus().BgsaveInProgress {
+		if time.Now().After(deadline) {
+			t.Fatal("background save did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSaveRules(t *testing.T) {
+	dir := t.TempDir()
+	m := newRDBManager(dir, 1)
+	db := m.dbs[0]
+	rules := []SaveRule{{Seconds: 3600, Changes: 1}, {Seconds: 0, Changes: 3}}
+
+	db.Set("a", "1")
+	db.Set("b", "2")
+	if m.SaveRulesDue(rules) {
+		t.Error("save due after 2 changes, within the hour")
+	}
+	db.Set("c", "3")
+	if !m.SaveRulesDue(rules) {
+		t.Error("save not due after 3 changes")
+	}
+	if m.SaveRulesDue(nil) {
+		t.Error("save due without any rule")
+	}
+
+	// The background save cannot write the file before the lock is released
+	before := m.LastSave()
+	m.rdbWriteMu.Lock()
+	if err := m.BackgroundSave(); err != nil {
+		t.Fatal(err)
+	}
+	if m.SaveRulesDue(rules) {
+		t.Error("save due while a background save runs")
+	}
+	m.rdbWriteMu.Unlock()
+	waitBgsave(t, m)
+	status := m.RDBStatus()
+	if status.LastError != nil || status.ChangesSinceSave != 0 || !status.LastSave.After(before) {
+		t.Fatalf("after the background save: %+v", status)
+	}
+	if m.SaveRulesDue(rules) {
+		t.Error("save due without changes since the last save")
+	}
+
+	// A single change is enough once the hour elapsed
+	db.Set("a", "4")
+	m.rdbMu.Lock()
+	m.lastSave = time.Now().Add(-2 * time.Hour)
+	m.rdbMu.Unlock()
+	if !m.SaveRulesDue(rules) {
+		t.Error("save not due after 1 change and 2 hours")
+	}
+
+	// A failed save is not retried at once
+	m.SetFiles(dir+"/missing", "dump.rdb", "appendonly.aof")
+	if err := m.BackgroundSave(); err != nil {
+		t.Fatal(err)
+	}
+	waitBgsave(t, m)
+	if m.RDBStatus().LastError == nil {
+		t.Fatal("saving into a missing directory succeeded")
+	}
+	if m.SaveRulesDue(rules) {
+		t.Error("save due right after a failed one")
+	}
+}
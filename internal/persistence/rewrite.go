@@ -0,0 +1,234 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/logger"
+	"redis-clone/internal/protocol"
+)
+
+var ErrRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+// rewriteItemsPerCommand caps the number of elements of a collection written
+// by a single command of the rewritten AOF, as Redis does.
+const rewriteItemsPerCommand = 64
+
+// StartRewriteAOF begins a background rewrite of the AOF from the current
+// dataset. The caller must make sure no write command is half way between
+// changing the database and calling WriteAOF, otherwise that command would
+// end up both in the snapshot and in the rewrite buffer, and the databases
+// would be copied at different points in time, see snapshot.
+//
+// Commands logged while the rewrite runs are kept in a buffer and appended
+// to the new file before it atomically replaces the old one.
+func (m *Manager) StartRewriteAOF() error {
+	m.aofMu.Lock()
+	defer m.aofMu.Unlock()
+
+	if m.rewriting {
+		return ErrRewriteInProgress
+	}
+
+	_, entries := m.snapshot()
+	m.rewriting = true
+	m.rewriteBuf = nil
+	// The buffer is appended after the snapshot, whatever database it ends
+	// with: make it start with a SELECT
+	m.aofSelectedDB = -1
+
+	go m.rewriteAOF(entries)
+	return nil
+}
+
+// AOFRewriteNeeded reports whether the AOF grew by more than percentage
+// since the last rewrite and is at least minSize bytes long.
+func (m *Manager) AOFRewriteNeeded(percentage int, minSize int64) bool {
+	m.aofMu.Lock()
+	defer m.aofMu.Unlock()
+
+	if !m.aofEnabled || m.rewriting || percentage <= 0 || m.aofSize < minSize {
+		return false
+	}
+
+	base := m.aofBaseSize
+	if base == 0 {
+		base = 1
+	}
+	growth := (m.aofSize - base) * 100 / base
+	return growth >= int64(percentage)
+}
+
+func (m *Manager) rewriteAOF(entries [][]database.Entry) {
+	start := time.Now()
+	tmpName := filepath.Join(m.dir, fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid()))
+
+	err := writeAOFSnapshot(tmpName, entries)
+	if err == nil {
+		err = m.finishRewrite(tmpName)
+	}
+
+	m.aofMu.Lock()
+	defer m.aofMu.Unlock()
+
+	if err != nil {
+		os.Remove(tmpName)
+		logger.Warningf("Background AOF rewrite failed: %v", err)
+	} else {
+		keys := 0
+		for _, dbEntries := range entries {
+			keys += len(dbEntries)
+		}
+		logger.Noticef("Background AOF rewrite finished (%d keys)", keys)
+	}
+	m.rewriting = false
+	m.rewriteBuf = nil
+	m.lastRewriteErr = err
+	m.lastRewriteTook = time.Since(start)
+}
+
+// writeAOFSnapshot writes the minimal set of commands that rebuild entries,
+// given per database, each non-empty database starting with a SELECT.
+func writeAOFSnapshot(name string, entries [][]database.Entry) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for db, dbEntries := range entries {
+		if len(dbEntries) == 0 {
+			continue
+		}
+		if _, err = writer.Write(protocol.Serialize(selectCommand(db))); err != nil {
+			break
+		}
+		for _, entry := range dbEntries {
+			for _, args := range rewriteCommands(entry) {
+				if _, err = writer.Write(protocol.Serialize(protocol.NewBulkArray(args))); err != nil {
+					break
+				}
+			}
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// finishRewrite appends the commands buffered during the rewrite to the new
+// file, then swaps it in place of the live AOF.
+func (m *Manager) finishRewrite(tmpName string) error {
+	m.aofMu.Lock()
+	defer m.aofMu.Unlock()
+
+	file, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(m.rewriteBuf); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if m.aofWriter != nil {
+		m.aofWriter.Flush()
+	}
+	if err := os.Rename(tmpName, m.aofPath()); err != nil {
+		file.Close()
+		return err
+	}
+	if m.aofFile != nil {
+		m.aofFile.Close()
+	}
+
+	m.aofFile = file
+	m.aofWriter = bufio.NewWriter(file)
+	m.aofSize = info.Size()
+	m.aofBaseSize = info.Size()
+	m.pendingSince = time.Time{}
+	m.lastFsync = time.Now()
+	// The buffer had the data of a failed write, which is now on disk
+	m.writeErr = nil
+	m.retryBuf = nil
+	m.aofTorn = false
+	m.fsyncErr = nil
+	return nil
+}
+
+// rewriteCommands returns the commands that recreate a single key.
+func rewriteCommands(entry database.Entry) [][]string {
+	var commands [][]string
+
+	switch entry.Value.Type {
+	case database.StringType:
+		commands = append(commands, []string{"SET", entry.Key, entry.Value.StrVal})
+	case database.HashType:
+		args := []string{"HSET", entry.Key}
+		entry.Value.HashVal.Range(func(field, value string) bool {
+			args = append(args, field, value)
+			if len(args) == 2+2*rewriteItemsPerCommand {
+				commands = append(commands, args)
+				args = []string{"HSET", entry.Key}
+			}
+			return true
+		})
+		if len(args) > 2 {
+			commands = append(commands, args)
+		}
+		for field, at := range entry.Value.FieldExpiry {
+			ms := strconv.FormatInt(at.UnixMilli(), 10)
+			commands = append(commands, []string{"HPEXPIREAT", entry.Key, ms, "FIELDS", "1", field})
+		}
+	case database.ListType:
+		items := entry.Value.ListVal.Items()
+		for len(items) > 0 {
+			n := min(len(items), rewriteItemsPerCommand)
+			commands = append(commands, append([]string{"RPUSH", entry.Key}, items[:n]...))
+			items = items[n:]
+		}
+	case database.SetType:
+		members := entry.Value.SetVal.Keys()
+		for len(members) > 0 {
+			n := min(len(members), rewriteItemsPerCommand)
+			commands = append(commands, append([]string{"SADD", entry.Key}, members[:n]...))
+			members = members[n:]
+		}
+	case database.ZSetType:
+		members := entry.Value.ZSetVal.Members()
+		for len(members) > 0 {
+			n := min(len(members), rewriteItemsPerCommand)
+			args := []string{"ZADD", entry.Key}
+			for _, m := range members[:n] {
+				args = append(args, strconv.FormatFloat(m.Score, 'g', -1, 64), m.Member)
+			}
+			commands = append(commands, args)
+			members = members[n:]
+		}
+	}
+
+	if len(commands) > 0 && !entry.ExpireAt.IsZero() {
+		at := strconv.FormatInt(entry.ExpireAt.UnixMilli(), 10)
+		commands = append(commands, []string{"PEXPIREAT", entry.Key, at})
+	}
+	return commands
+}
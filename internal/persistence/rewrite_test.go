@@ -0,0 +1,98 @@
+package persistence
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitRewrite waits for the AOF rewrite in progress to finish.
+func waitRewrite(t *testing.T, m *Manager) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for m.AOFStatus().RewriteInProgress {
+		if time.Now().After(deadline) {
+			t.Fatal("AOF rewrite did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRewriteAOF(t *testing.T) {
+	m := newTestManager(t)
+	db := m.dbs[0]
+	for i := 1; i <= 100; i++ {
+		value := strconv.Itoa(i)
+		db.Set("counter", value)
+		if err := m.WriteAOF(0, []string{"SET", "counter", value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := m.AOFStatus().CurrentSize
+
+	// The background fsync runs throughout: the file it syncs may be
+	// swapped under it, which is no error
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				m.aofMu.Lock()
+				m.markPending()
+				m.aofMu.Unlock()
+				m.fsyncPending()
+			}
+		}
+	}()
+
+	if err := m.StartRewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.StartRewriteAOF(); err != ErrRewriteInProgress {
+		t.Errorf("second rewrite returned %v, want ErrRewriteInProgress", err)
+	}
+	// Logged while the rewrite runs, after its snapshot was taken
+	db.Set("name", "after")
+	if err := m.WriteAOF(0, []string{"SET", "name", "after"}); err != nil {
+		t.Fatal(err)
+	}
+	waitRewrite(t, m)
+	close(stop)
+	<-done
+
+	status := m.AOFStatus()
+	if status.LastRewriteError != nil {
+		t.Fatal(status.LastRewriteError)
+	}
+	if status.LastError != nil {
+		t.Errorf("fsync error %v while the rewrite swapped the file", status.LastError)
+	}
+	if status.CurrentSize >= before || status.BaseSize != status.CurrentSize {
+		t.Errorf("size %d (base %d) after rewriting %d bytes", status.CurrentSize, status.BaseSize, before)
+	}
+
+	// Writes after the swap go to the new file
+	if err := m.WriteAOF(0, []string{"DEL", "name"}); err != nil {
+		t.Fatal(err)
+	}
+	var replayed [][]string
+	if err := m.LoadAOF(recordReplay(&replayed)); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"SELECT", "0"},
+		{"SET", "counter", "100"},
+		// The buffer starts over with a SELECT
+		{"SELECT", "0"},
+		{"SET", "name", "after"},
+		{"DEL", "name"},
+	}
+	if !reflect.DeepEqual(replayed, want) {
+		t.Errorf("rewritten AOF replays %q, want %q", replayed, want)
+	}
+}
@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Reader decodes RESP values from a stream. Unlike RESPParser it reads bulk
+// strings by length, so values may contain CR, LF or any other byte.
+type Reader struct {
+	rd     *bufio.Reader
+	offset int64
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{rd: bufio.NewReader(r)}
+}
+
+// Offset returns the number of bytes consumed so far.
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// ReadValue reads the next complete value. It returns io.EOF when the stream
+// ends cleanly between values and io.ErrUnexpectedEOF when it ends in the
+// middle of one.
+func (r *Reader) ReadValue() (*RESPValue, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	return r.readValue(line)
+}
+
+func (r *Reader) readValue(line string) (*RESPValue, error) {
+	if len(line) == 0 {
+		return nil, errors.New("protocol error: empty line")
+	}
+
+	switch RESPType(line[0]) {
+	case SimpleString, Error:
+		return &RESPValue{
+			Type: RESPType(line[0]),
+			Str:  line[1:],
+		}, nil
+	case Integer:
+		num, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("protocol error: invalid integer '%s'", line[1:])
+		}
+		return &RESPValue{
+			Type: Integer,
+			Num:  num,
+		}, nil
+	case BulkString:
+		return r.readBulkString(line)
+	case Array:
+		return r.readArray(line)
+	default:
+		return nil, fmt.Errorf("protocol error: unknown type '%c'", line[0])
+	}
+}
+
+func (r *Reader) readBulkString(line string) (*RESPValue, error) {
+	length, err := strconv.Atoi(line[1:])
+	if err != nil || length < -1 {
+		return nil, fmt.Errorf("protocol error: invalid bulk length '%s'", line[1:])
+	}
+
+	if length == -1 {
+		return &RESPValue{
+			Type: BulkString,
+			Null: true,
+		}, nil
+	}
+
+	data := make([]byte, length+2)
+	n, err := io.ReadFull(r.rd, data)
+	r.offset += int64(n)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if data[length] != '\r' || data[length+1] != '\n' {
+		return nil, errors.New("protocol error: bulk string not terminated by CRLF")
+	}
+
+	return &RESPValue{
+		Type: BulkString,
+		Str:  string(data[:length]),
+	}, nil
+}
+
+func (r *Reader) readArray(line string) (*RESPValue, error) {
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count < -1 {
+		return nil, fmt.Errorf("protocol error: invalid array length '%s'", line[1:])
+	}
+
+	if count == -1 {
+		return &RESPValue{
+			Type: Array,
+			Null: true,
+		}, nil
+	}
+
+	array := make([]*RESPValue, count)
+	for i := range array {
+		line, err := r.readLine()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if array[i], err = r.readValue(line); err != nil {
+			return nil, err
+		}
+	}
+
+	return &RESPValue{
+		Type:  Array,
+		Array: array,
+	}, nil
+}
+
+// readLine reads a CRLF terminated line and returns it without the
+// terminator.
+func (r *Reader) readLine() (string, error) {
+	line, err := r.rd.ReadString('\n')
+	r.offset += int64(len(line))
+	if err == io.EOF && len(line) > 0 {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("protocol error: line not terminated by CRLF")
+	}
+	return line[:len(line)-2], nil
+}
@@ -130,6 +130,26 @@ func (p *RESPParser) parseBulkString(lines []string, index int) (*RESPValue, int
 }
 
 func Serialize(value *RESPValue) []byte {
+	return appendValue(nil, value)
+}
+
+func appendValue(buf []byte, value *RESPValue) []byte {
+	switch value.Type {
+	case Array:
+		if value.Null {
+			return append(buf, "*-1\r\n"...)
+		}
+		buf = append(buf, fmt.Sprintf("*%d\r\n", len(value.Array))...)
+		for _, item := range value.Array {
+			buf = appendValue(buf, item)
+		}
+		return buf
+	default:
+		return append(buf, serializeScalar(value)...)
+	}
+}
+
+func serializeScalar(value *RESPValue) []byte {
 	switch value.Type {
 	case SimpleString:
 		return []byte(fmt.Sprintf("+%s\r\n", value.Str))
@@ -142,13 +162,23 @@ func Serialize(value *RESPValue) []byte {
 			return []byte("$-1\r\n")
 		}
 		return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(value.Str), value.Str))
-	case Array:
-		result := fmt.Sprintf("*%d\r\n", len(value.Array))
-		for _, item := range value.Array {
-			result += string(Serialize(item))
-		}
-		return []byte(result)
 	default:
 		return []byte("-ERR unknown type\r\n")
 	}
 }
+
+// NewBulkArray builds an array of bulk strings, the encoding used for
+// commands sent by clients and stored in the AOF.
+func NewBulkArray(items []string) *RESPValue {
+	array := make([]*RESPValue, len(items))
+	for i, item := range items {
+		array[i] = &RESPValue{
+			Type: BulkString,
+			Str:  item,
+		}
+	}
+	return &RESPValue{
+		Type:  Array,
+		Array: array,
+	}
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-clone/internal/protocol"
+)
+
+// newTestServer returns a server keeping its files in a temporary
+// directory.
+func newTestServer(t *testing.T, overrides map[string]string) *Server {
+	t.Helper()
+	if overrides == nil {
+		overrides = make(map[string]string)
+	}
+	overrides["dir"] = t.TempDir()
+	s, err := NewServer("", overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// newTestClient returns a client of s whose commands are run directly with
+// do, without going through a connection.
+func newTestClient(t *testing.T, s *Server) *Client {
+	t.Helper()
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	client := NewClient(conn, s)
+	client.authenticated = true
+	return client
+}
+
+// do runs a command for client and returns its reply.
+func (s *Server) do(client *Client, args ...string) *protocol.RESPValue {
+	return s.executeCommand(client, protocol.NewBulkArray(args))
+}
+
+func TestMigrateLegacyAOF(t *testing.T) {
+	s := newTestServer(t, map[string]string{"appendonly": "yes", "save": `""`})
+	path := filepath.Join(s.cfg().Dir, s.cfg().AppendFilename)
+	legacy := "SET counter 42\nEXPIRE counter 100\nSET greeting Hello World\nHSET user name Alice\n"
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	if err := s.loadData(); err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Split(string(data), "\r\n")
+	var at int64
+	for i, field := range fields {
+		if field == "EXPIRE" {
+			t.Fatalf("relative EXPIRE kept in the converted file:\n%q", data)
+		}
+		if field == "PEXPIREAT" && i+4 < len(fields) {
+			at, _ = strconv.ParseInt(fields[i+4], 10, 64)
+		}
+	}
+	min := before.Add(100 * time.Second).UnixMilli()
+	max := after.Add(100 * time.Second).UnixMilli()
+	if at < min || at > max {
+		t.Fatalf("PEXPIREAT timestamp %d, want between %d and %d:\n%q", at, min, max, data)
+	}
+
+	// The value containing a space is ambiguous and dropped
+	db := s.dbs[0]
+	if db.Exists("greeting") {
+		t.Error("ambiguous SET was replayed")
+	}
+	if value, _ := db.Get("counter"); value != "42" {
+		t.Errorf("counter = %q, want 42", value)
+	}
+	if strings.Contains(string(data), "greeting") {
+		t.Errorf("ambiguous SET kept in the converted file:\n%q", data)
+	}
+	if !strings.Contains(string(data), "Alice") {
+		t.Errorf("HSET missing from the converted file:\n%q", data)
+	}
+}
+
+func TestStartRefusesCorruptAOF(t *testing.T) {
+	s := newTestServer(t, map[string]string{"appendonly": "yes", "port": "0"})
+	dir := s.cfg().Dir
+	aof := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n+garbage\r\n*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n2\r\n"
+	if err := os.WriteFile(filepath.Join(dir, s.cfg().AppendFilename), []byte(aof), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(dir, s.cfg().DBFilename)
+	if err := os.WriteFile(snapshot, []byte("previous snapshot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+	select {
+	case err := <-started:
+		if err == nil || !strings.Contains(err.Error(), "corrupt command #2") {
+			t.Fatalf("Start returned %v, want the corrupt command", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start served a partially loaded AOF")
+	}
+	if len(s.listeners) != 0 {
+		t.Error("Start listened despite the load error")
+	}
+
+	// The partial dataset must not replace the snapshot either
+	s.Shutdown()
+	if data, _ := os.ReadFile(snapshot); string(data) != "previous snapshot" {
+		t.Errorf("snapshot overwritten on shutdown: %q", data)
+	}
+}
+
+func TestNoOpWritesNotLogged(t *testing.T) {
+	s := newTestServer(t, map[string]string{"appendonly": "yes", "save": `""`})
+	t.Cleanup(s.persistence.Close)
+	client := newTestClient(t, s)
+	for _, command := range [][]string{
+		{"RPUSH", "list", "a"},
+		{"SADD", "set", "m"},
+		{"HSET", "hash", "f", "v"},
+		{"ZADD", "zset", "1", "m"},
+	} {
+		if reply := s.do(client, command...); reply.Type == protocol.Error {
+			t.Fatalf("%q: %s", command, reply.Str)
+		}
+	}
+	size := s.persistence.AOFStatus().CurrentSize
+
+	for _, command := range [][]string{
+		{"DEL", "missing"},
+		{"UNLINK", "missing"},
+		{"LPOP", "missing"},
+		{"RPOP", "missing", "2"},
+		{"LMOVE", "missing", "list", "LEFT", "RIGHT"},
+		{"LINSERT", "list", "BEFORE", "pivot", "x"},
+		{"LINSERT", "missing", "BEFORE", "pivot", "x"},
+		{"LREM", "list", "0", "x"},
+		{"RPUSHX", "missing", "x"},
+		{"SADD", "set", "m"},
+		{"SREM", "set", "x"},
+		{"SMOVE", "set", "other", "x"},
+		{"HDEL", "hash", "x"},
+		{"HSETNX", "hash", "f", "w"},
+		{"HPERSIST", "hash", "FIELDS", "1", "f"},
+		{"HEXPIRE", "hash", "100", "FIELDS", "1", "x"},
+		{"ZREM", "zset", "x"},
+		{"ZREMRANGEBYSCORE", "zset", "5", "10"},
+		{"ZPOPMIN", "missing"},
+		{"ZADD", "zset", "NX", "INCR", "1", "m"},
+	} {
+		if reply := s.do(client, command...); reply.Type == protocol.Error {
+			t.Fatalf("%q: %s", command, reply.Str)
+		}
+		if now := s.persistence.AOFStatus().CurrentSize; now != size {
+			t.Errorf("%q changed nothing but was logged", command)
+			size = now
+		}
+	}
+
+	s.do(client, "LPOP", "list")
+	if s.persistence.AOFStatus().CurrentSize == size {
+		t.Error("LPOP of an element was not logged")
+	}
+}
+
+func TestWritesRefusedOnAOFError(t *testing.T) {
+	s := newTestServer(t, map[string]string{"appendonly": "yes", "save": `""`})
+	client := newTestClient(t, s)
+	// A directory in place of the AOF makes every write fail
+	path := filepath.Join(s.cfg().Dir, s.cfg().AppendFilename)
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply := s.do(client, "SET", "a", "1"); !strings.HasPrefix(reply.Str, "MISCONF ") {
+		t.Fatalf("SET replied %+v, want a MISCONF error", reply)
+	}
+	if reply := s.do(client, "SET", "b", "2"); !strings.HasPrefix(reply.Str, "MISCONF ") {
+		t.Fatalf("SET replied %+v while the AOF is failing", reply)
+	}
+	if reply := s.do(client, "GET", "b"); !reply.Null {
+		t.Errorf("refused SET was applied: GET replied %+v", reply)
+	}
+	if info := s.do(client, "INFO", "persistence"); !strings.Contains(info.Str, "aof_last_write_status:err") {
+		t.Errorf("INFO does not report the write error:\n%s", info.Str)
+	}
+
+	// The background sync writes the failed command once the disk is back
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	s.persistence.StartAOFSync()
+	defer s.persistence.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.persistence.AOFError() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("AOF error not recovered")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if reply := s.do(client, "SET", "c", "3"); reply.Str != "OK" {
+		t.Fatalf("SET replied %+v after the AOF recovered", reply)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "$1\r\na\r\n") || strings.Contains(string(data), "$1\r\nb\r\n") || !strings.Contains(string(data), "$1\r\nc\r\n") {
+		t.Errorf("AOF holds %q, want the writes to a and c", data)
+	}
+}
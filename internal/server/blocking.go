@@ -0,0 +1,373 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/protocol"
+)
+
+// BLPOP, BRPOP, BLMOVE and BLMPOP first run like their non-blocking forms.
+// When every list is empty, the client is queued on each of its keys and
+// waits, holding no lock, until a list is stored at one of them. The write
+// command storing it serves the queued clients, oldest first, before it
+// replies, as Redis does. While clients are blocked, writes hold the write
+// lock exclusively so that no other command can pop from the list before
+// they are served.
+
+// blockedKey is a key some clients are blocked on.
+type blockedKey struct {
+	db  int
+	key string
+}
+
+// blockedClient is a client waiting in a blocking command.
+type blockedClient struct {
+	client  *Client
+	command string
+	args    []string
+	keys    []blockedKey
+	timeout *protocol.RESPValue      // reply when the timeout expires
+	reply   chan *protocol.RESPValue // receives the reply once served
+}
+
+// blocking holds the blocked clients.
+type blocking struct {
+	mu      sync.Mutex
+	keys    map[blockedKey][]*blockedClient // clients blocked on each key, oldest first
+	clients map[int64]*blockedClient        // by client id
+
+	// waiting counts the blocked clients. It is read without mu by keyReady,
+	// which may not take it, and by lockWrite. It only grows under the
+	// exclusive write lock.
+	waiting atomic.Int64
+
+	readyMu sync.Mutex
+	ready   []blockedKey // keys that got a list since the last serveReady
+}
+
+func newBlocking() blocking {
+	return blocking{
+		keys:    make(map[blockedKey][]*blockedClient),
+		clients: make(map[int64]*blockedClient),
+	}
+}
+
+// timeoutArg returns the timeout argument of a blocking command.
+func timeoutArg(command string, args []string) string {
+	switch command {
+	case "BLMOVE":
+		return args[4]
+	case "BLMPOP":
+		return args[0]
+	}
+	return args[len(args)-1]
+}
+
+// blockingKeys returns the keys a blocking command waits on, once its
+// arguments are known to be valid.
+func blockingKeys(command string, args []string) []string {
+	switch command {
+	case "BLMOVE":
+		return args[:1]
+	case "BLMPOP":
+		n, _ := parseInt(args[1])
+		return args[2 : 2+n]
+	}
+	return args[:len(args)-1]
+}
+
+// parseTimeout parses the timeout of a blocking command, in seconds. Zero
+// means waiting forever.
+func parseTimeout(arg string) (time.Duration, *protocol.RESPValue) {
+	f, ok := parseFloat(arg)
+	if !ok || math.IsInf(f, 0) || f*float64(time.Second) > math.MaxInt64 {
+		return 0, errorReply("ERR timeout is not a float or out of range")
+	}
+	if f < 0 {
+		return 0, errorReply("ERR timeout is negative")
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
+
+// handleBlocking runs a blocking command for client, waiting for a list if
+// needed. Commands queued in a transaction or replayed from the AOF go
+// straight to dispatch instead, and never wait.
+func (s *Server) handleBlocking(client *Client, command string, args []string) *protocol.RESPValue {
+	if err := checkCommand(command, args); err != nil {
+		return err
+	}
+	timeout, errReply := parseTimeout(timeoutArg(command, args))
+	if errReply != nil {
+		return errReply
+	}
+
+	w, response := s.tryBlocking(client, command, args)
+	if w == nil {
+		return response
+	}
+	return s.waitBlocked(w, timeout)
+}
+
+// tryBlocking runs the command once and returns its reply. When there was
+// nothing to pop, the client is queued on its keys in the same critical
+// section, so that no list stored in between goes unnoticed, and returned
+// instead. It holds the write lock exclusively: no write is in flight while
+// the client queues, and the clients it stores a list for can be served.
+func (s *Server) tryBlocking(client *Client, command string, args []string) (*blockedClient, *protocol.RESPValue) {
+	s.execMu.RLock()
+	defer s.execMu.RUnlock()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if errReply := s.checkWrite(command); errReply != nil {
+		return nil, errReply
+	}
+	if commandTable[command].flags&flagDenyOOM != 0 && !s.freeMemoryIfNeeded() {
+		return nil, errorReply(errOOM)
+	}
+
+	var keys []blockedKey
+	for _, key := range blockingKeys(command, args) {
+		if k := (blockedKey{client.db, key}); !containsBlockedKey(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	// The clients already queued on these keys came first: they get
+	// whatever the lists hold before this one may pop
+	s.blocking.mu.Lock()
+	for _, k := range keys {
+		if len(s.blocking.keys[k]) > 0 {
+			if typ, _ := s.dbs[k.db].Type(k.key); typ == database.ListType {
+				s.keyReady(k.db, k.key)
+			}
+		}
+	}
+	s.blocking.mu.Unlock()
+	s.serveReady()
+
+	response := s.dispatch(client, command, args)
+	if !response.Null {
+		response = s.logWrite(client.db, command, args, response)
+		// BLMOVE may have stored a list
+		s.serveReady()
+		return nil, response
+	}
+
+	s.blocking.mu.Lock()
+	defer s.blocking.mu.Unlock()
+	w := &blockedClient{
+		client:  client,
+		command: command,
+		args:    args,
+		keys:    keys,
+		timeout: response,
+		reply:   make(chan *protocol.RESPValue, 1),
+	}
+	for _, k := range keys {
+		s.blocking.keys[k] = append(s.blocking.keys[k], w)
+	}
+	s.blocking.clients[client.id] = w
+	s.blocking.waiting.Add(1)
+	return w, nil
+}
+
+func containsBlockedKey(keys []blockedKey, k blockedKey) bool {
+	for _, key := range keys {
+		if key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// waitBlocked waits until w is served, unblocked, or times out, or until
+// its client disconnects.
+func (s *Server) waitBlocked(w *blockedClient, timeout time.Duration) *protocol.RESPValue {
+	start := time.Now()
+	defer func() { w.client.waited = time.Since(start) }()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	closed, stop := w.client.watchConnection()
+	defer stop()
+
+	select {
+	case response := <-w.reply:
+		return response
+	case <-expired:
+	case <-closed:
+	}
+
+	s.blocking.mu.Lock()
+	defer s.blocking.mu.Unlock()
+	if !s.unblock(w) {
+		// Served in the meantime
+		return <-w.reply
+	}
+	return w.timeout
+}
+
+// unblock removes w from the blocked clients and reports whether it was
+// still there. The caller holds the blocking lock.
+func (s *Server) unblock(w *blockedClient) bool {
+	if s.blocking.clients[w.client.id] != w {
+		return false
+	}
+	delete(s.blocking.clients, w.client.id)
+	for _, k := range w.keys {
+		queue := s.blocking.keys[k]
+		for i, other := range queue {
+			if other == w {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(s.blocking.keys, k)
+		} else {
+			s.blocking.keys[k] = queue
+		}
+	}
+	s.blocking.waiting.Add(-1)
+	return true
+}
+
+// unblockClient wakes the client with the given id if it is blocked, with
+// the reply of a timeout or with an UNBLOCKED error, and reports whether it
+// was blocked.
+func (s *Server) unblockClient(id int64, withError bool) bool {
+	s.blocking.mu.Lock()
+	defer s.blocking.mu.Unlock()
+
+	w := s.blocking.clients[id]
+	if w == nil {
+		return false
+	}
+	s.unblock(w)
+	response := w.timeout
+	if withError {
+		response = errorReply("UNBLOCKED client unblocked via CLIENT UNBLOCK")
+	}
+	w.reply <- response
+	return true
+}
+
+// keyReady is the ready function of every database: a list was stored at
+// key. It is called with the database lock held.
+func (s *Server) keyReady(db int, key string) {
+	if s.blocking.waiting.Load() == 0 {
+		return
+	}
+	s.blocking.readyMu.Lock()
+	s.blocking.ready = append(s.blocking.ready, blockedKey{db, key})
+	s.blocking.readyMu.Unlock()
+}
+
+// signalBlockedDBs marks the keys blocked on in dbs as ready when they
+// hold a list, after SWAPDB exchanged their contents.
+func (s *Server) signalBlockedDBs(dbs ...int) {
+	s.blocking.mu.Lock()
+	defer s.blocking.mu.Unlock()
+
+	for k := range s.blocking.keys {
+		for _, db := range dbs {
+			if k.db == db {
+				if typ, _ := s.dbs[db].Type(k.key); typ == database.ListType {
+					s.keyReady(k.db, k.key)
+				}
+			}
+		}
+	}
+}
+
+// lockWrite takes the write lock for a write command and reports whether
+// it is held exclusively. The lock is shared unless clients are blocked:
+// the command may then store a list they wait for, and must serve them
+// with serveReady before another command can pop from it.
+func (s *Server) lockWrite() bool {
+	s.writeMu.RLock()
+	// No client can block while the lock is shared
+	if s.blocking.waiting.Load() == 0 {
+		return false
+	}
+	s.writeMu.RUnlock()
+	s.writeMu.Lock()
+	return true
+}
+
+// serveReady serves the clients blocked on the keys that got a list,
+// oldest first, until the list is empty again, and logs what it pops.
+// Serving a BLMOVE may make another key ready, which is served in turn.
+// The caller holds the write lock exclusively, or runs EXEC, so that no
+// other write is in flight and the pushes it serves are already logged.
+func (s *Server) serveReady() {
+	s.blocking.mu.Lock()
+	defer s.blocking.mu.Unlock()
+	for {
+		s.blocking.readyMu.Lock()
+		ready := s.blocking.ready
+		s.blocking.ready = nil
+		s.blocking.readyMu.Unlock()
+		if len(ready) == 0 {
+			return
+		}
+
+		for _, k := range ready {
+			for len(s.blocking.keys[k]) > 0 {
+				w := s.blocking.keys[k][0]
+				response := s.dispatch(w.client, w.command, w.args)
+				if response.Null {
+					break
+				}
+				response = s.logWrite(w.client.db, w.command, w.args, response)
+				s.unblock(w)
+				w.reply <- response
+			}
+		}
+	}
+}
+
+// blockedClients returns the number of clients waiting in a blocking
+// command.
+func (s *Server) blockedClients() int {
+	s.blocking.mu.Lock()
+	defer s.blocking.mu.Unlock()
+	return len(s.blocking.clients)
+}
+
+// watchConnection closes the returned channel if the peer closes the
+// connection of c, which nothing reads while c is blocked. The read
+// deadline is cleared meanwhile: a blocked client may wait longer than the
+// idle timeout. stop ends the watch.
+func (c *Client) watchConnection() (closed <-chan struct{}, stop func()) {
+	ch := make(chan struct{})
+	done := make(chan struct{})
+	c.conn.SetReadDeadline(time.Time{})
+	go func() {
+		defer close(done)
+		// Pipelined commands are only peeked at, they stay buffered for
+		// the next read. Once the buffer is full the watch gives up.
+		for n := c.reader.Buffered() + 1; n <= c.reader.Size(); n = c.reader.Buffered() + 1 {
+			if _, err := c.reader.Peek(n); err != nil {
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					close(ch)
+				}
+				return
+			}
+		}
+	}()
+	return ch, func() {
+		c.conn.SetReadDeadline(time.Now())
+		<-done
+	}
+}
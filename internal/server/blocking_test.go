@@ -0,0 +1,221 @@
+package server
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"redis-clone/internal/protocol"
+)
+
+// send sends a command without waiting for its reply.
+func (c *testConn) send(args ...string) {
+	c.t.Helper()
+	if _, err := c.conn.Write(protocol.Serialize(protocol.NewBulkArray(args))); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// waitBlockedClients waits until n clients are blocked on s.
+func waitBlockedClients(t *testing.T, s *Server, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.blockedClients() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients blocked, want %d", s.blockedClients(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBlockedClientsServedInOrder(t *testing.T) {
+	s := newTestServer(t, nil)
+	pusher := newTestClient(t, s)
+	var waiters []*testConn
+	for i := 0; i < 3; i++ {
+		c := dial(t, s)
+		c.send("BLPOP", "other", "queue", "0")
+		waitBlockedClients(t, s, i+1)
+		waiters = append(waiters, c)
+	}
+
+	if reply := s.do(pusher, "RPUSH", "queue", "a", "b"); reply.Num != 2 {
+		t.Fatalf("RPUSH replied %+v", reply)
+	}
+	// Served before the reply to the push
+	if n := s.blockedClients(); n != 1 {
+		t.Fatalf("%d clients blocked after RPUSH, want 1", n)
+	}
+	if reply := s.do(pusher, "LLEN", "queue"); reply.Num != 0 {
+		t.Errorf("%d elements left for the last waiter", reply.Num)
+	}
+	s.do(pusher, "RPUSH", "queue", "c")
+	for i, c := range waiters {
+		want := " queue " + string(rune('a'+i))
+		if reply := strings.Join(flatten(c.read()), " "); reply != want {
+			t.Errorf("waiter %d received %q, want %q", i, reply, want)
+		}
+	}
+}
+
+// waitClientBlocked waits until the client with the given id is blocked.
+func waitClientBlocked(t *testing.T, s *Server, id int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.blocking.mu.Lock()
+		blocked := s.blocking.clients[id] != nil
+		s.blocking.mu.Unlock()
+		if blocked {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("client %d not blocked", id)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBlockedClientNotRobbed(t *testing.T) {
+	s := newTestServer(t, nil)
+	pusher := newTestClient(t, s)
+	waiter := dial(t, s)
+	id := waiter.do("CLIENT", "ID").Num
+
+	// Once the push released its locks the element is the waiter's: no
+	// LPOP nor new BLPOP can take it
+	for _, command := range [][]string{{"LPOP", "queue"}, {"BLPOP", "queue", "0.01"}} {
+		waiter.send("BLPOP", "queue", "0")
+		waitClientBlocked(t, s, id)
+		s.runCommand(pusher, "RPUSH", []string{"queue", "x"})
+		if reply := s.do(newTestClient(t, s), command...); !reply.Null {
+			t.Errorf("%s popped %q before the blocked client", command[0], flatten(reply))
+		}
+		if reply := strings.Join(flatten(waiter.read()), " "); reply != " queue x" {
+			t.Fatalf("waiter received %q", reply)
+		}
+	}
+
+	// Other clients try to take each element while the waiter is served
+	stop := make(chan struct{})
+	stolen := make(chan string, 1)
+	robber := func(command ...string) {
+		client := newTestClient(t, s)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if reply := s.do(client, command...); !reply.Null && reply.Type != protocol.Error {
+				select {
+				case stolen <- strings.Join(flatten(reply), " "):
+				default:
+				}
+			}
+			// Let the connections run on a single CPU
+			runtime.Gosched()
+		}
+	}
+	done := make(chan struct{})
+	go func() { robber("LPOP", "queue"); done <- struct{}{} }()
+	go func() { robber("LMPOP", "1", "queue", "RIGHT"); done <- struct{}{} }()
+
+	for i := 0; i < 50; i++ {
+		waiter.conn.SetDeadline(time.Now().Add(5 * time.Second))
+		waiter.send("BLPOP", "queue", "0")
+		waitClientBlocked(t, s, id)
+		value := strconv.Itoa(i)
+		s.do(pusher, "RPUSH", "queue", value)
+		if reply := strings.Join(flatten(waiter.read()), " "); reply != " queue "+value {
+			t.Fatalf("waiter received %q, want %s", reply, value)
+		}
+	}
+	close(stop)
+	<-done
+	<-done
+	select {
+	case reply := <-stolen:
+		t.Fatalf("another client popped %q from a list a client was blocked on", reply)
+	default:
+	}
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	s := newTestServer(t, nil)
+	client := dial(t, s)
+
+	start := time.Now()
+	if reply := client.do("BLPOP", "queue", "0.05"); reply.Type != protocol.Array || !reply.Null {
+		t.Fatalf("BLPOP replied %+v after its timeout", reply)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("BLPOP returned after %v", elapsed)
+	}
+	if n := s.blockedClients(); n != 0 {
+		t.Errorf("%d clients still blocked", n)
+	}
+	if reply := client.do("BLPOP", "queue", "-1"); reply.Type != protocol.Error {
+		t.Errorf("negative timeout replied %+v", reply)
+	}
+}
+
+func TestClientUnblock(t *testing.T) {
+	s := newTestServer(t, nil)
+	admin := newTestClient(t, s)
+	client := dial(t, s)
+	id := strconv.FormatInt(client.do("CLIENT", "ID").Num, 10)
+
+	client.send("BLMOVE", "src", "dst", "LEFT", "RIGHT", "0")
+	waitBlockedClients(t, s, 1)
+	if reply := s.do(admin, "CLIENT", "UNBLOCK", id); reply.Num != 1 {
+		t.Fatalf("CLIENT UNBLOCK replied %+v", reply)
+	}
+	if reply := client.read(); !reply.Null {
+		t.Errorf("unblocked BLMOVE replied %+v", reply)
+	}
+
+	client.send("BLPOP", "queue", "0")
+	waitBlockedClients(t, s, 1)
+	if reply := s.do(admin, "CLIENT", "UNBLOCK", id, "ERROR"); reply.Num != 1 {
+		t.Fatalf("CLIENT UNBLOCK ERROR replied %+v", reply)
+	}
+	if reply := client.read(); !strings.HasPrefix(reply.Str, "UNBLOCKED") {
+		t.Errorf("BLPOP unblocked with an error replied %+v", reply)
+	}
+	if reply := s.do(admin, "CLIENT", "UNBLOCK", id); reply.Num != 0 {
+		t.Errorf("CLIENT UNBLOCK of a client not blocked replied %+v", reply)
+	}
+
+	// The client is no longer queued: the next push is not for it
+	s.do(admin, "RPUSH", "queue", "a")
+	if reply := s.do(admin, "LLEN", "queue"); reply.Num != 1 {
+		t.Errorf("LLEN = %d after unblocking", reply.Num)
+	}
+}
+
+func TestBlockingMoveChain(t *testing.T) {
+	s := newTestServer(t, nil)
+	pusher := newTestClient(t, s)
+	mover := dial(t, s)
+	popper := dial(t, s)
+
+	mover.send("BLMOVE", "src", "dst", "LEFT", "RIGHT", "0")
+	waitBlockedClients(t, s, 1)
+	popper.send("BRPOP", "dst", "0")
+	waitBlockedClients(t, s, 2)
+
+	// Serving the BLMOVE stores the list the BRPOP waits for
+	s.do(pusher, "LPUSH", "src", "job")
+	if reply := mover.read(); reply.Str != "job" {
+		t.Errorf("BLMOVE replied %+v", reply)
+	}
+	if reply := strings.Join(flatten(popper.read()), " "); reply != " dst job" {
+		t.Errorf("BRPOP replied %q", reply)
+	}
+	if reply := s.do(pusher, "EXISTS", "src", "dst"); reply.Num != 0 {
+		t.Errorf("%d lists left", reply.Num)
+	}
+}
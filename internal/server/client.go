@@ -3,24 +3,52 @@ package server
 import (
 	"bufio"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"redis-clone/internal/protocol"
 )
 
 type Client struct {
-	conn   net.Conn
-	writer *bufio.Writer
-	server *Server
+	id            int64 // unique, as reported by CLIENT ID
+	conn          net.Conn
+	reader        *bufio.Reader
+	writer        *bufio.Writer
+	server        *Server
+	authenticated bool
+	db            int // index of the selected database
+
+	tx      *transaction // commands queued since MULTI, nil outside MULTI
+	watched []watchedKey // keys watched for the next EXEC
+
+	subs *subscriptions // nil until the first subscription command
+
+	// waited is the time the last command spent blocked, which does not
+	// count as execution time in the slowlog
+	waited time.Duration
 }
 
 func NewClient(conn net.Conn, server *Server) *Client {
 	return &Client{
+		id:     atomic.AddInt64(&server.lastClientID, 1),
 		conn:   conn,
+		reader: bufio.NewReader(conn),
 		writer: bufio.NewWriter(conn),
 		server: server,
 	}
 }
 
+// newAOFClient returns the client replaying the AOF at startup. It has no
+// connection.
+func newAOFClient(server *Server) *Client {
+	return &Client{server: server, authenticated: true}
+}
+
+// replaying reports whether c is the client replaying the AOF.
+func (c *Client) replaying() bool {
+	return c.conn == nil
+}
+
 func (c *Client) WriteResponse(resp *protocol.RESPValue) {
 	data := protocol.Serialize(resp)
 	c.writer.Write(data)
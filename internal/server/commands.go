@@ -1,13 +1,18 @@
 package server
 
 import (
+	"crypto/subtle"
 	"strconv"
 	"strings"
 
+	"redis-clone/internal/database"
+	"redis-clone/internal/logger"
 	"redis-clone/internal/protocol"
 )
 
-func (s *Server) executeCommand(cmd *protocol.RESPValue) *protocol.RESPValue {
+// executeCommand runs a single command for client, which is the AOF client
+// for commands replayed at startup.
+func (s *Server) executeCommand(client *Client, cmd *protocol.RESPValue) *protocol.RESPValue {
 	if cmd.Type != protocol.Array || len(cmd.Array) == 0 {
 		return &protocol.RESPValue{
 			Type: protocol.Error,
@@ -21,42 +26,327 @@ func (s *Server) executeCommand(cmd *protocol.RESPValue) *protocol.RESPValue {
 		args[i] = arg.Str
 	}
 
-	// Log command for AOF
-	if isWriteCommand(command) {
-		cmdStr := command
-		for _, arg := range args {
-			cmdStr += " " + arg
+	if command == "AUTH" {
+		return s.handleAuth(client, args)
+	}
+	if !client.authenticated && s.cfg().RequirePass != "" {
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "NOAUTH Authentication required.",
+		}
+	}
+
+	if client.subscribed() && !allowedWhenSubscribed(command) {
+		return errorReply("ERR Can't execute '" + strings.ToLower(command) + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")
+	}
+
+	if transactionCommand(command) {
+		return s.handleTransaction(client, command, args)
+	}
+	if client.tx != nil {
+		return s.queueCommand(client, command, args)
+	}
+	// Blocking commands wait without holding the locks below
+	if commandTable[command].flags&flagBlocking != 0 && !client.replaying() {
+		return s.handleBlocking(client, command, args)
+	}
+
+	return s.runCommand(client, command, args)
+}
+
+// runCommand runs a command that is neither queued nor blocking, and logs
+// it to the AOF.
+func (s *Server) runCommand(client *Client, command string, args []string) *protocol.RESPValue {
+	s.execMu.RLock()
+	defer s.execMu.RUnlock()
+	exclusive := false
+	switch flags := commandTable[command].flags; {
+	case flags&flagExclusive != 0:
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+		exclusive = true
+	case flags&flagWrite != 0:
+		if exclusive = s.lockWrite(); exclusive {
+			defer s.writeMu.Unlock()
+		} else {
+			defer s.writeMu.RUnlock()
 		}
-		s.persistence.WriteAOF(cmdStr)
 	}
 
+	// Commands replayed from the AOF are never refused, the dataset must be
+	// rebuilt as it was
+	if !client.replaying() {
+		if errReply := s.checkWrite(command); errReply != nil {
+			return errReply
+		}
+		if commandTable[command].flags&flagDenyOOM != 0 && !s.freeMemoryIfNeeded() {
+			return errorReply(errOOM)
+		}
+	}
+
+	response := s.dispatch(client, command, args)
+
+	// Log successful writes for AOF. This happens before the reply is sent
+	// so that appendfsync always can guarantee durability.
+	response = s.logWrite(client.db, command, args, response)
+	// Clients blocked on a list the command stored are served before the
+	// lock is released
+	if exclusive {
+		s.serveReady()
+	}
+	return response
+}
+
+// checkWrite refuses write commands while the AOF cannot be written or
+// fsynced, as Redis does, so that no client takes for durable a write that
+// may be lost.
+func (s *Server) checkWrite(command string) *protocol.RESPValue {
+	if !isWriteCommand(command) {
+		return nil
+	}
+	if err := s.persistence.AOFError(); err != nil {
+		return aofErrorReply(err)
+	}
+	return nil
+}
+
+// aofErrorReply is the reply to a write that could not be logged.
+func aofErrorReply(err error) *protocol.RESPValue {
+	return errorReply("MISCONF Errors writing to the AOF file: " + err.Error())
+}
+
+// logWrite appends a command that ran on the database with index db to the
+// AOF, if it changed the dataset, and returns the reply to send: response,
+// or an error when the AOF could not be written. The dataset keeps the
+// change, which the AOF gets once the disk recovers.
+func (s *Server) logWrite(db int, command string, args []string, response *protocol.RESPValue) *protocol.RESPValue {
+	if entry := writeEntry(command, args, response); entry != nil {
+		if err := s.persistence.WriteAOF(db, entry); err != nil {
+			logger.Warningf("Error writing AOF: %v", err)
+			return aofErrorReply(err)
+		}
+	}
+	return response
+}
+
+func (s *Server) dispatch(client *Client, command string, args []string) *protocol.RESPValue {
+	db := s.dbs[client.db]
 	switch command {
 	case "PING":
+		if client.subscribed() {
+			return s.handleSubscribedPing(args)
+		}
 		return s.handlePing(args)
+	case "INFO":
+		return s.handleInfo(args)
+	case "CONFIG":
+		return s.handleConfig(args)
+	case "SLOWLOG":
+		return s.handleSlowlog(args)
+	case "BGREWRITEAOF":
+		return s.handleBgRewriteAOF(args)
+	case "SAVE":
+		return s.handleSave(args)
+	case "BGSAVE":
+		return s.handleBgSave(args)
+	case "LASTSAVE":
+		return s.handleLastSave(args)
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		return s.handleSubscribe(client, command, args)
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		return s.handleUnsubscribe(client, command, args)
+	case "PUBLISH":
+		return s.handlePublish(args)
+	case "PUBSUB":
+		return s.handlePubSub(args)
+	case "CLIENT":
+		return s.handleClient(client, args)
+	case "SELECT":
+		return s.handleSelect(client, args)
+	case "MOVE":
+		return s.handleMove(db, args)
+	case "SWAPDB":
+		return s.handleSwapDB(args)
+	case "FLUSHDB":
+		return s.handleFlushDB(db, args)
+	case "FLUSHALL":
+		return s.handleFlushAll(args)
+	case "TYPE":
+		return s.handleType(db, args)
+	case "DBSIZE":
+		return s.handleDBSize(db, args)
+	case "RENAME", "RENAMENX":
+		return s.handleRename(db, command, args)
+	case "COPY":
+		return s.handleCopy(db, args)
+	case "RANDOMKEY":
+		return s.handleRandomKey(db, args)
+	case "TOUCH":
+		return s.handleTouch(db, args)
+	case "UNLINK":
+		return s.handleUnlink(db, args)
 	case "SET":
-		return s.handleSet(args)
+		return s.handleSet(db, args)
+	case "SETNX":
+		return s.handleSetNX(db, args)
+	case "SETEX", "PSETEX":
+		return s.handleSetEx(db, command, args)
 	case "GET":
-		return s.handleGet(args)
+		return s.handleGet(db, args)
+	case "GETSET":
+		return s.handleGetSet(db, args)
+	case "GETDEL":
+		return s.handleGetDel(db, args)
+	case "GETEX":
+		return s.handleGetEx(db, args)
+	case "MGET":
+		return s.handleMGet(db, args)
+	case "MSET", "MSETNX":
+		return s.handleMSet(db, command, args)
+	case "APPEND":
+		return s.handleAppend(db, args)
+	case "STRLEN":
+		return s.handleStrLen(db, args)
+	case "GETRANGE":
+		return s.handleGetRange(db, args)
+	case "SETRANGE":
+		return s.handleSetRange(db, args)
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		return s.handleIncrBy(db, command, args)
+	case "INCRBYFLOAT":
+		return s.handleIncrByFloat(db, args)
+	case "LCS":
+		return s.handleLCS(db, args)
 	case "DEL":
-		return s.handleDel(args)
+		return s.handleDel(db, args)
 	case "EXISTS":
-		return s.handleExists(args)
-	case "EXPIRE":
-		return s.handleExpire(args)
-	case "TTL":
-		return s.handleTTL(args)
+		return s.handleExists(db, args)
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT":
+		return s.handleExpire(db, command, args)
+	case "TTL", "PTTL", "EXPIRETIME", "PEXPIRETIME":
+		return s.handleTTL(db, command, args)
+	case "PERSIST":
+		return s.handlePersist(db, args)
 	case "KEYS":
-		return s.handleKeys(args)
-	case "HSET":
-		return s.handleHSet(args)
+		return s.handleKeys(db, args)
+	case "SCAN":
+		return s.handleScan(db, args)
+	case "HSET", "HMSET":
+		return s.handleHSet(db, command, args)
+	case "HSETNX":
+		return s.handleHSetNX(db, args)
 	case "HGET":
-		return s.handleHGet(args)
+		return s.handleHGet(db, args)
+	case "HMGET":
+		return s.handleHMGet(db, args)
+	case "HGETALL", "HKEYS", "HVALS":
+		return s.handleHGetAll(db, command, args)
+	case "HLEN":
+		return s.handleHLen(db, args)
+	case "HEXISTS":
+		return s.handleHExists(db, args)
+	case "HSTRLEN":
+		return s.handleHStrLen(db, args)
 	case "HDEL":
-		return s.handleHDel(args)
-	case "INCR":
-		return s.handleIncr(args)
-	case "DECR":
-		return s.handleDecr(args)
+		return s.handleHDel(db, args)
+	case "HINCRBY":
+		return s.handleHIncrBy(db, args)
+	case "HINCRBYFLOAT":
+		return s.handleHIncrByFloat(db, args)
+	case "HRANDFIELD":
+		return s.handleHRandField(db, args)
+	case "HSCAN":
+		return s.handleHScan(db, args)
+	case "HEXPIRE", "HPEXPIRE", "HEXPIREAT", "HPEXPIREAT":
+		return s.handleHExpire(db, command, args)
+	case "HTTL", "HPTTL", "HEXPIRETIME", "HPEXPIRETIME":
+		return s.handleHTTL(db, command, args)
+	case "HPERSIST":
+		return s.handleHPersist(db, args)
+	case "LPUSH", "RPUSH", "LPUSHX", "RPUSHX":
+		return s.handlePush(db, command, args)
+	case "LPOP", "RPOP":
+		return s.handlePop(db, command, args)
+	case "LLEN":
+		return s.handleLLen(db, args)
+	case "LRANGE":
+		return s.handleLRange(db, args)
+	case "LINDEX":
+		return s.handleLIndex(db, args)
+	case "LSET":
+		return s.handleLSet(db, args)
+	case "LINSERT":
+		return s.handleLInsert(db, args)
+	case "LREM":
+		return s.handleLRem(db, args)
+	case "LTRIM":
+		return s.handleLTrim(db, args)
+	case "LPOS":
+		return s.handleLPos(db, args)
+	case "LMOVE":
+		return s.handleLMove(db, args)
+	case "LMPOP":
+		return s.handleLMPop(db, args)
+	case "BLPOP", "BRPOP":
+		return s.handleBPop(db, command, args)
+	case "BLMOVE":
+		return s.handleBLMove(db, args)
+	case "BLMPOP":
+		return s.handleBLMPop(db, args)
+	case "SADD":
+		return s.handleSAdd(db, args)
+	case "SREM":
+		return s.handleSRem(db, args)
+	case "SISMEMBER":
+		return s.handleSIsMember(db, args)
+	case "SMISMEMBER":
+		return s.handleSMIsMember(db, args)
+	case "SMEMBERS":
+		return s.handleSMembers(db, args)
+	case "SCARD":
+		return s.handleSCard(db, args)
+	case "SPOP":
+		return s.handleSPop(db, args)
+	case "SRANDMEMBER":
+		return s.handleSRandMember(db, args)
+	case "SMOVE":
+		return s.handleSMove(db, args)
+	case "SINTER", "SUNION", "SDIFF":
+		return s.handleSetOp(db, command, args)
+	case "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE":
+		return s.handleSetOpStore(db, command, args)
+	case "SINTERCARD":
+		return s.handleSInterCard(db, args)
+	case "SSCAN":
+		return s.handleSScan(db, args)
+	case "ZADD":
+		return s.handleZAdd(db, args)
+	case "ZINCRBY":
+		return s.handleZIncrBy(db, args)
+	case "ZREM":
+		return s.handleZRem(db, args)
+	case "ZSCORE":
+		return s.handleZScore(db, args)
+	case "ZMSCORE":
+		return s.handleZMScore(db, args)
+	case "ZCARD":
+		return s.handleZCard(db, args)
+	case "ZCOUNT":
+		return s.handleZCount(db, args)
+	case "ZRANK", "ZREVRANK":
+		return s.handleZRank(db, command, args)
+	case "ZRANGE":
+		return s.handleZRange(db, args)
+	case "ZRANGESTORE":
+		return s.handleZRangeStore(db, args)
+	case "ZREMRANGEBYRANK", "ZREMRANGEBYSCORE", "ZREMRANGEBYLEX":
+		return s.handleZRemRange(db, command, args)
+	case "ZPOPMIN", "ZPOPMAX":
+		return s.handleZPop(db, command, args)
+	case "ZUNIONSTORE", "ZINTERSTORE":
+		return s.handleZStore(db, command, args)
+	case "ZSCAN":
+		return s.handleZScan(db, args)
 	default:
 		return &protocol.RESPValue{
 			Type: protocol.Error,
@@ -65,230 +355,491 @@ func (s *Server) executeCommand(cmd *protocol.RESPValue) *protocol.RESPValue {
 	}
 }
 
-func isWriteCommand(command string) bool {
-	writeCommands := map[string]bool{
-		"SET":    true,
-		"DEL":    true,
-		"EXPIRE": true,
-		"HSET":   true,
-		"HDEL":   true,
-		"INCR":   true,
-		"DECR":   true,
-	}
-	return writeCommands[command]
+// Command flags
+const (
+	flagWrite     = 1 << iota // modifies the dataset, logged to the AOF
+	flagDenyOOM               // may use more memory, refused over maxmemory
+	flagNoMulti               // may not be queued in a transaction
+	flagBlocking              // may wait for data, see blocking.go
+	flagExclusive             // takes a snapshot, runs while no write is in flight
+)
+
+// commandInfo describes a command. As in Redis, the arity counts the
+// command name and a negative arity -N means at least N.
+type commandInfo struct {
+	arity int
+	flags int
 }
 
-func (s *Server) handlePing(args []string) *protocol.RESPValue {
-	if len(args) == 0 {
-		return &protocol.RESPValue{
-			Type: protocol.SimpleString,
-			Str:  "PONG",
-		}
+var commandTable = map[string]commandInfo{
+	"AUTH":             {-2, 0},
+	"PING":             {-1, 0},
+	"INFO":             {-1, 0},
+	"CONFIG":           {-2, 0},
+	"SLOWLOG":          {-2, 0},
+	"BGREWRITEAOF":     {1, flagExclusive},
+	"SAVE":             {1, flagExclusive},
+	"BGSAVE":           {-1, flagExclusive},
+	"LASTSAVE":         {1, 0},
+	"MULTI":            {1, 0},
+	"EXEC":             {1, 0},
+	"DISCARD":          {1, 0},
+	"WATCH":            {-2, 0},
+	"UNWATCH":          {1, 0},
+	"SUBSCRIBE":        {-2, flagNoMulti},
+	"UNSUBSCRIBE":      {-1, flagNoMulti},
+	"PSUBSCRIBE":       {-2, flagNoMulti},
+	"PUNSUBSCRIBE":     {-1, flagNoMulti},
+	"PUBLISH":          {3, 0},
+	"PUBSUB":           {-2, 0},
+	"CLIENT":           {-2, 0},
+	"SELECT":           {2, 0},
+	"MOVE":             {3, flagWrite},
+	"SWAPDB":           {3, flagWrite},
+	"FLUSHDB":          {-1, flagWrite},
+	"FLUSHALL":         {-1, flagWrite},
+	"TYPE":             {2, 0},
+	"DBSIZE":           {1, 0},
+	"RENAME":           {3, flagWrite},
+	"RENAMENX":         {3, flagWrite},
+	"COPY":             {-3, flagWrite | flagDenyOOM},
+	"RANDOMKEY":        {1, 0},
+	"TOUCH":            {-2, 0},
+	"UNLINK":           {-2, flagWrite},
+	"SET":              {-3, flagWrite | flagDenyOOM},
+	"SETNX":            {3, flagWrite | flagDenyOOM},
+	"SETEX":            {4, flagWrite | flagDenyOOM},
+	"PSETEX":           {4, flagWrite | flagDenyOOM},
+	"GET":              {2, 0},
+	"GETSET":           {3, flagWrite | flagDenyOOM},
+	"GETDEL":           {2, flagWrite},
+	"GETEX":            {-2, flagWrite},
+	"MGET":             {-2, 0},
+	"MSET":             {-3, flagWrite | flagDenyOOM},
+	"MSETNX":           {-3, flagWrite | flagDenyOOM},
+	"APPEND":           {3, flagWrite | flagDenyOOM},
+	"STRLEN":           {2, 0},
+	"GETRANGE":         {4, 0},
+	"SETRANGE":         {4, flagWrite | flagDenyOOM},
+	"INCR":             {2, flagWrite | flagDenyOOM},
+	"DECR":             {2, flagWrite | flagDenyOOM},
+	"INCRBY":           {3, flagWrite | flagDenyOOM},
+	"DECRBY":           {3, flagWrite | flagDenyOOM},
+	"INCRBYFLOAT":      {3, flagWrite | flagDenyOOM},
+	"LCS":              {-3, 0},
+	"DEL":              {-2, flagWrite},
+	"EXISTS":           {-2, 0},
+	"EXPIRE":           {-3, flagWrite},
+	"PEXPIRE":          {-3, flagWrite},
+	"EXPIREAT":         {-3, flagWrite},
+	"PEXPIREAT":        {-3, flagWrite},
+	"TTL":              {2, 0},
+	"PTTL":             {2, 0},
+	"EXPIRETIME":       {2, 0},
+	"PEXPIRETIME":      {2, 0},
+	"PERSIST":          {2, flagWrite},
+	"KEYS":             {2, 0},
+	"SCAN":             {-2, 0},
+	"HSET":             {-4, flagWrite | flagDenyOOM},
+	"HMSET":            {-4, flagWrite | flagDenyOOM},
+	"HSETNX":           {4, flagWrite | flagDenyOOM},
+	"HGET":             {3, 0},
+	"HMGET":            {-3, 0},
+	"HGETALL":          {2, 0},
+	"HKEYS":            {2, 0},
+	"HVALS":            {2, 0},
+	"HLEN":             {2, 0},
+	"HEXISTS":          {3, 0},
+	"HSTRLEN":          {3, 0},
+	"HDEL":             {-3, flagWrite},
+	"HINCRBY":          {4, flagWrite | flagDenyOOM},
+	"HINCRBYFLOAT":     {4, flagWrite | flagDenyOOM},
+	"HRANDFIELD":       {-2, 0},
+	"HSCAN":            {-3, 0},
+	"HEXPIRE":          {-5, flagWrite},
+	"HPEXPIRE":         {-5, flagWrite},
+	"HEXPIREAT":        {-5, flagWrite},
+	"HPEXPIREAT":       {-5, flagWrite},
+	"HTTL":             {-4, 0},
+	"HPTTL":            {-4, 0},
+	"HEXPIRETIME":      {-4, 0},
+	"HPEXPIRETIME":     {-4, 0},
+	"HPERSIST":         {-4, flagWrite},
+	"LPUSH":            {-3, flagWrite | flagDenyOOM},
+	"RPUSH":            {-3, flagWrite | flagDenyOOM},
+	"LPUSHX":           {-3, flagWrite | flagDenyOOM},
+	"RPUSHX":           {-3, flagWrite | flagDenyOOM},
+	"LPOP":             {-2, flagWrite},
+	"RPOP":             {-2, flagWrite},
+	"LLEN":             {2, 0},
+	"LRANGE":           {4, 0},
+	"LINDEX":           {3, 0},
+	"LSET":             {4, flagWrite | flagDenyOOM},
+	"LINSERT":          {5, flagWrite | flagDenyOOM},
+	"LREM":             {4, flagWrite},
+	"LTRIM":            {4, flagWrite},
+	"LPOS":             {-3, 0},
+	"LMOVE":            {5, flagWrite | flagDenyOOM},
+	"LMPOP":            {-4, flagWrite},
+	"BLPOP":            {-3, flagWrite | flagBlocking},
+	"BRPOP":            {-3, flagWrite | flagBlocking},
+	"BLMOVE":           {6, flagWrite | flagDenyOOM | flagBlocking},
+	"BLMPOP":           {-5, flagWrite | flagBlocking},
+	"SADD":             {-3, flagWrite | flagDenyOOM},
+	"SREM":             {-3, flagWrite},
+	"SISMEMBER":        {3, 0},
+	"SMISMEMBER":       {-3, 0},
+	"SMEMBERS":         {2, 0},
+	"SCARD":            {2, 0},
+	"SPOP":             {-2, flagWrite},
+	"SRANDMEMBER":      {-2, 0},
+	"SMOVE":            {4, flagWrite},
+	"SINTER":           {-2, 0},
+	"SUNION":           {-2, 0},
+	"SDIFF":            {-2, 0},
+	"SINTERSTORE":      {-3, flagWrite | flagDenyOOM},
+	"SUNIONSTORE":      {-3, flagWrite | flagDenyOOM},
+	"SDIFFSTORE":       {-3, flagWrite | flagDenyOOM},
+	"SINTERCARD":       {-3, 0},
+	"SSCAN":            {-3, 0},
+	"ZADD":             {-4, flagWrite | flagDenyOOM},
+	"ZINCRBY":          {4, flagWrite | flagDenyOOM},
+	"ZREM":             {-3, flagWrite},
+	"ZSCORE":           {3, 0},
+	"ZMSCORE":          {-3, 0},
+	"ZCARD":            {2, 0},
+	"ZCOUNT":           {4, 0},
+	"ZRANK":            {3, 0},
+	"ZREVRANK":         {3, 0},
+	"ZRANGE":           {-4, 0},
+	"ZRANGESTORE":      {-5, flagWrite | flagDenyOOM},
+	"ZREMRANGEBYRANK":  {4, flagWrite},
+	"ZREMRANGEBYSCORE": {4, flagWrite},
+	"ZREMRANGEBYLEX":   {4, flagWrite},
+	"ZPOPMIN":          {-2, flagWrite},
+	"ZPOPMAX":          {-2, flagWrite},
+	"ZUNIONSTORE":      {-4, flagWrite | flagDenyOOM},
+	"ZINTERSTORE":      {-4, flagWrite | flagDenyOOM},
+	"ZSCAN":            {-3, 0},
+}
+
+// checkCommand returns the error for an unknown command or a wrong number of
+// arguments, or nil if the command may run.
+func checkCommand(command string, args []string) *protocol.RESPValue {
+	info, exists := commandTable[command]
+	if !exists {
+		return errorReply("ERR unknown command '" + command + "'")
 	}
-	return &protocol.RESPValue{
-		Type: protocol.BulkString,
-		Str:  args[0],
+	n := len(args) + 1
+	if (info.arity > 0 && n != info.arity) || n < -info.arity {
+		return wrongArgsReply(command)
 	}
+	return nil
 }
 
-func (s *Server) handleSet(args []string) *protocol.RESPValue {
-	if len(args) < 2 {
-		return &protocol.RESPValue{
-			Type: protocol.Error,
-			Str:  "ERR wrong number of arguments for 'set' command",
-		}
-	}
+func isWriteCommand(command string) bool {
+	return commandTable[command].flags&flagWrite != 0
+}
 
-	key, value := args[0], args[1]
-	s.db.Set(key, value)
+// writeEntry returns the AOF record of a command that ran successfully, or
+// nil when it is not a write or its reply shows it changed nothing.
+func writeEntry(command string, args []string, response *protocol.RESPValue) []string {
+	if !isWriteCommand(command) || response.Type == protocol.Error {
+		return nil
+	}
+	return aofEntry(command, args, response)
+}
 
-	return &protocol.RESPValue{
-		Type: protocol.SimpleString,
-		Str:  "OK",
+// aofEntry builds the AOF record for a write command from its arguments and
+// reply, or returns nil when nothing changed: a zero count, a null or empty
+// pop, a failed condition. Relative expirations are
+// converted to absolute timestamps so that replaying the file after a restart
+// does not extend the lifetime of the key, and commands with a random outcome
+// are logged as their effect.
+func aofEntry(command string, args []string, response *protocol.RESPValue) []string {
+	switch command {
+	case "EXPIRE", "PEXPIRE", "EXPIREAT":
+		if response.Num == 0 {
+			return nil
+		}
+		// Key expirations are logged in absolute milliseconds
+		n, _ := parseInt(args[1])
+		at, _ := expireTime(command, n)
+		args = append([]string{args[0], strconv.FormatInt(at.UnixMilli(), 10)}, args[2:]...)
+		command = "PEXPIREAT"
+	case "PEXPIREAT", "PERSIST":
+		if response.Num == 0 {
+			return nil
+		}
+	case "SET":
+		if response.Null && !setHasGet(args) {
+			return nil
+		}
+		args = absoluteSetArgs(args, 2)
+	case "SETEX", "PSETEX":
+		n, _ := parseInt(args[1])
+		at, _ := expireTime(command, n)
+		args = []string{args[0], args[2], "PXAT", strconv.FormatInt(at.UnixMilli(), 10)}
+		command = "SET"
+	case "GETEX":
+		if response.Null || len(args) == 1 {
+			return nil
+		}
+		args = absoluteSetArgs(args, 1)
+	case "SETNX", "MSETNX", "MOVE", "RENAMENX", "COPY", "DEL", "UNLINK",
+		"HSETNX", "HDEL", "LPUSHX", "RPUSHX", "LREM", "SADD", "SREM", "SMOVE",
+		"ZREM", "ZREMRANGEBYRANK", "ZREMRANGEBYSCORE", "ZREMRANGEBYLEX":
+		if response.Num == 0 {
+			return nil
+		}
+	case "LINSERT":
+		// -1 when the pivot was not found, 0 when the key does not exist
+		if response.Num <= 0 {
+			return nil
+		}
+	case "LPOP", "RPOP", "LMOVE", "LMPOP", "ZPOPMIN", "ZPOPMAX", "ZADD":
+		// ZADD replies null when NX or XX prevented its INCR
+		if response.Null || response.Type == protocol.Array && len(response.Array) == 0 {
+			return nil
+		}
+	case "HPEXPIREAT", "HPERSIST":
+		if !anyFieldChanged(response) {
+			return nil
+		}
+	case "GETDEL":
+		if response.Null {
+			return nil
+		}
+	case "INCRBYFLOAT":
+		// Replaying the addition could round differently
+		args = []string{args[0], response.Str, "KEEPTTL"}
+		command = "SET"
+	case "SPOP":
+		popped := response.Array
+		if response.Type == protocol.BulkString && !response.Null {
+			popped = []*protocol.RESPValue{response}
+		}
+		if len(popped) == 0 {
+			return nil
+		}
+		args = []string{args[0]}
+		for _, member := range popped {
+			args = append(args, member.Str)
+		}
+		command = "SREM"
+	case "HEXPIRE", "HPEXPIRE", "HEXPIREAT":
+		if !anyFieldChanged(response) {
+			return nil
+		}
+		// Field expirations are logged in absolute milliseconds
+		n, _ := parseInt(args[1])
+		at, _ := expireTime(command, n)
+		args = append([]string{args[0], strconv.FormatInt(at.UnixMilli(), 10)}, args[2:]...)
+		command = "HPEXPIREAT"
+	case "HINCRBYFLOAT":
+		// Replaying the addition could round differently
+		args = []string{args[0], args[1], response.Str}
+		command = "HSET"
+	case "BLPOP", "BRPOP":
+		// Blocking pops are logged as the pop they made, which never
+		// blocks when replayed
+		if response.Null {
+			return nil
+		}
+		args = []string{response.Array[0].Str}
+		command = command[1:]
+	case "BLMOVE":
+		if response.Null {
+			return nil
+		}
+		args = args[:4]
+		command = "LMOVE"
+	case "BLMPOP":
+		if response.Null {
+			return nil
+		}
+		args = args[1:]
+		command = "LMPOP"
 	}
+	return append([]string{command}, args...)
 }
 
-func (s *Server) handleGet(args []string) *protocol.RESPValue {
-	if len(args) != 1 {
-		return &protocol.RESPValue{
-			Type: protocol.Error,
-			Str:  "ERR wrong number of arguments for 'get' command",
+// anyFieldChanged reports whether the reply of a command of the HEXPIRE
+// family or of HPERSIST has a field it changed, coded 1, or deleted, coded 2.
+func anyFieldChanged(response *protocol.RESPValue) bool {
+	for _, result := range response.Array {
+		if result.Num > 0 {
+			return true
 		}
 	}
+	return false
+}
 
-	key := args[0]
-	value, exists := s.db.Get(key)
-	if !exists {
-		return &protocol.RESPValue{
-			Type: protocol.BulkString,
-			Null: true,
+// absoluteSetArgs rewrites the EX, PX and EXAT options of SET and GETEX,
+// found from args[from], as PXAT, and drops GET.
+func absoluteSetArgs(args []string, from int) []string {
+	rewritten := append([]string{}, args[:from]...)
+	for i := from; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch {
+		case option == "GET":
+		case isExpireOption(option) && i+1 < len(args):
+			n, _ := parseInt(args[i+1])
+			at, _ := expireTime(option, n)
+			rewritten = append(rewritten, "PXAT", strconv.FormatInt(at.UnixMilli(), 10))
+			i++
+		default:
+			rewritten = append(rewritten, args[i])
 		}
 	}
+	return rewritten
+}
 
-	return &protocol.RESPValue{
-		Type: protocol.BulkString,
-		Str:  value,
+func setHasGet(args []string) bool {
+	for _, arg := range args[2:] {
+		if strings.ToUpper(arg) == "GET" {
+			return true
+		}
 	}
+	return false
 }
 
-func (s *Server) handleDel(args []string) *protocol.RESPValue {
-	if len(args) == 0 {
+func (s *Server) handleAuth(client *Client, args []string) *protocol.RESPValue {
+	if len(args) < 1 || len(args) > 2 {
 		return &protocol.RESPValue{
 			Type: protocol.Error,
-			Str:  "ERR wrong number of arguments for 'del' command",
+			Str:  "ERR wrong number of arguments for 'auth' command",
 		}
 	}
 
-	deleted := 0
-	for _, key := range args {
-		if s.db.Del(key) {
-			deleted++
+	if s.cfg().RequirePass == "" {
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?",
 		}
 	}
 
-	return &protocol.RESPValue{
-		Type: protocol.Integer,
-		Num:  int64(deleted),
+	// Only the default user exists
+	password := args[len(args)-1]
+	username := "default"
+	if len(args) == 2 {
+		username = args[0]
 	}
-}
-
-func (s *Server) handleExists(args []string) *protocol.RESPValue {
-	if len(args) == 0 {
+	if username != "default" || subtle.ConstantTimeCompare([]byte(password), []byte(s.cfg().RequirePass)) != 1 {
 		return &protocol.RESPValue{
 			Type: protocol.Error,
-			Str:  "ERR wrong number of arguments for 'exists' command",
-		}
-	}
-
-	count := 0
-	for _, key := range args {
-		if s.db.Exists(key) {
-			count++
+			Str:  "WRONGPASS invalid username-password pair or user is disabled.",
 		}
 	}
 
+	client.authenticated = true
 	return &protocol.RESPValue{
-		Type: protocol.Integer,
-		Num:  int64(count),
+		Type: protocol.SimpleString,
+		Str:  "OK",
 	}
 }
 
-func (s *Server) handleExpire(args []string) *protocol.RESPValue {
-	if len(args) != 2 {
+func (s *Server) handlePing(args []string) *protocol.RESPValue {
+	if len(args) == 0 {
 		return &protocol.RESPValue{
-			Type: protocol.Error,
-			Str:  "ERR wrong number of arguments for 'expire' command",
+			Type: protocol.SimpleString,
+			Str:  "PONG",
 		}
 	}
+	return &protocol.RESPValue{
+		Type: protocol.BulkString,
+		Str:  args[0],
+	}
+}
 
-	key := args[0]
-	seconds, err := strconv.Atoi(args[1])
-	if err != nil {
+func (s *Server) handleBgRewriteAOF(args []string) *protocol.RESPValue {
+	if len(args) != 0 {
 		return &protocol.RESPValue{
 			Type: protocol.Error,
-			Str:  "ERR value is not an integer or out of range",
+			Str:  "ERR wrong number of arguments for 'bgrewriteaof' command",
 		}
 	}
 
-	if s.db.Expire(key, seconds) {
+	if err := s.persistence.StartRewriteAOF(); err != nil {
 		return &protocol.RESPValue{
-			Type: protocol.Integer,
-			Num:  1,
+			Type: protocol.Error,
+			Str:  err.Error(),
 		}
 	}
 
 	return &protocol.RESPValue{
-		Type: protocol.Integer,
-		Num:  0,
+		Type: protocol.SimpleString,
+		Str:  "Background append only file rewriting started",
 	}
 }
 
-func (s *Server) handleTTL(args []string) *protocol.RESPValue {
-	if len(args) != 1 {
+func (s *Server) handleSave(args []string) *protocol.RESPValue {
+	if len(args) != 0 {
 		return &protocol.RESPValue{
 			Type: protocol.Error,
-			Str:  "ERR wrong number of arguments for 'ttl' command",
+			Str:  "ERR wrong number of arguments for 'save' command",
 		}
 	}
 
-	key := args[0]
-	ttl := s.db.TTL(key)
-
-	return &protocol.RESPValue{
-		Type: protocol.Integer,
-		Num:  ttl,
-	}
-}
-
-func (s *Server) handleKeys(args []string) *protocol.RESPValue {
-	keys := s.db.Keys()
-	result := make([]*protocol.RESPValue, len(keys))
-
-	for i, key := range keys {
-		result[i] = &protocol.RESPValue{
-			Type: protocol.BulkString,
-			Str:  key,
+	if err := s.persistence.Save(); err != nil {
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR " + strings.TrimPrefix(err.Error(), "ERR "),
 		}
 	}
 
 	return &protocol.RESPValue{
-		Type:  protocol.Array,
-		Array: result,
+		Type: protocol.SimpleString,
+		Str:  "OK",
 	}
 }
 
-func (s *Server) handleHSet(args []string) *protocol.RESPValue {
-	if len(args) != 3 {
+func (s *Server) handleBgSave(args []string) *protocol.RESPValue {
+	if len(args) > 1 || (len(args) == 1 && strings.ToUpper(args[0]) != "SCHEDULE") {
 		return &protocol.RESPValue{
 			Type: protocol.Error,
-			Str:  "ERR wrong number of arguments for 'hset' command",
+			Str:  "ERR syntax error",
 		}
 	}
 
-	key, field, value := args[0], args[1], args[2]
-	s.db.HSet(key, field, value)
+	if err := s.persistence.BackgroundSave(); err != nil {
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  err.Error(),
+		}
+	}
 
 	return &protocol.RESPValue{
-		Type: protocol.Integer,
-		Num:  1,
+		Type: protocol.SimpleString,
+		Str:  "Background saving started",
 	}
 }
 
-func (s *Server) handleHGet(args []string) *protocol.RESPValue {
-	if len(args) != 2 {
+func (s *Server) handleLastSave(args []string) *protocol.RESPValue {
+	if len(args) != 0 {
 		return &protocol.RESPValue{
 			Type: protocol.Error,
-			Str:  "ERR wrong number of arguments for 'hget' command",
-		}
-	}
-
-	key, field := args[0], args[1]
-	value, exists := s.db.HGet(key, field)
-	if !exists {
-		return &protocol.RESPValue{
-			Type: protocol.BulkString,
-			Null: true,
+			Str:  "ERR wrong number of arguments for 'lastsave' command",
 		}
 	}
 
 	return &protocol.RESPValue{
-		Type: protocol.BulkString,
-		Str:  value,
+		Type: protocol.Integer,
+		Num:  s.persistence.LastSave().Unix(),
 	}
 }
 
-func (s *Server) handleHDel(args []string) *protocol.RESPValue {
-	if len(args) < 2 {
+func (s *Server) handleDel(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) == 0 {
 		return &protocol.RESPValue{
 			Type: protocol.Error,
-			Str:  "ERR wrong number of arguments for 'hdel' command",
+			Str:  "ERR wrong number of arguments for 'del' command",
 		}
 	}
 
-	key := args[0]
 	deleted := 0
-	for _, field := range args[1:] {
-		if s.db.HDel(key, field) {
+	for _, key := range args {
+		if db.Del(key) {
 			deleted++
 		}
 	}
@@ -299,66 +850,34 @@ func (s *Server) handleHDel(args []string) *protocol.RESPValue {
 	}
 }
 
-func (s *Server) handleIncr(args []string) *protocol.RESPValue {
-	if len(args) != 1 {
+func (s *Server) handleExists(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) == 0 {
 		return &protocol.RESPValue{
 			Type: protocol.Error,
-			Str:  "ERR wrong number of arguments for 'incr' command",
+			Str:  "ERR wrong number of arguments for 'exists' command",
 		}
 	}
 
-	key := args[0]
-	value, exists := s.db.Get(key)
-	var intValue int64 = 0
-
-	if exists {
-		num, err := strconv.ParseInt(value, 10, 64)
-		if err != nil {
-			return &protocol.RESPValue{
-				Type: protocol.Error,
-				Str:  "ERR value is not an integer or out of range",
-			}
+	count := 0
+	for _, key := range args {
+		if db.Exists(key) {
+			count++
 		}
-		intValue = num
 	}
 
-	intValue++
-	s.db.Set(key, strconv.FormatInt(intValue, 10))
-
 	return &protocol.RESPValue{
 		Type: protocol.Integer,
-		Num:  intValue,
+		Num:  int64(count),
 	}
 }
 
-func (s *Server) handleDecr(args []string) *protocol.RESPValue {
+// KEYS pattern returns every key matching a glob pattern. The keys are
+// copied under the read lock and matched after it is released.
+func (s *Server) handleKeys(db *database.Database, args []string) *protocol.RESPValue {
 	if len(args) != 1 {
-		return &protocol.RESPValue{
-			Type: protocol.Error,
-			Str:  "ERR wrong number of arguments for 'decr' command",
-		}
-	}
-
-	key := args[0]
-	value, exists := s.db.Get(key)
-	var intValue int64 = 0
-
-	if exists {
-		num, err := strconv.ParseInt(value, 10, 64)
-		if err != nil {
-			return &protocol.RESPValue{
-				Type: protocol.Error,
-				Str:  "ERR value is not an integer or out of range",
-			}
-		}
-		intValue = num
+		return wrongArgsReply("keys")
 	}
 
-	intValue--
-	s.db.Set(key, strconv.FormatInt(intValue, 10))
-
-	return &protocol.RESPValue{
-		Type: protocol.Integer,
-		Num:  intValue,
-	}
+	scan := scanArgs{match: args[0]}
+	return protocol.NewBulkArray(scan.filter(db.Keys()))
 }
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"strings"
+
+	"redis-clone/internal/protocol"
+)
+
+// CLIENT ID and CLIENT UNBLOCK client-id [TIMEOUT|ERROR]
+func (s *Server) handleClient(client *Client, args []string) *protocol.RESPValue {
+	if len(args) == 0 {
+		return wrongArgsReply("client")
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "ID":
+		if len(args) != 1 {
+			return wrongArgsReply("client|id")
+		}
+		return integerReply(client.id)
+	case "UNBLOCK":
+		if len(args) < 2 || len(args) > 3 {
+			return wrongArgsReply("client|unblock")
+		}
+		id, ok := parseInt(args[1])
+		if !ok {
+			return errorReply(errNotInteger)
+		}
+		withError := false
+		if len(args) == 3 {
+			switch strings.ToUpper(args[2]) {
+			case "TIMEOUT":
+			case "ERROR":
+				withError = true
+			default:
+				return errorReply("ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR")
+			}
+		}
+		return boolReply(s.unblockClient(id, withError))
+	default:
+		return errorReply("ERR unknown subcommand '" + args[0] + "'. Try CLIENT ID, CLIENT UNBLOCK.")
+	}
+}
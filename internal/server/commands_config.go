@@ -0,0 +1,361 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"redis-clone/internal/glob"
+	"redis-clone/internal/logger"
+	"redis-clone/internal/protocol"
+)
+
+func (s *Server) handleConfig(args []string) *protocol.RESPValue {
+	if len(args) == 0 {
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR wrong number of arguments for 'config' command",
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		return s.handleConfigGet(args[1:])
+	case "SET":
+		return s.handleConfigSet(args[1:])
+	case "REWRITE":
+		return s.handleConfigRewrite(args[1:])
+	case "RESETSTAT":
+		return s.handleConfigResetStat(args[1:])
+	default:
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR unknown subcommand '" + args[0] + "'. Try CONFIG GET, CONFIG SET, CONFIG REWRITE, CONFIG RESETSTAT.",
+		}
+	}
+}
+
+func (s *Server) handleConfigGet(patterns []string) *protocol.RESPValue {
+	if len(patterns) == 0 {
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR wrong number of arguments for 'config|get' command",
+		}
+	}
+
+	config := s.cfg()
+	var result []string
+	for _, name := range ParamNames() {
+		for _, pattern := range patterns {
+			if glob.Match(strings.ToLower(pattern), name) {
+				value, _ := config.Get(name)
+				result = append(result, name, value)
+				break
+			}
+		}
+	}
+
+	return protocol.NewBulkArray(result)
+}
+
+// handleConfigSet applies one or more parameter/value pairs. Either all of
+// them are applied or, if any is rejected, none.
+func (s *Server) handleConfigSet(args []string) *protocol.RESPValue {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR wrong number of arguments for 'config|set' command",
+		}
+	}
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	old := s.cfg()
+	next := old.Clone()
+	seen := make(map[string]bool)
+	for i := 0; i < len(args); i += 2 {
+		name, value := strings.ToLower(args[i]), args[i+1]
+
+		param, ok := configParams[name]
+		if !ok || seen[name] {
+			return &protocol.RESPValue{
+				Type: protocol.Error,
+				Str:  "ERR Unknown option or number of arguments for CONFIG SET - '" + args[i] + "'",
+			}
+		}
+		seen[name] = true
+		if !param.mutable {
+			return configSetError(name, errors.New("can't set immutable config"))
+		}
+
+		values := []string{value}
+		if name == "save" || name == "bind" {
+			fields, err := splitConfigLine(value)
+			if err != nil {
+				return configSetError(name, err)
+			}
+			if len(fields) > 0 {
+				values = fields
+			}
+		}
+		if name == "save" {
+			next.SaveRules = nil
+		}
+		if err := param.apply(next, values); err != nil {
+			return configSetError(name, err)
+		}
+	}
+
+	s.config.Store(next)
+	s.applyConfig(next)
+
+	return &protocol.RESPValue{
+		Type: protocol.SimpleString,
+		Str:  "OK",
+	}
+}
+
+func configSetError(name string, err error) *protocol.RESPValue {
+	return &protocol.RESPValue{
+		Type: protocol.Error,
+		Str:  fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", name, err),
+	}
+}
+
+// applyConfig pushes runtime settings to the subsystems that keep their own
+// copy of them.
+func (s *Server) applyConfig(config *Config) {
+	s.persistence.SetFsyncPolicy(config.AOFSyncPolicy)
+	s.persistence.SetNoFsyncOnRewrite(config.NoAppendFsyncOnRewrite)
+	for _, db := range s.dbs {
+		db.SetHz(config.Hz)
+	}
+
+	level, _ := logger.ParseLevel(config.LogLevel)
+	logger.SetLevel(level)
+
+	s.slowlog.mu.Lock()
+	s.slowlog.trim(config.SlowlogMaxLen)
+	s.slowlog.mu.Unlock()
+}
+
+func (s *Server) handleConfigResetStat(args []string) *protocol.RESPValue {
+	if len(args) != 0 {
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR wrong number of arguments for 'config|resetstat' command",
+		}
+	}
+
+	s.stats.reset()
+	s.persistence.ResetStats()
+	for _, db := range s.dbs {
+		db.ResetExpireStats()
+	}
+
+	return &protocol.RESPValue{
+		Type: protocol.SimpleString,
+		Str:  "OK",
+	}
+}
+
+func (s *Server) handleConfigRewrite(args []string) *protocol.RESPValue {
+	if len(args) != 0 {
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR wrong number of arguments for 'config|rewrite' command",
+		}
+	}
+	if s.configPath == "" {
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR The server is running without a config file",
+		}
+	}
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	if err := rewriteConfigFile(s.configPath, s.cfg()); err != nil {
+		logger.Warningf("CONFIG REWRITE failed: %v", err)
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR Rewriting config file: " + err.Error(),
+		}
+	}
+
+	logger.Noticef("CONFIG REWRITE executed with success.")
+	return &protocol.RESPValue{
+		Type: protocol.SimpleString,
+		Str:  "OK",
+	}
+}
+
+// rewriteConfigFile writes the current settings back to path. Comments,
+// blank lines and unknown lines are kept; each known directive is updated
+// where it already appears, and settings that differ from the defaults but
+// are missing from the file are appended at the end.
+func rewriteConfigFile(path string, config *Config) error {
+	var lines []string
+	if file, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	pending := make(map[string][]string)
+	for _, name := range ParamNames() {
+		pending[name] = configLines(name, config)
+	}
+
+	var out []string
+	lastLine := make(map[string]int)
+	for _, line := range lines {
+		fields, comment, err := splitConfigComment(line)
+		if err != nil || len(fields) == 0 {
+			out = append(out, line)
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if _, known := configParams[name]; !known {
+			out = append(out, line)
+			continue
+		}
+
+		values := pending[name]
+		if len(values) == 0 {
+			// The directive now has fewer lines than before
+			continue
+		}
+		pending[name] = values[1:]
+
+		updated := values[0]
+		if normalizeConfigLine(name, fields[1:]) == updated {
+			// Unchanged: keep the original spelling, e.g. "64mb"
+			out = append(out, line)
+			lastLine[name] = len(out) - 1
+			continue
+		}
+		if comment != "" {
+			// Keep the comment in its original column when possible
+			column := strings.Index(line, comment)
+			if padding := column - len(updated); padding > 0 {
+				updated += strings.Repeat(" ", padding)
+			} else {
+				updated += " "
+			}
+			updated += comment
+		}
+		out = append(out, updated)
+		lastLine[name] = len(out) - 1
+	}
+
+	// Extra lines for directives already in the file go right after their
+	// last occurrence, starting from the end so indexes stay valid
+	names := make([]string, 0, len(lastLine))
+	for name := range lastLine {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return lastLine[names[i]] > lastLine[names[j]] })
+	for _, name := range names {
+		at := lastLine[name] + 1
+		extra := pending[name]
+		out = append(out[:at], append(append([]string(nil), extra...), out[at:]...)...)
+		delete(pending, name)
+	}
+
+	defaults := DefaultConfig()
+	header := false
+	for _, name := range ParamNames() {
+		values, ok := pending[name]
+		if !ok || len(values) == 0 {
+			continue
+		}
+		current, _ := config.Get(name)
+		original, _ := defaults.Get(name)
+		if current == original {
+			continue
+		}
+		if !header {
+			out = append(out, "", "# Generated by CONFIG REWRITE")
+			header = true
+		}
+		out = append(out, values...)
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmpName := path + ".tmp"
+	data := strings.Join(out, "\n") + "\n"
+	if err := os.WriteFile(tmpName, []byte(data), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// normalizeConfigLine returns how a directive line read from the file would
+// be written back, or "" if it is invalid.
+func normalizeConfigLine(name string, args []string) string {
+	scratch := DefaultConfig()
+	scratch.SaveRules = nil
+	if err := scratch.Apply(name, args); err != nil {
+		return ""
+	}
+	lines := configLines(name, scratch)
+	if len(lines) != 1 {
+		return ""
+	}
+	return lines[0]
+}
+
+// configLines returns the lines describing a directive in a config file.
+func configLines(name string, config *Config) []string {
+	switch name {
+	case "save":
+		if len(config.SaveRules) == 0 {
+			return []string{`save ""`}
+		}
+		lines := make([]string, len(config.SaveRules))
+		for i, rule := range config.SaveRules {
+			lines[i] = fmt.Sprintf("save %d %d", rule.Seconds, rule.Changes)
+		}
+		return lines
+	case "bind":
+		if len(config.Bind) == 0 {
+			return nil
+		}
+		return []string{"bind " + strings.Join(config.Bind, " ")}
+	}
+
+	value, _ := config.Get(name)
+	if name == "dir" {
+		value = config.Dir
+	}
+	return []string{name + " " + quoteConfigValue(value)}
+}
+
+func quoteConfigValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\"#\\\n") {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	return `"` + replacer.Replace(value) + `"`
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/protocol"
+)
+
+// expireUnits gives the unit of the time argument of each expire command
+// or option and whether it is a unix timestamp rather than relative to now.
+var expireUnits = map[string]struct {
+	unit     time.Duration
+	absolute bool
+}{
+	"EXPIRE":     {time.Second, false},
+	"PEXPIRE":    {time.Millisecond, false},
+	"EXPIREAT":   {time.Second, true},
+	"PEXPIREAT":  {time.Millisecond, true},
+	"HEXPIRE":    {time.Second, false},
+	"HPEXPIRE":   {time.Millisecond, false},
+	"HEXPIREAT":  {time.Second, true},
+	"HPEXPIREAT": {time.Millisecond, true},
+	"SETEX":      {time.Second, false},
+	"PSETEX":     {time.Millisecond, false},
+	"EX":         {time.Second, false},
+	"PX":         {time.Millisecond, false},
+	"EXAT":       {time.Second, true},
+	"PXAT":       {time.Millisecond, true},
+}
+
+// expireTime converts the time argument of an expire command to an
+// absolute time. It fails when the value does not fit a time.Duration.
+func expireTime(command string, n int64) (time.Time, bool) {
+	u := expireUnits[command]
+	if n > math.MaxInt64/int64(u.unit) || n < math.MinInt64/int64(u.unit) {
+		return time.Time{}, false
+	}
+	if u.absolute {
+		return time.Unix(0, 0).Add(time.Duration(n) * u.unit), true
+	}
+	return time.Now().Add(time.Duration(n) * u.unit), true
+}
+
+func invalidExpireReply(command string) *protocol.RESPValue {
+	return errorReply("ERR invalid expire time in '" + strings.ToLower(command) + "' command")
+}
+
+// parseExpireCondition parses the optional NX|XX|GT|LT argument of the
+// expire commands.
+func parseExpireCondition(arg string) (database.ExpireCondition, bool) {
+	switch strings.ToUpper(arg) {
+	case "NX":
+		return database.ExpireNX, true
+	case "XX":
+		return database.ExpireXX, true
+	case "GT":
+		return database.ExpireGT, true
+	case "LT":
+		return database.ExpireLT, true
+	}
+	return database.ExpireAlways, false
+}
+
+// EXPIRE key seconds [NX|XX|GT|LT]
+// PEXPIRE, EXPIREAT and PEXPIREAT take milliseconds or unix timestamps.
+func (s *Server) handleExpire(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) < 2 || len(args) > 3 {
+		return wrongArgsReply(command)
+	}
+
+	n, ok := parseInt(args[1])
+	if !ok {
+		return errorReply(errNotInteger)
+	}
+	at, ok := expireTime(command, n)
+	if !ok {
+		return invalidExpireReply(command)
+	}
+
+	cond := database.ExpireAlways
+	if len(args) == 3 {
+		if cond, ok = parseExpireCondition(args[2]); !ok {
+			return errorReply("ERR Unsupported option " + args[2])
+		}
+	}
+
+	return boolReply(db.ExpireAt(args[0], at, cond))
+}
+
+// TTL/PTTL key reply with the time to live in seconds or milliseconds,
+// EXPIRETIME/PEXPIRETIME key with the expiration as a unix timestamp.
+func (s *Server) handleTTL(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply(command)
+	}
+
+	at, exists := db.ExpireTime(args[0])
+	switch {
+	case !exists:
+		return integerReply(-2)
+	case at.IsZero():
+		return integerReply(-1)
+	}
+
+	switch command {
+	case "TTL":
+		return integerReply((time.Until(at).Milliseconds() + 500) / 1000)
+	case "PTTL":
+		return integerReply(time.Until(at).Milliseconds())
+	case "EXPIRETIME":
+		return integerReply(at.Unix())
+	}
+	return integerReply(at.UnixMilli())
+}
+
+func (s *Server) handlePersist(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply("persist")
+	}
+
+	return boolReply(db.Persist(args[0]))
+}
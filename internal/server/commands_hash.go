@@ -0,0 +1,354 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/protocol"
+)
+
+// HSET key field value [field value ...]
+// HMSET is the deprecated form replying OK.
+func (s *Server) handleHSet(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return wrongArgsReply(command)
+	}
+
+	added, err := db.HSet(args[0], args[1:])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if command == "HMSET" {
+		return okReply()
+	}
+	return integerReply(int64(added))
+}
+
+func (s *Server) handleHSetNX(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply("hsetnx")
+	}
+
+	set, err := db.HSetNX(args[0], args[1], args[2])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return boolReply(set)
+}
+
+func (s *Server) handleHGet(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("hget")
+	}
+
+	value, exists, err := db.HGet(args[0], args[1])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if !exists {
+		return nullBulkReply()
+	}
+	return bulkReply(value)
+}
+
+func (s *Server) handleHMGet(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("hmget")
+	}
+
+	values, found, err := db.HMGet(args[0], args[1:])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	reply := arrayReply()
+	for i, value := range values {
+		if found[i] {
+			reply.Array = append(reply.Array, bulkReply(value))
+		} else {
+			reply.Array = append(reply.Array, nullBulkReply())
+		}
+	}
+	return reply
+}
+
+// HGETALL/HKEYS/HVALS key
+func (s *Server) handleHGetAll(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply(command)
+	}
+
+	fields, values, err := db.HGetAll(args[0])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+
+	switch command {
+	case "HKEYS":
+		return protocol.NewBulkArray(fields)
+	case "HVALS":
+		return protocol.NewBulkArray(values)
+	}
+	return protocol.NewBulkArray(interleave(fields, values))
+}
+
+// interleave returns field, value pairs as a flat list.
+func interleave(fields, values []string) []string {
+	pairs := make([]string, 0, 2*len(fields))
+	for i, field := range fields {
+		pairs = append(pairs, field, values[i])
+	}
+	return pairs
+}
+
+func (s *Server) handleHLen(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply("hlen")
+	}
+
+	n, err := db.HLen(args[0])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+func (s *Server) handleHExists(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("hexists")
+	}
+
+	_, exists, err := db.HGet(args[0], args[1])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return boolReply(exists)
+}
+
+func (s *Server) handleHStrLen(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("hstrlen")
+	}
+
+	value, _, err := db.HGet(args[0], args[1])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(len(value)))
+}
+
+func (s *Server) handleHDel(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("hdel")
+	}
+
+	deleted, err := db.HDel(args[0], args[1:])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(deleted))
+}
+
+func (s *Server) handleHIncrBy(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply("hincrby")
+	}
+
+	incr, ok := parseInt(args[2])
+	if !ok {
+		return errorReply(errNotInteger)
+	}
+
+	n, err := db.HIncrBy(args[0], args[1], incr)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(n)
+}
+
+func (s *Server) handleHIncrByFloat(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply("hincrbyfloat")
+	}
+
+	incr, ok := parseFloat(args[2])
+	if !ok {
+		return errorReply(errNotFloat)
+	}
+
+	value, err := db.HIncrByFloat(args[0], args[1], incr)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return bulkReply(value)
+}
+
+// HRANDFIELD key [count [WITHVALUES]]
+func (s *Server) handleHRandField(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 1 || len(args) > 3 {
+		return wrongArgsReply("hrandfield")
+	}
+
+	count := int64(1)
+	if len(args) >= 2 {
+		var ok bool
+		if count, ok = parseInt(args[1]); !ok {
+			return errorReply(errNotInteger)
+		}
+	}
+	withValues := len(args) == 3
+	if withValues && strings.ToUpper(args[2]) != "WITHVALUES" {
+		return errorReply(errSyntax)
+	}
+
+	fields, values, err := db.HRandField(args[0], int(count))
+	if err != nil {
+		return errorReply(err.Error())
+	}
+
+	if len(args) == 1 {
+		if len(fields) == 0 {
+			return nullBulkReply()
+		}
+		return bulkReply(fields[0])
+	}
+	if withValues {
+		return protocol.NewBulkArray(interleave(fields, values))
+	}
+	return protocol.NewBulkArray(fields)
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+func (s *Server) handleHScan(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("hscan")
+	}
+
+	scan, errReply := parseScanArgs(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+
+	cursor, fields, values, err := db.HScan(args[0], scan.cursor, scan.count)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	var elements []string
+	for i, field := range fields {
+		if scan.matches(field) {
+			elements = append(elements, field, values[i])
+		}
+	}
+	return scanReply(cursor, elements)
+}
+
+// parseFields parses "FIELDS numfields field [field ...]".
+func parseFields(args []string) ([]string, *protocol.RESPValue) {
+	if len(args) < 2 || strings.ToUpper(args[0]) != "FIELDS" {
+		return nil, errorReply("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, ok := parseInt(args[1])
+	if !ok || n <= 0 {
+		return nil, errorReply("ERR Parameter `numFields` should be greater than 0")
+	}
+	if n != int64(len(args)-2) {
+		return nil, errorReply("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// HEXPIRE/HPEXPIRE/HEXPIREAT/HPEXPIREAT key time [NX|XX|GT|LT]
+// FIELDS numfields field [field ...]
+func (s *Server) handleHExpire(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) < 4 {
+		return wrongArgsReply(command)
+	}
+
+	n, ok := parseInt(args[1])
+	if !ok {
+		return errorReply(errNotInteger)
+	}
+	at, ok := expireTime(command, n)
+	if !ok {
+		return invalidExpireReply(command)
+	}
+
+	rest := args[2:]
+	cond, ok := parseExpireCondition(rest[0])
+	if ok {
+		rest = rest[1:]
+	}
+	fields, errReply := parseFields(rest)
+	if errReply != nil {
+		return errReply
+	}
+
+	results, err := db.HExpire(args[0], at, cond, fields)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integersReply(results)
+}
+
+func integersReply(values []int) *protocol.RESPValue {
+	reply := arrayReply()
+	for _, v := range values {
+		reply.Array = append(reply.Array, integerReply(int64(v)))
+	}
+	return reply
+}
+
+// HTTL/HPTTL/HEXPIRETIME/HPEXPIRETIME key FIELDS numfields field [field ...]
+func (s *Server) handleHTTL(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) < 3 {
+		return wrongArgsReply(command)
+	}
+
+	fields, errReply := parseFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+
+	expiry, exists, err := db.HFieldExpiry(args[0], fields)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+
+	results := make([]int, len(fields))
+	now := time.Now()
+	for i, at := range expiry {
+		switch {
+		case !exists[i]:
+			results[i] = database.FieldMissing
+		case at.IsZero():
+			results[i] = database.FieldNoTTL
+		case command == "HTTL":
+			results[i] = int(at.Sub(now).Round(time.Second) / time.Second)
+		case command == "HPTTL":
+			results[i] = int(at.Sub(now).Milliseconds())
+		case command == "HEXPIRETIME":
+			results[i] = int(at.Unix())
+		default:
+			results[i] = int(at.UnixMilli())
+		}
+	}
+	return integersReply(results)
+}
+
+// HPERSIST key FIELDS numfields field [field ...]
+func (s *Server) handleHPersist(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 3 {
+		return wrongArgsReply("hpersist")
+	}
+
+	fields, errReply := parseFields(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+
+	results, err := db.HPersist(args[0], fields)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integersReply(results)
+}
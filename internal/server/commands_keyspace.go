@@ -0,0 +1,209 @@
+package server
+
+import (
+	"strings"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/protocol"
+)
+
+const errDBIndex = "ERR DB index is out of range"
+
+// parseDBIndex parses the index of a database. It returns false in range
+// when the number is valid but no such database exists.
+func (s *Server) parseDBIndex(arg string) (index int, ok, inRange bool) {
+	n, ok := parseInt(arg)
+	if !ok {
+		return 0, false, false
+	}
+	return int(n), true, n >= 0 && n < int64(len(s.dbs))
+}
+
+// SELECT index changes the database used by the following commands of the
+// connection.
+func (s *Server) handleSelect(client *Client, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply("select")
+	}
+
+	index, ok, inRange := s.parseDBIndex(args[0])
+	switch {
+	case !ok:
+		return errorReply(errNotInteger)
+	case !inRange:
+		return errorReply(errDBIndex)
+	}
+	client.db = index
+	return okReply()
+}
+
+// MOVE key db
+func (s *Server) handleMove(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("move")
+	}
+
+	index, ok, inRange := s.parseDBIndex(args[1])
+	switch {
+	case !ok:
+		return errorReply(errNotInteger)
+	case !inRange:
+		return errorReply(errDBIndex)
+	case index == db.ID():
+		return errorReply("ERR source and destination objects are the same")
+	}
+	return boolReply(db.Move(args[0], s.dbs[index]))
+}
+
+// SWAPDB index1 index2 exchanges the keys of two databases. Clients stay
+// connected to the same index and see the keys of the other database.
+func (s *Server) handleSwapDB(args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("swapdb")
+	}
+
+	first, ok1, inRange1 := s.parseDBIndex(args[0])
+	second, ok2, inRange2 := s.parseDBIndex(args[1])
+	switch {
+	case !ok1:
+		return errorReply("ERR invalid first DB index")
+	case !ok2:
+		return errorReply("ERR invalid second DB index")
+	case !inRange1 || !inRange2:
+		return errorReply(errDBIndex)
+	}
+	s.dbs[first].Swap(s.dbs[second])
+	s.signalBlockedDBs(first, second)
+	return okReply()
+}
+
+// parseFlushMode checks the optional ASYNC or SYNC argument of FLUSHDB and
+// FLUSHALL. Flushing never waits for the memory to be reclaimed, so both
+// behave the same.
+func parseFlushMode(args []string) bool {
+	if len(args) == 0 {
+		return true
+	}
+	mode := strings.ToUpper(args[0])
+	return len(args) == 1 && (mode == "ASYNC" || mode == "SYNC")
+}
+
+// FLUSHDB [ASYNC|SYNC]
+func (s *Server) handleFlushDB(db *database.Database, args []string) *protocol.RESPValue {
+	if !parseFlushMode(args) {
+		return errorReply(errSyntax)
+	}
+
+	db.Flush()
+	return okReply()
+}
+
+// FLUSHALL [ASYNC|SYNC]
+func (s *Server) handleFlushAll(args []string) *protocol.RESPValue {
+	if !parseFlushMode(args) {
+		return errorReply(errSyntax)
+	}
+
+	for _, db := range s.dbs {
+		db.Flush()
+	}
+	return okReply()
+}
+
+func (s *Server) handleType(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply("type")
+	}
+
+	typ, exists := db.Type(args[0])
+	if !exists {
+		return simpleReply("none")
+	}
+	return simpleReply(string(typ))
+}
+
+func (s *Server) handleDBSize(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 0 {
+		return wrongArgsReply("dbsize")
+	}
+	return integerReply(int64(db.Size()))
+}
+
+// RENAME key newkey
+// RENAMENX key newkey only renames when newkey does not exist.
+func (s *Server) handleRename(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply(command)
+	}
+
+	renamed, err := db.Rename(args[0], args[1], command == "RENAMENX")
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if command == "RENAMENX" {
+		return boolReply(renamed)
+	}
+	return okReply()
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func (s *Server) handleCopy(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("copy")
+	}
+
+	dstDB := db
+	replace := false
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "REPLACE":
+			replace = true
+		case option == "DB" && i+1 < len(args):
+			index, ok, inRange := s.parseDBIndex(args[i+1])
+			switch {
+			case !ok:
+				return errorReply(errNotInteger)
+			case !inRange:
+				return errorReply(errDBIndex)
+			}
+			dstDB = s.dbs[index]
+			i++
+		default:
+			return errorReply(errSyntax)
+		}
+	}
+	if dstDB == db && args[0] == args[1] {
+		return errorReply("ERR source and destination objects are the same")
+	}
+
+	return boolReply(db.Copy(args[0], dstDB, args[1], replace))
+}
+
+func (s *Server) handleRandomKey(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 0 {
+		return wrongArgsReply("randomkey")
+	}
+
+	key, ok := db.RandomKey()
+	if !ok {
+		return nullBulkReply()
+	}
+	return bulkReply(key)
+}
+
+// TOUCH key [key ...] updates the access time used by the LRU and LFU
+// eviction policies.
+func (s *Server) handleTouch(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) == 0 {
+		return wrongArgsReply("touch")
+	}
+	return integerReply(int64(db.Touch(args)))
+}
+
+// UNLINK key [key ...] is DEL releasing large values in the background.
+func (s *Server) handleUnlink(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) == 0 {
+		return wrongArgsReply("unlink")
+	}
+	return integerReply(int64(db.Unlink(args)))
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"strings"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/protocol"
+)
+
+// LPUSH/RPUSH/LPUSHX/RPUSHX key element [element ...]
+func (s *Server) handlePush(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply(command)
+	}
+
+	left := command[0] == 'L'
+	onlyIfExists := strings.HasSuffix(command, "X")
+	n, err := db.Push(args[0], args[1:], left, onlyIfExists)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+// LPOP/RPOP key [count]
+func (s *Server) handlePop(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgsReply(command)
+	}
+
+	count := int64(1)
+	if len(args) == 2 {
+		var ok bool
+		if count, ok = parseInt(args[1]); !ok || count < 0 {
+			return errorReply(errNotPositive)
+		}
+	}
+
+	items, err := db.Pop(args[0], command == "LPOP", int(count))
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if len(args) == 2 {
+		if items == nil {
+			return nullArrayReply()
+		}
+		return protocol.NewBulkArray(items)
+	}
+	if len(items) == 0 {
+		return nullBulkReply()
+	}
+	return bulkReply(items[0])
+}
+
+func (s *Server) handleLLen(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply("llen")
+	}
+
+	n, err := db.LLen(args[0])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+func (s *Server) handleLRange(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply("lrange")
+	}
+
+	start, ok1 := parseInt(args[1])
+	stop, ok2 := parseInt(args[2])
+	if !ok1 || !ok2 {
+		return errorReply(errNotInteger)
+	}
+
+	items, err := db.LRange(args[0], int(start), int(stop))
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return protocol.NewBulkArray(items)
+}
+
+func (s *Server) handleLIndex(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("lindex")
+	}
+
+	index, ok := parseInt(args[1])
+	if !ok {
+		return errorReply(errNotInteger)
+	}
+
+	item, exists, err := db.LIndex(args[0], int(index))
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if !exists {
+		return nullBulkReply()
+	}
+	return bulkReply(item)
+}
+
+func (s *Server) handleLSet(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply("lset")
+	}
+
+	index, ok := parseInt(args[1])
+	if !ok {
+		return errorReply(errNotInteger)
+	}
+
+	if err := db.LSet(args[0], int(index), args[2]); err != nil {
+		return errorReply(err.Error())
+	}
+	return okReply()
+}
+
+// LINSERT key BEFORE|AFTER pivot element
+func (s *Server) handleLInsert(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 4 {
+		return wrongArgsReply("linsert")
+	}
+
+	var before bool
+	switch strings.ToUpper(args[1]) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+	default:
+		return errorReply(errSyntax)
+	}
+
+	n, err := db.LInsert(args[0], before, args[2], args[3])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+func (s *Server) handleLRem(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply("lrem")
+	}
+
+	count, ok := parseInt(args[1])
+	if !ok {
+		return errorReply(errNotInteger)
+	}
+
+	n, err := db.LRem(args[0], int(count), args[2])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+func (s *Server) handleLTrim(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply("ltrim")
+	}
+
+	start, ok1 := parseInt(args[1])
+	stop, ok2 := parseInt(args[2])
+	if !ok1 || !ok2 {
+		return errorReply(errNotInteger)
+	}
+
+	if err := db.LTrim(args[0], int(start), int(stop)); err != nil {
+		return errorReply(err.Error())
+	}
+	return okReply()
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func (s *Server) handleLPos(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return wrongArgsReply("lpos")
+	}
+
+	rank, count, maxlen := int64(1), int64(-1), int64(0)
+	for i := 2; i < len(args); i += 2 {
+		n, ok := parseInt(args[i+1])
+		if !ok {
+			return errorReply(errNotInteger)
+		}
+		switch strings.ToUpper(args[i]) {
+		case "RANK":
+			if n == 0 {
+				return errorReply("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return errorReply("ERR COUNT can't be negative")
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return errorReply("ERR MAXLEN can't be negative")
+			}
+			maxlen = n
+		default:
+			return errorReply(errSyntax)
+		}
+	}
+
+	limit := count
+	if limit < 0 {
+		limit = 1
+	}
+	positions, err := db.LPos(args[0], args[1], int(rank), int(limit), int(maxlen))
+	if err != nil {
+		return errorReply(err.Error())
+	}
+
+	if count < 0 {
+		if len(positions) == 0 {
+			return nullBulkReply()
+		}
+		return integerReply(int64(positions[0]))
+	}
+	reply := arrayReply()
+	for _, pos := range positions {
+		reply.Array = append(reply.Array, integerReply(int64(pos)))
+	}
+	return reply
+}
+
+// parseListEnd parses the LEFT|RIGHT argument of LMOVE and LMPOP.
+func parseListEnd(arg string) (left bool, ok bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func (s *Server) handleLMove(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 4 {
+		return wrongArgsReply("lmove")
+	}
+
+	fromLeft, ok1 := parseListEnd(args[2])
+	toLeft, ok2 := parseListEnd(args[3])
+	if !ok1 || !ok2 {
+		return errorReply(errSyntax)
+	}
+
+	item, exists, err := db.LMove(args[0], args[1], fromLeft, toLeft)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if !exists {
+		return nullBulkReply()
+	}
+	return bulkReply(item)
+}
+
+// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func (s *Server) handleLMPop(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 3 {
+		return wrongArgsReply("lmpop")
+	}
+
+	keys, left, count, errReply := parseLMPop(args)
+	if errReply != nil {
+		return errReply
+	}
+
+	key, items, err := db.LMPop(keys, left, count)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if key == "" {
+		return nullArrayReply()
+	}
+	return arrayReply(bulkReply(key), protocol.NewBulkArray(items))
+}
+
+// BLPOP/BRPOP key [key ...] timeout
+//
+// Like the other blocking commands, it does not wait when run from here:
+// this is the attempt made before blocking, see blocking.go, and the form
+// run inside transactions, where it replies like a timeout.
+func (s *Server) handleBPop(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply(command)
+	}
+	if _, errReply := parseTimeout(args[len(args)-1]); errReply != nil {
+		return errReply
+	}
+
+	key, items, err := db.LMPop(args[:len(args)-1], command == "BLPOP", 1)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if key == "" {
+		return nullArrayReply()
+	}
+	return arrayReply(bulkReply(key), bulkReply(items[0]))
+}
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func (s *Server) handleBLMove(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 5 {
+		return wrongArgsReply("blmove")
+	}
+	if _, errReply := parseTimeout(args[4]); errReply != nil {
+		return errReply
+	}
+	return s.handleLMove(db, args[:4])
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func (s *Server) handleBLMPop(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 4 {
+		return wrongArgsReply("blmpop")
+	}
+	if _, errReply := parseTimeout(args[0]); errReply != nil {
+		return errReply
+	}
+	return s.handleLMPop(db, args[1:])
+}
+
+// parseLMPop parses the arguments shared by LMPOP and BLMPOP, starting at
+// numkeys.
+func parseLMPop(args []string) (keys []string, left bool, count int, errReply *protocol.RESPValue) {
+	numKeys, ok := parseInt(args[0])
+	if !ok {
+		return nil, false, 0, errorReply(errNotInteger)
+	}
+	if numKeys <= 0 {
+		return nil, false, 0, errorReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-2) {
+		return nil, false, 0, errorReply(errSyntax)
+	}
+	keys = args[1 : 1+numKeys]
+	rest := args[1+numKeys:]
+
+	if left, ok = parseListEnd(rest[0]); !ok {
+		return nil, false, 0, errorReply(errSyntax)
+	}
+
+	count = 1
+	switch {
+	case len(rest) == 1:
+	case len(rest) == 3 && strings.ToUpper(rest[1]) == "COUNT":
+		n, ok := parseInt(rest[2])
+		if !ok || n <= 0 {
+			return nil, false, 0, errorReply("ERR count should be greater than 0")
+		}
+		count = int(n)
+	default:
+		return nil, false, 0, errorReply(errSyntax)
+	}
+	return keys, left, count, nil
+}
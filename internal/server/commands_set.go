@@ -0,0 +1,240 @@
+package server
+
+import (
+	"strings"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/protocol"
+)
+
+func (s *Server) handleSAdd(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("sadd")
+	}
+
+	n, err := db.SAdd(args[0], args[1:])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+func (s *Server) handleSRem(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("srem")
+	}
+
+	n, err := db.SRem(args[0], args[1:])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+func (s *Server) handleSIsMember(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("sismember")
+	}
+
+	found, err := db.SMIsMember(args[0], args[1:])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return boolReply(found[0])
+}
+
+func (s *Server) handleSMIsMember(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("smismember")
+	}
+
+	found, err := db.SMIsMember(args[0], args[1:])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	reply := arrayReply()
+	for _, ok := range found {
+		reply.Array = append(reply.Array, boolReply(ok))
+	}
+	return reply
+}
+
+func (s *Server) handleSMembers(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply("smembers")
+	}
+
+	members, err := db.SMembers(args[0])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return protocol.NewBulkArray(members)
+}
+
+func (s *Server) handleSCard(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply("scard")
+	}
+
+	n, err := db.SCard(args[0])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+// SPOP key [count]
+func (s *Server) handleSPop(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgsReply("spop")
+	}
+
+	count := int64(1)
+	if len(args) == 2 {
+		var ok bool
+		if count, ok = parseInt(args[1]); !ok || count < 0 {
+			return errorReply(errNotPositive)
+		}
+	}
+
+	members, err := db.SPop(args[0], int(count))
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if len(args) == 2 {
+		return protocol.NewBulkArray(members)
+	}
+	if len(members) == 0 {
+		return nullBulkReply()
+	}
+	return bulkReply(members[0])
+}
+
+// SRANDMEMBER key [count]
+func (s *Server) handleSRandMember(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgsReply("srandmember")
+	}
+
+	count := int64(1)
+	if len(args) == 2 {
+		var ok bool
+		if count, ok = parseInt(args[1]); !ok {
+			return errorReply(errNotInteger)
+		}
+	}
+
+	members, err := db.SRandMember(args[0], int(count))
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if len(args) == 2 {
+		return protocol.NewBulkArray(members)
+	}
+	if len(members) == 0 {
+		return nullBulkReply()
+	}
+	return bulkReply(members[0])
+}
+
+func (s *Server) handleSMove(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply("smove")
+	}
+
+	moved, err := db.SMove(args[0], args[1], args[2])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return boolReply(moved)
+}
+
+var setOps = map[string]database.SetOp{
+	"SINTER": database.SetInter,
+	"SUNION": database.SetUnion,
+	"SDIFF":  database.SetDiff,
+}
+
+// SINTER/SUNION/SDIFF key [key ...]
+func (s *Server) handleSetOp(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) < 1 {
+		return wrongArgsReply(command)
+	}
+
+	members, err := db.SetCompute(setOps[command], args)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return protocol.NewBulkArray(members)
+}
+
+// SINTERSTORE/SUNIONSTORE/SDIFFSTORE destination key [key ...]
+func (s *Server) handleSetOpStore(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply(command)
+	}
+
+	op := setOps[strings.TrimSuffix(command, "STORE")]
+	n, err := db.SetStore(op, args[0], args[1:])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+func (s *Server) handleSInterCard(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("sintercard")
+	}
+
+	numKeys, ok := parseInt(args[0])
+	if !ok {
+		return errorReply(errNotInteger)
+	}
+	if numKeys <= 0 {
+		return errorReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-1) {
+		return errorReply("ERR Number of keys can't be greater than number of args")
+	}
+	keys := args[1 : 1+numKeys]
+
+	var limit int64
+	switch rest := args[1+numKeys:]; {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.ToUpper(rest[0]) == "LIMIT":
+		if limit, ok = parseInt(rest[1]); !ok {
+			return errorReply(errNotInteger)
+		}
+		if limit < 0 {
+			return errorReply("ERR LIMIT can't be negative")
+		}
+	default:
+		return errorReply(errSyntax)
+	}
+
+	n, err := db.SInterCard(keys, int(limit))
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func (s *Server) handleSScan(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("sscan")
+	}
+
+	scan, errReply := parseScanArgs(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+
+	cursor, members, err := db.SScan(args[0], scan.cursor, scan.count)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return scanReply(cursor, scan.filter(members))
+}
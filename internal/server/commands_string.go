@@ -0,0 +1,403 @@
+package server
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/protocol"
+)
+
+// parseExpireOption parses the value of an EX, PX, EXAT or PXAT option,
+// which must be positive.
+func parseExpireOption(command, option, arg string) (time.Time, *protocol.RESPValue) {
+	n, ok := parseInt(arg)
+	if !ok {
+		return time.Time{}, errorReply(errNotInteger)
+	}
+	at, ok := expireTime(option, n)
+	if !ok || n <= 0 {
+		return time.Time{}, invalidExpireReply(command)
+	}
+	return at, nil
+}
+
+func isExpireOption(option string) bool {
+	switch option {
+	case "EX", "PX", "EXAT", "PXAT":
+		return true
+	}
+	return false
+}
+
+// SET key value [NX|XX] [GET] [EX seconds|PX ms|EXAT ts|PXAT ms-ts|KEEPTTL]
+func (s *Server) handleSet(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("set")
+	}
+
+	var opts database.SetOptions
+	hasExpire := false
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch {
+		case option == "NX" && !opts.XX:
+			opts.NX = true
+		case option == "XX" && !opts.NX:
+			opts.XX = true
+		case option == "GET":
+			opts.Get = true
+		case option == "KEEPTTL" && !hasExpire:
+			opts.KeepTTL = true
+		case isExpireOption(option) && !hasExpire && !opts.KeepTTL && i+1 < len(args):
+			at, errReply := parseExpireOption("set", option, args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			opts.ExpireAt = at
+			hasExpire = true
+			i++
+		default:
+			return errorReply(errSyntax)
+		}
+	}
+
+	res, err := db.SetString(args[0], args[1], opts)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	switch {
+	case opts.Get && res.Existed:
+		return bulkReply(res.Old)
+	case opts.Get, !res.Stored:
+		return nullBulkReply()
+	}
+	return okReply()
+}
+
+func (s *Server) handleSetNX(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("setnx")
+	}
+
+	res, _ := db.SetString(args[0], args[1], database.SetOptions{NX: true})
+	return boolReply(res.Stored)
+}
+
+// SETEX key seconds value
+// PSETEX key milliseconds value
+func (s *Server) handleSetEx(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply(command)
+	}
+
+	at, errReply := parseExpireOption(command, command, args[1])
+	if errReply != nil {
+		return errReply
+	}
+	db.SetString(args[0], args[2], database.SetOptions{ExpireAt: at})
+	return okReply()
+}
+
+func (s *Server) handleGet(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply("get")
+	}
+
+	value, exists, err := db.GetString(args[0])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if !exists {
+		return nullBulkReply()
+	}
+	return bulkReply(value)
+}
+
+func (s *Server) handleGetSet(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("getset")
+	}
+
+	res, err := db.SetString(args[0], args[1], database.SetOptions{Get: true})
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if !res.Existed {
+		return nullBulkReply()
+	}
+	return bulkReply(res.Old)
+}
+
+func (s *Server) handleGetDel(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply("getdel")
+	}
+
+	value, exists, err := db.GetDel(args[0])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if !exists {
+		return nullBulkReply()
+	}
+	return bulkReply(value)
+}
+
+// GETEX key [EX seconds|PX ms|EXAT ts|PXAT ms-ts|PERSIST]
+func (s *Server) handleGetEx(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 1 {
+		return wrongArgsReply("getex")
+	}
+
+	var at time.Time
+	persist := false
+	switch {
+	case len(args) == 1:
+	case len(args) == 2 && strings.ToUpper(args[1]) == "PERSIST":
+		persist = true
+	case len(args) == 3 && isExpireOption(strings.ToUpper(args[1])):
+		var errReply *protocol.RESPValue
+		if at, errReply = parseExpireOption("getex", strings.ToUpper(args[1]), args[2]); errReply != nil {
+			return errReply
+		}
+	default:
+		return errorReply(errSyntax)
+	}
+
+	value, exists, err := db.GetEx(args[0], at, persist)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if !exists {
+		return nullBulkReply()
+	}
+	return bulkReply(value)
+}
+
+func (s *Server) handleMGet(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 1 {
+		return wrongArgsReply("mget")
+	}
+
+	values, found := db.MGet(args)
+	reply := arrayReply()
+	for i, value := range values {
+		if found[i] {
+			reply.Array = append(reply.Array, bulkReply(value))
+		} else {
+			reply.Array = append(reply.Array, nullBulkReply())
+		}
+	}
+	return reply
+}
+
+// MSET key value [key value ...]
+// MSETNX sets nothing if any of the keys exists.
+func (s *Server) handleMSet(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return wrongArgsReply(command)
+	}
+
+	set := db.MSet(args, command == "MSETNX")
+	if command == "MSETNX" {
+		return boolReply(set)
+	}
+	return okReply()
+}
+
+func (s *Server) handleAppend(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("append")
+	}
+
+	n, err := db.Append(args[0], args[1])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+func (s *Server) handleStrLen(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply("strlen")
+	}
+
+	value, _, err := db.GetString(args[0])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(len(value)))
+}
+
+// GETRANGE key start end, with inclusive offsets that may be negative.
+func (s *Server) handleGetRange(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply("getrange")
+	}
+
+	start, ok1 := parseInt(args[1])
+	end, ok2 := parseInt(args[2])
+	if !ok1 || !ok2 {
+		return errorReply(errNotInteger)
+	}
+
+	value, _, err := db.GetString(args[0])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+
+	size := int64(len(value))
+	if start < 0 && end < 0 && start > end {
+		return bulkReply("")
+	}
+	if start < 0 {
+		start = max(size+start, 0)
+	}
+	if end < 0 {
+		end = max(size+end, 0)
+	}
+	end = min(end, size-1)
+	if start > end || size == 0 {
+		return bulkReply("")
+	}
+	return bulkReply(value[start : end+1])
+}
+
+// SETRANGE key offset value
+func (s *Server) handleSetRange(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply("setrange")
+	}
+
+	offset, ok := parseInt(args[1])
+	if !ok {
+		return errorReply(errNotInteger)
+	}
+	if offset < 0 {
+		return errorReply("ERR offset is out of range")
+	}
+	if offset > database.MaxStringLength {
+		return errorReply(database.ErrStringTooLong.Error())
+	}
+
+	n, err := db.SetRange(args[0], int(offset), args[2])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+// INCR and DECR key
+// INCRBY and DECRBY key increment
+func (s *Server) handleIncrBy(db *database.Database, command string, args []string) *protocol.RESPValue {
+	incr := int64(1)
+	switch command {
+	case "INCR", "DECR":
+		if len(args) != 1 {
+			return wrongArgsReply(command)
+		}
+	default:
+		if len(args) != 2 {
+			return wrongArgsReply(command)
+		}
+		var ok bool
+		if incr, ok = parseInt(args[1]); !ok {
+			return errorReply(errNotInteger)
+		}
+	}
+	if command == "DECR" || command == "DECRBY" {
+		if incr == math.MinInt64 {
+			return errorReply("ERR decrement would overflow")
+		}
+		incr = -incr
+	}
+
+	n, err := db.IncrBy(args[0], incr)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(n)
+}
+
+func (s *Server) handleIncrByFloat(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("incrbyfloat")
+	}
+
+	incr, ok := parseFloat(args[1])
+	if !ok {
+		return errorReply(database.ErrNotFloat.Error())
+	}
+
+	value, err := db.IncrByFloat(args[0], incr)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return bulkReply(value)
+}
+
+// LCS key1 key2 [LEN] [IDX] [MINMATCHLEN len] [WITHMATCHLEN]
+func (s *Server) handleLCS(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("lcs")
+	}
+
+	var withLen, withIdx, withMatchLen bool
+	minMatchLen := int64(0)
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "LEN":
+			withLen = true
+		case "IDX":
+			withIdx = true
+		case "WITHMATCHLEN":
+			withMatchLen = true
+		case "MINMATCHLEN":
+			if i+1 == len(args) {
+				return errorReply(errSyntax)
+			}
+			var ok bool
+			if minMatchLen, ok = parseInt(args[i+1]); !ok {
+				return errorReply(errNotInteger)
+			}
+			i++
+		default:
+			return errorReply(errSyntax)
+		}
+	}
+	if withLen && withIdx {
+		return errorReply("ERR If you want both the length and indexes, please just use IDX.")
+	}
+
+	seq, matches, err := db.LCS(args[0], args[1])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	switch {
+	case withLen:
+		return integerReply(int64(len(seq)))
+	case !withIdx:
+		return bulkReply(seq)
+	}
+
+	ranges := arrayReply()
+	for _, m := range matches {
+		if int64(m.Len()) < minMatchLen {
+			continue
+		}
+		match := arrayReply(
+			arrayReply(integerReply(int64(m.AStart)), integerReply(int64(m.AEnd))),
+			arrayReply(integerReply(int64(m.BStart)), integerReply(int64(m.BEnd))),
+		)
+		if withMatchLen {
+			match.Array = append(match.Array, integerReply(int64(m.Len())))
+		}
+		ranges.Array = append(ranges.Array, match)
+	}
+	return arrayReply(
+		bulkReply("matches"), ranges,
+		bulkReply("len"), integerReply(int64(len(seq))),
+	)
+}
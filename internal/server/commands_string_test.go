@@ -0,0 +1,67 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentIncr(t *testing.T) {
+	s := newTestServer(t, nil)
+	const clients, increments = 20, 100
+
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for i := 0; i < clients; i++ {
+		client := newTestClient(t, s)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			start.Wait()
+			for j := 0; j < increments; j++ {
+				command := []string{"INCR", "counter"}
+				if j%2 == 1 {
+					command = []string{"INCRBY", "counter", "1"}
+				}
+				if reply := s.do(client, command...); reply.Str != "" {
+					t.Errorf("%q replied %q", command, reply.Str)
+					return
+				}
+			}
+		}()
+	}
+	start.Done()
+	done.Wait()
+
+	want := strconv.Itoa(clients * increments)
+	if reply := s.do(newTestClient(t, s), "GET", "counter"); reply.Str != want {
+		t.Fatalf("counter = %q after %s increments", reply.Str, want)
+	}
+}
+
+func TestIncrKeepsTTL(t *testing.T) {
+	s := newTestServer(t, nil)
+	client := newTestClient(t, s)
+
+	s.do(client, "SET", "counter", "10", "EX", "100")
+	for _, command := range [][]string{
+		{"INCR", "counter"},
+		{"DECRBY", "counter", "3"},
+		{"INCRBYFLOAT", "counter", "0.5"},
+		{"APPEND", "counter", "0"},
+	} {
+		s.do(client, command...)
+		if reply := s.do(client, "TTL", "counter"); reply.Num <= 0 || reply.Num > 100 {
+			t.Errorf("TTL = %d after %q", reply.Num, command)
+		}
+	}
+	if reply := s.do(client, "GET", "counter"); reply.Str != "8.50" {
+		t.Errorf("counter = %q, want 8.50", reply.Str)
+	}
+
+	// SET replaces the value and drops the TTL
+	s.do(client, "SET", "counter", "1")
+	if reply := s.do(client, "TTL", "counter"); reply.Num != -1 {
+		t.Errorf("TTL = %d after SET, want -1", reply.Num)
+	}
+}
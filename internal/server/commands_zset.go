@@ -0,0 +1,514 @@
+package server
+
+import (
+	"strings"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/protocol"
+)
+
+const (
+	errNotFloat     = "ERR value is not a valid float"
+	errScoreRange   = "ERR min or max is not a float"
+	errLexRange     = "ERR min or max not valid string range item"
+	errZAddIncrPair = "ERR INCR option supports a single increment-element pair"
+)
+
+// zmembersReply encodes members as a flat array, with their scores when
+// withScores is set.
+func zmembersReply(members []database.ZMember, withScores bool) *protocol.RESPValue {
+	reply := arrayReply()
+	for _, m := range members {
+		reply.Array = append(reply.Array, bulkReply(m.Member))
+		if withScores {
+			reply.Array = append(reply.Array, bulkReply(formatFloat(m.Score)))
+		}
+	}
+	return reply
+}
+
+// parseScoreRange parses ZRANGEBYSCORE-style bounds such as "(1" or "-inf".
+func parseScoreRange(min, max string) (database.ScoreRange, bool) {
+	var r database.ScoreRange
+	var ok1, ok2 bool
+	r.Min, r.MinEx, ok1 = parseScoreBound(min)
+	r.Max, r.MaxEx, ok2 = parseScoreBound(max)
+	return r, ok1 && ok2
+}
+
+func parseScoreBound(arg string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+	f, ok := parseFloat(arg)
+	return f, exclusive, ok
+}
+
+// parseLexRange parses ZRANGEBYLEX-style bounds: "[a" and "(a" are
+// inclusive and exclusive, "-" and "+" the lowest and highest strings.
+func parseLexRange(min, max string) (database.LexRange, bool) {
+	var r database.LexRange
+	// An empty range: "" is both the minimum and excluded
+	empty := database.LexRange{MaxEx: true}
+
+	switch {
+	case min == "-":
+		r.MinInf = true
+	case min == "+":
+		return empty, max == "+" || max == "-" || validLexBound(max)
+	case validLexBound(min):
+		r.Min, r.MinEx = min[1:], min[0] == '('
+	default:
+		return r, false
+	}
+
+	switch {
+	case max == "+":
+		r.MaxInf = true
+	case max == "-":
+		return empty, true
+	case validLexBound(max):
+		r.Max, r.MaxEx = max[1:], max[0] == '('
+	default:
+		return r, false
+	}
+	return r, true
+}
+
+func validLexBound(arg string) bool {
+	return arg != "" && (arg[0] == '[' || arg[0] == '(')
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func (s *Server) handleZAdd(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 3 {
+		return wrongArgsReply("zadd")
+	}
+
+	var flags database.ZAddFlags
+	var ch bool
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			flags.NX = true
+		case "XX":
+			flags.XX = true
+		case "GT":
+			flags.GT = true
+		case "LT":
+			flags.LT = true
+		case "CH":
+			ch = true
+		case "INCR":
+			flags.Incr = true
+		default:
+			break flags
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return errorReply(errSyntax)
+	}
+	if flags.NX && flags.XX {
+		return errorReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (flags.GT && flags.LT) || (flags.NX && (flags.GT || flags.LT)) {
+		return errorReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if flags.Incr && len(pairs) != 2 {
+		return errorReply(errZAddIncrPair)
+	}
+
+	members := make([]database.ZMember, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, ok := parseFloat(pairs[j])
+		if !ok {
+			return errorReply(errNotFloat)
+		}
+		members = append(members, database.ZMember{Member: pairs[j+1], Score: score})
+	}
+
+	result, err := db.ZAdd(args[0], flags, members)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if flags.Incr {
+		if !result.Applied {
+			return nullBulkReply()
+		}
+		return bulkReply(formatFloat(result.Score))
+	}
+	if ch {
+		return integerReply(int64(result.Added + result.Changed))
+	}
+	return integerReply(int64(result.Added))
+}
+
+func (s *Server) handleZIncrBy(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply("zincrby")
+	}
+
+	incr, ok := parseFloat(args[1])
+	if !ok {
+		return errorReply(errNotFloat)
+	}
+
+	member := database.ZMember{Member: args[2], Score: incr}
+	result, err := db.ZAdd(args[0], database.ZAddFlags{Incr: true}, []database.ZMember{member})
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return bulkReply(formatFloat(result.Score))
+}
+
+func (s *Server) handleZRem(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("zrem")
+	}
+
+	n, err := db.ZRem(args[0], args[1:])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+func (s *Server) handleZScore(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("zscore")
+	}
+
+	scores, found, err := db.ZMScore(args[0], args[1:])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if !found[0] {
+		return nullBulkReply()
+	}
+	return bulkReply(formatFloat(scores[0]))
+}
+
+func (s *Server) handleZMScore(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("zmscore")
+	}
+
+	scores, found, err := db.ZMScore(args[0], args[1:])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	reply := arrayReply()
+	for i, score := range scores {
+		if found[i] {
+			reply.Array = append(reply.Array, bulkReply(formatFloat(score)))
+		} else {
+			reply.Array = append(reply.Array, nullBulkReply())
+		}
+	}
+	return reply
+}
+
+func (s *Server) handleZCard(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 1 {
+		return wrongArgsReply("zcard")
+	}
+
+	n, err := db.ZCard(args[0])
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+func (s *Server) handleZCount(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply("zcount")
+	}
+
+	r, ok := parseScoreRange(args[1], args[2])
+	if !ok {
+		return errorReply(errScoreRange)
+	}
+
+	n, err := db.ZCount(args[0], r)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+// ZRANK/ZREVRANK key member
+func (s *Server) handleZRank(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply(command)
+	}
+
+	rank, exists, err := db.ZRank(args[0], args[1], command == "ZREVRANK")
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	if !exists {
+		return nullBulkReply()
+	}
+	return integerReply(int64(rank))
+}
+
+// parseZRange parses "min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]"
+// and, unless store is set, [WITHSCORES].
+func parseZRange(args []string, store bool) (database.ZRangeSpec, bool, *protocol.RESPValue) {
+	spec := database.ZRangeSpec{Count: -1}
+	var withScores, limit bool
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "BYSCORE":
+			spec.By = database.ZRangeByScore
+		case "BYLEX":
+			spec.By = database.ZRangeByLex
+		case "REV":
+			spec.Rev = true
+		case "WITHSCORES":
+			if store {
+				return spec, false, errorReply(errSyntax)
+			}
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return spec, false, errorReply(errSyntax)
+			}
+			offset, ok1 := parseInt(args[i+1])
+			count, ok2 := parseInt(args[i+2])
+			if !ok1 || !ok2 {
+				return spec, false, errorReply(errNotInteger)
+			}
+			spec.Offset, spec.Count = int(offset), int(count)
+			limit = true
+			i += 2
+		default:
+			return spec, false, errorReply(errSyntax)
+		}
+	}
+
+	if limit && spec.By == database.ZRangeByRank {
+		return spec, false, errorReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if withScores && spec.By == database.ZRangeByLex {
+		return spec, false, errorReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	if spec.Offset < 0 {
+		spec.Count = 0
+	}
+
+	// With REV, score and lex ranges are given from max to min
+	min, max := args[0], args[1]
+	if spec.Rev && spec.By != database.ZRangeByRank {
+		min, max = max, min
+	}
+
+	switch spec.By {
+	case database.ZRangeByRank:
+		start, ok1 := parseInt(min)
+		stop, ok2 := parseInt(max)
+		if !ok1 || !ok2 {
+			return spec, false, errorReply(errNotInteger)
+		}
+		spec.Start, spec.Stop = int(start), int(stop)
+	case database.ZRangeByScore:
+		r, ok := parseScoreRange(min, max)
+		if !ok {
+			return spec, false, errorReply(errScoreRange)
+		}
+		spec.Score = r
+	case database.ZRangeByLex:
+		r, ok := parseLexRange(min, max)
+		if !ok {
+			return spec, false, errorReply(errLexRange)
+		}
+		spec.Lex = r
+	}
+	return spec, withScores, nil
+}
+
+// ZRANGE key min max [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func (s *Server) handleZRange(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 3 {
+		return wrongArgsReply("zrange")
+	}
+
+	spec, withScores, errReply := parseZRange(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+
+	members, err := db.ZRange(args[0], spec)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return zmembersReply(members, withScores)
+}
+
+// ZRANGESTORE dst src min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+func (s *Server) handleZRangeStore(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 4 {
+		return wrongArgsReply("zrangestore")
+	}
+
+	spec, _, errReply := parseZRange(args[2:], true)
+	if errReply != nil {
+		return errReply
+	}
+
+	n, err := db.ZRangeStore(args[0], args[1], spec)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+// ZREMRANGEBYRANK/ZREMRANGEBYSCORE/ZREMRANGEBYLEX key min max
+func (s *Server) handleZRemRange(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) != 3 {
+		return wrongArgsReply(command)
+	}
+
+	spec := database.ZRangeSpec{Count: -1}
+	switch command {
+	case "ZREMRANGEBYRANK":
+		start, ok1 := parseInt(args[1])
+		stop, ok2 := parseInt(args[2])
+		if !ok1 || !ok2 {
+			return errorReply(errNotInteger)
+		}
+		spec.Start, spec.Stop = int(start), int(stop)
+	case "ZREMRANGEBYSCORE":
+		r, ok := parseScoreRange(args[1], args[2])
+		if !ok {
+			return errorReply(errScoreRange)
+		}
+		spec.By, spec.Score = database.ZRangeByScore, r
+	case "ZREMRANGEBYLEX":
+		r, ok := parseLexRange(args[1], args[2])
+		if !ok {
+			return errorReply(errLexRange)
+		}
+		spec.By, spec.Lex = database.ZRangeByLex, r
+	}
+
+	n, err := db.ZRemRange(args[0], spec)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+// ZPOPMIN/ZPOPMAX key [count]
+func (s *Server) handleZPop(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) < 1 || len(args) > 2 {
+		return wrongArgsReply(command)
+	}
+
+	count := int64(1)
+	if len(args) == 2 {
+		var ok bool
+		if count, ok = parseInt(args[1]); !ok || count < 0 {
+			return errorReply(errNotPositive)
+		}
+	}
+
+	members, err := db.ZPop(args[0], int(count), command == "ZPOPMAX")
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return zmembersReply(members, true)
+}
+
+// ZUNIONSTORE/ZINTERSTORE destination numkeys key [key ...]
+// [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func (s *Server) handleZStore(db *database.Database, command string, args []string) *protocol.RESPValue {
+	if len(args) < 3 {
+		return wrongArgsReply(command)
+	}
+
+	numKeys, ok := parseInt(args[1])
+	if !ok {
+		return errorReply(errNotInteger)
+	}
+	if numKeys < 1 {
+		return errorReply("ERR at least 1 input key is needed for '" + strings.ToLower(command) + "' command")
+	}
+	if numKeys > int64(len(args)-2) {
+		return errorReply(errSyntax)
+	}
+	keys := args[2 : 2+numKeys]
+
+	var weights []float64
+	agg := database.AggregateSum
+	opts := args[2+numKeys:]
+	for len(opts) > 0 {
+		switch strings.ToUpper(opts[0]) {
+		case "WEIGHTS":
+			if len(opts) < 1+len(keys) {
+				return errorReply(errSyntax)
+			}
+			weights = make([]float64, len(keys))
+			for i := range keys {
+				if weights[i], ok = parseFloat(opts[1+i]); !ok {
+					return errorReply("ERR weight value is not a float")
+				}
+			}
+			opts = opts[1+len(keys):]
+		case "AGGREGATE":
+			if len(opts) < 2 {
+				return errorReply(errSyntax)
+			}
+			switch strings.ToUpper(opts[1]) {
+			case "SUM":
+				agg = database.AggregateSum
+			case "MIN":
+				agg = database.AggregateMin
+			case "MAX":
+				agg = database.AggregateMax
+			default:
+				return errorReply(errSyntax)
+			}
+			opts = opts[2:]
+		default:
+			return errorReply(errSyntax)
+		}
+	}
+
+	n, err := db.ZStore(args[0], keys, weights, agg, command == "ZINTERSTORE")
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	return integerReply(int64(n))
+}
+
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func (s *Server) handleZScan(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 2 {
+		return wrongArgsReply("zscan")
+	}
+
+	scan, errReply := parseScanArgs(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+
+	cursor, members, err := db.ZScan(args[0], scan.cursor, scan.count)
+	if err != nil {
+		return errorReply(err.Error())
+	}
+	var elements []string
+	for _, m := range members {
+		if scan.matches(m.Member) {
+			elements = append(elements, m.Member, formatFloat(m.Score))
+		}
+	}
+	return scanReply(cursor, elements)
+}
@@ -0,0 +1,604 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/logger"
+	"redis-clone/internal/persistence"
+)
+
+type Config struct {
+	Port           string
+	Bind           []string
+	Timeout        int // seconds a client may stay idle, 0 for no limit
+	Dir            string
+	DBFilename     string
+	AppendFilename string
+
+	AOFEnabled               bool
+	SaveRules                []persistence.SaveRule
+	AOFSyncPolicy            string
+	NoAppendFsyncOnRewrite   bool
+	AutoAOFRewritePercentage int
+	AutoAOFRewriteMinSize    int64
+
+	Databases int
+	Hz        int // active expire cycles per second
+
+	MaxMemory        int64 // bytes, 0 disables the limit
+	EvictionPolicy   string
+	MaxMemorySamples int
+
+	NotifyKeyspaceEvents int // event classes and channels, see notify.go
+
+	SlowlogLogSlowerThan int64 // microseconds, negative disables the slowlog
+	SlowlogMaxLen        int
+
+	RequirePass string
+	LogLevel    string
+	LogFile     string
+}
+
+// DefaultConfig returns the settings used when no configuration file is
+// given.
+func DefaultConfig() *Config {
+	return &Config{
+		Port:           "6379",
+		Dir:            ".",
+		DBFilename:     "dump.rdb",
+		AppendFilename: "appendonly.aof",
+
+		AOFEnabled: true,
+		SaveRules: []persistence.SaveRule{
+			{Seconds: 900, Changes: 1},
+			{Seconds: 300, Changes: 10},
+			{Seconds: 60, Changes: 10000},
+		},
+		AOFSyncPolicy:            persistence.FsyncEverySec,
+		AutoAOFRewritePercentage: 100,
+		AutoAOFRewriteMinSize:    64 * 1024 * 1024, // 64MB
+
+		Databases: 16,
+		Hz:        database.DefaultHz,
+
+		MaxMemory:        100 * 1024 * 1024, // 100MB
+		EvictionPolicy:   "allkeys-lru",
+		MaxMemorySamples: 5,
+
+		SlowlogLogSlowerThan: 10000,
+		SlowlogMaxLen:        128,
+
+		LogLevel: "notice",
+	}
+}
+
+// Clone returns a copy that can be modified without affecting c.
+func (c *Config) Clone() *Config {
+	clone := *c
+	clone.Bind = append([]string(nil), c.Bind...)
+	clone.SaveRules = append([]persistence.SaveRule(nil), c.SaveRules...)
+	return &clone
+}
+
+var evictionPolicies = map[string]bool{
+	"noeviction":      true,
+	"allkeys-lru":     true,
+	"volatile-lru":    true,
+	"allkeys-lfu":     true,
+	"volatile-lfu":    true,
+	"allkeys-random":  true,
+	"volatile-random": true,
+	"volatile-ttl":    true,
+}
+
+// configParam describes a configuration directive.
+type configParam struct {
+	apply func(c *Config, args []string) error
+	get   func(c *Config) string
+	// mutable parameters may be changed at runtime with CONFIG SET
+	mutable bool
+}
+
+var configParams = map[string]configParam{
+	"port": {
+		apply: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errWrongArgs
+			}
+			port, err := strconv.Atoi(args[0])
+			if err != nil || port < 0 || port > 65535 {
+				return fmt.Errorf("invalid port '%s'", args[0])
+			}
+			c.Port = args[0]
+			return nil
+		},
+		get: func(c *Config) string { return c.Port },
+	},
+	"bind": {
+		apply: func(c *Config, args []string) error {
+			if len(args) == 0 {
+				return errWrongArgs
+			}
+			c.Bind = append([]string(nil), args...)
+			return nil
+		},
+		get: func(c *Config) string { return strings.Join(c.Bind, " ") },
+	},
+	"timeout": {
+		apply: func(c *Config, args []string) error {
+			return setInt(&c.Timeout, args, 0)
+		},
+		get:     func(c *Config) string { return strconv.Itoa(c.Timeout) },
+		mutable: true,
+	},
+	"dir": {
+		apply: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errWrongArgs
+			}
+			info, err := os.Stat(args[0])
+			if err != nil {
+				return fmt.Errorf("can't use dir '%s': %v", args[0], err)
+			}
+			if !info.IsDir() {
+				return fmt.Errorf("dir '%s' is not a directory", args[0])
+			}
+			c.Dir = args[0]
+			return nil
+		},
+		get: func(c *Config) string {
+			if abs, err := filepath.Abs(c.Dir); err == nil {
+				return abs
+			}
+			return c.Dir
+		},
+	},
+	"dbfilename": {
+		apply: func(c *Config, args []string) error {
+			return setFilename(&c.DBFilename, args)
+		},
+		get: func(c *Config) string { return c.DBFilename },
+	},
+	"appendfilename": {
+		apply: func(c *Config, args []string) error {
+			return setFilename(&c.AppendFilename, args)
+		},
+		get: func(c *Config) string { return c.AppendFilename },
+	},
+	"databases": {
+		apply: func(c *Config, args []string) error {
+			return setInt(&c.Databases, args, 1)
+		},
+		get: func(c *Config) string { return strconv.Itoa(c.Databases) },
+	},
+	"hz": {
+		apply: func(c *Config, args []string) error {
+			if err := setInt(&c.Hz, args, 0); err != nil {
+				return err
+			}
+			c.Hz = min(max(c.Hz, database.MinHz), database.MaxHz)
+			return nil
+		},
+		get:     func(c *Config) string { return strconv.Itoa(c.Hz) },
+		mutable: true,
+	},
+	"maxmemory": {
+		apply: func(c *Config, args []string) error {
+			return setMemory(&c.MaxMemory, args)
+		},
+		get:     func(c *Config) string { return strconv.FormatInt(c.MaxMemory, 10) },
+		mutable: true,
+	},
+	"maxmemory-policy": {
+		apply: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errWrongArgs
+			}
+			policy := strings.ToLower(args[0])
+			if !evictionPolicies[policy] {
+				return fmt.Errorf("invalid maxmemory-policy '%s'", args[0])
+			}
+			c.EvictionPolicy = policy
+			return nil
+		},
+		get:     func(c *Config) string { return c.EvictionPolicy },
+		mutable: true,
+	},
+	"maxmemory-samples": {
+		apply: func(c *Config, args []string) error {
+			return setInt(&c.MaxMemorySamples, args, 1)
+		},
+		get:     func(c *Config) string { return strconv.Itoa(c.MaxMemorySamples) },
+		mutable: true,
+	},
+	"save": {
+		apply: func(c *Config, args []string) error {
+			rules, err := parseSaveRules(args)
+			if err != nil {
+				return err
+			}
+			c.SaveRules = append(c.SaveRules, rules...)
+			return nil
+		},
+		get: func(c *Config) string {
+			parts := make([]string, 0, len(c.SaveRules)*2)
+			for _, rule := range c.SaveRules {
+				parts = append(parts, strconv.Itoa(rule.Seconds), strconv.FormatInt(rule.Changes, 10))
+			}
+			return strings.Join(parts, " ")
+		},
+		mutable: true,
+	},
+	"appendonly": {
+		apply: func(c *Config, args []string) error {
+			return setYesNo(&c.AOFEnabled, args)
+		},
+		get: func(c *Config) string { return yesNo(c.AOFEnabled) },
+	},
+	"appendfsync": {
+		apply: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errWrongArgs
+			}
+			policy := strings.ToLower(args[0])
+			switch policy {
+			case persistence.FsyncAlways, persistence.FsyncEverySec, persistence.FsyncNo:
+			default:
+				return fmt.Errorf("invalid appendfsync '%s'", args[0])
+			}
+			c.AOFSyncPolicy = policy
+			return nil
+		},
+		get:     func(c *Config) string { return c.AOFSyncPolicy },
+		mutable: true,
+	},
+	"no-appendfsync-on-rewrite": {
+		apply: func(c *Config, args []string) error {
+			return setYesNo(&c.NoAppendFsyncOnRewrite, args)
+		},
+		get:     func(c *Config) string { return yesNo(c.NoAppendFsyncOnRewrite) },
+		mutable: true,
+	},
+	"auto-aof-rewrite-percentage": {
+		apply: func(c *Config, args []string) error {
+			return setInt(&c.AutoAOFRewritePercentage, args, 0)
+		},
+		get:     func(c *Config) string { return strconv.Itoa(c.AutoAOFRewritePercentage) },
+		mutable: true,
+	},
+	"auto-aof-rewrite-min-size": {
+		apply: func(c *Config, args []string) error {
+			return setMemory(&c.AutoAOFRewriteMinSize, args)
+		},
+		get:     func(c *Config) string { return strconv.FormatInt(c.AutoAOFRewriteMinSize, 10) },
+		mutable: true,
+	},
+	"notify-keyspace-events": {
+		apply: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errWrongArgs
+			}
+			flags, err := parseNotifyFlags(args[0])
+			if err != nil {
+				return err
+			}
+			c.NotifyKeyspaceEvents = flags
+			return nil
+		},
+		get:     func(c *Config) string { return formatNotifyFlags(c.NotifyKeyspaceEvents) },
+		mutable: true,
+	},
+	"slowlog-log-slower-than": {
+		apply: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errWrongArgs
+			}
+			n, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid number '%s'", args[0])
+			}
+			c.SlowlogLogSlowerThan = n
+			return nil
+		},
+		get:     func(c *Config) string { return strconv.FormatInt(c.SlowlogLogSlowerThan, 10) },
+		mutable: true,
+	},
+	"slowlog-max-len": {
+		apply: func(c *Config, args []string) error {
+			return setInt(&c.SlowlogMaxLen, args, 0)
+		},
+		get:     func(c *Config) string { return strconv.Itoa(c.SlowlogMaxLen) },
+		mutable: true,
+	},
+	"requirepass": {
+		apply: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errWrongArgs
+			}
+			c.RequirePass = args[0]
+			return nil
+		},
+		get:     func(c *Config) string { return c.RequirePass },
+		mutable: true,
+	},
+	"loglevel": {
+		apply: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errWrongArgs
+			}
+			level := strings.ToLower(args[0])
+			if _, err := logger.ParseLevel(level); err != nil {
+				return err
+			}
+			c.LogLevel = level
+			return nil
+		},
+		get:     func(c *Config) string { return c.LogLevel },
+		mutable: true,
+	},
+	"logfile": {
+		apply: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errWrongArgs
+			}
+			c.LogFile = args[0]
+			return nil
+		},
+		get: func(c *Config) string { return c.LogFile },
+	},
+}
+
+var errWrongArgs = errors.New("wrong number of arguments")
+
+// Apply sets a single directive from its arguments.
+func (c *Config) Apply(name string, args []string) error {
+	param, ok := configParams[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("bad directive '%s'", name)
+	}
+	return param.apply(c, args)
+}
+
+// Get returns the value of a directive as shown by CONFIG GET.
+func (c *Config) Get(name string) (string, bool) {
+	param, ok := configParams[strings.ToLower(name)]
+	if !ok {
+		return "", false
+	}
+	return param.get(c), true
+}
+
+// ParamNames returns every directive name in sorted order.
+func ParamNames() []string {
+	names := make([]string, 0, len(configParams))
+	for name := range configParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadConfig reads a redis.conf style file on top of the defaults. Each
+// line holds a directive followed by its arguments; '#' starts a comment
+// and double quotes group an argument containing spaces.
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// The first save line in a file replaces the default rules
+	sawSave := false
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields, err := splitConfigLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		name := strings.ToLower(fields[0])
+		if name == "save" && !sawSave {
+			config.SaveRules = nil
+			sawSave = true
+		}
+		if err := config.Apply(name, fields[1:]); err != nil {
+			return nil, fmt.Errorf("%s:%d: '%s': %v", path, line, scanner.Text(), err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// splitConfigLine splits a line into words, honouring double quotes and
+// dropping everything after an unquoted '#'.
+func splitConfigLine(line string) ([]string, error) {
+	fields, _, err := splitConfigComment(line)
+	return fields, err
+}
+
+// splitConfigComment works like splitConfigLine and also returns the
+// trailing comment, starting at its '#', if any.
+func splitConfigComment(line string) ([]string, string, error) {
+	var fields []string
+	var current strings.Builder
+	inWord, inQuotes := false, false
+	comment := ""
+
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case inQuotes && ch == '\\' && i+1 < len(line):
+			i++
+			switch line[i] {
+			case 'n':
+				current.WriteByte('\n')
+			case 't':
+				current.WriteByte('\t')
+			default:
+				current.WriteByte(line[i])
+			}
+		case inQuotes && ch == '"':
+			inQuotes = false
+			if i+1 < len(line) && line[i+1] != ' ' && line[i+1] != '\t' {
+				return nil, "", fmt.Errorf("closing quote must be followed by a space")
+			}
+		case inQuotes:
+			current.WriteByte(ch)
+		case ch == '"' && !inWord:
+			inQuotes, inWord = true, true
+		case ch == '#' && !inWord:
+			comment = line[i:]
+			i = len(line)
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			if inWord {
+				fields = append(fields, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteByte(ch)
+			inWord = true
+		}
+	}
+
+	if inQuotes {
+		return nil, "", fmt.Errorf("unbalanced quotes")
+	}
+	if inWord {
+		fields = append(fields, current.String())
+	}
+	return fields, comment, nil
+}
+
+// parseMemory parses a byte count with an optional unit suffix: k, m and g
+// are powers of 1000, kb, mb and gb powers of 1024.
+func parseMemory(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kb", 1024},
+		{"mb", 1024 * 1024},
+		{"gb", 1024 * 1024 * 1024},
+		{"k", 1000},
+		{"m", 1000 * 1000},
+		{"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size '%s'", value)
+	}
+	return n * multiplier, nil
+}
+
+// parseSaveRules parses "seconds changes" pairs. A single empty argument
+// clears the rules, which disables snapshots.
+func parseSaveRules(args []string) ([]persistence.SaveRule, error) {
+	if len(args) == 1 && args[0] == "" {
+		return nil, nil
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, errWrongArgs
+	}
+
+	rules := make([]persistence.SaveRule, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		seconds, err := strconv.Atoi(args[i])
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid save seconds '%s'", args[i])
+		}
+		changes, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || changes <= 0 {
+			return nil, fmt.Errorf("invalid save changes '%s'", args[i+1])
+		}
+		rules = append(rules, persistence.SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, nil
+}
+
+func setYesNo(target *bool, args []string) error {
+	if len(args) != 1 {
+		return errWrongArgs
+	}
+	switch strings.ToLower(args[0]) {
+	case "yes":
+		*target = true
+	case "no":
+		*target = false
+	default:
+		return fmt.Errorf("argument must be 'yes' or 'no'")
+	}
+	return nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func setInt(target *int, args []string, min int) error {
+	if len(args) != 1 {
+		return errWrongArgs
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < min {
+		return fmt.Errorf("invalid number '%s'", args[0])
+	}
+	*target = n
+	return nil
+}
+
+func setMemory(target *int64, args []string) error {
+	if len(args) != 1 {
+		return errWrongArgs
+	}
+	bytes, err := parseMemory(args[0])
+	if err != nil {
+		return err
+	}
+	*target = bytes
+	return nil
+}
+
+func setFilename(target *string, args []string) error {
+	if len(args) != 1 {
+		return errWrongArgs
+	}
+	if args[0] == "" || strings.ContainsAny(args[0], "/\\") {
+		return fmt.Errorf("invalid file name '%s'", args[0])
+	}
+	*target = args[0]
+	return nil
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"redis-clone/internal/persistence"
+)
+
+// writeConfig writes a configuration file in a temporary directory and
+// returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `# Test configuration
+port 7000
+bind 127.0.0.1 -::1   # the second address is optional
+MaxMemory 2mb
+maxmemory-policy volatile-ttl
+appendonly no
+appendfsync always
+requirepass "secret with \"quotes\" and spaces"
+
+save 60 100
+save 3600 1
+`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := DefaultConfig()
+	want.Port = "7000"
+	want.Bind = []string{"127.0.0.1", "-::1"}
+	want.MaxMemory = 2 * 1024 * 1024
+	want.EvictionPolicy = "volatile-ttl"
+	want.AOFEnabled = false
+	want.AOFSyncPolicy = persistence.FsyncAlways
+	want.RequirePass = `secret with "quotes" and spaces`
+	// The save lines of the file replace the default rules
+	want.SaveRules = []persistence.SaveRule{{Seconds: 60, Changes: 100}, {Seconds: 3600, Changes: 1}}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("loaded %+v\nwant %+v", config, want)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"port 70000", "invalid port"},
+		{"maxmemory 12xb", "12xb"},
+		{"maxmemory-policy sometimes", "sometimes"},
+		{"appendonly maybe", "maybe"},
+		{"save 60", "wrong number of arguments"},
+		{"save 0 1", "invalid save seconds"},
+		{`requirepass "unbalanced`, "unbalanced quotes"},
+		{`requirepass "a"b`, "closing quote"},
+		{"no-such-directive yes", "no-such-directive"},
+	}
+	for _, tt := range tests {
+		path := writeConfig(t, "# first line\n"+tt.line+"\n")
+		_, err := LoadConfig(path)
+		if err == nil {
+			t.Errorf("%q: loaded without error", tt.line)
+			continue
+		}
+		if !strings.Contains(err.Error(), path+":2:") || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error %q, want line 2 and %q", tt.line, err, tt.want)
+		}
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"0", 0},
+		{"100", 100},
+		{"1k", 1000},
+		{"1kb", 1024},
+		{"3M", 3000000},
+		{"3mb", 3 * 1024 * 1024},
+		{"2GB", 2 * 1024 * 1024 * 1024},
+	}
+	for _, tt := range tests {
+		if got, err := parseMemory(tt.value); err != nil || got != tt.want {
+			t.Errorf("parseMemory(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+	for _, value := range []string{"", "mb", "-1", "1tb", "1.5mb"} {
+		if _, err := parseMemory(value); err == nil {
+			t.Errorf("parseMemory(%q) succeeded", value)
+		}
+	}
+}
+
+func TestNewServerOverrides(t *testing.T) {
+	path := writeConfig(t, "port 7000\nmaxmemory 1mb\nsave 900 1\nhz 20\n")
+	overrides := map[string]string{
+		"port": "7001",
+		"save": `""`,
+		"dir":  t.TempDir(),
+		"bind": "127.0.0.1 ::1",
+	}
+	s, err := NewServer(path, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := s.cfg()
+	if config.Port != "7001" || config.SaveRules != nil || !reflect.DeepEqual(config.Bind, []string{"127.0.0.1", "::1"}) {
+		t.Errorf("overrides not applied: port %s, save %v, bind %q", config.Port, config.SaveRules, config.Bind)
+	}
+	if config.MaxMemory != 1024*1024 || config.Hz != 20 {
+		t.Errorf("file settings lost: maxmemory %d, hz %d", config.MaxMemory, config.Hz)
+	}
+
+	if _, err := NewServer(path, map[string]string{"maxmemory": "lots"}); err == nil || !strings.Contains(err.Error(), "--maxmemory") {
+		t.Errorf("invalid override returned %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"redis-clone/internal/logger"
+)
+
+// freeMemoryIfNeeded evicts keys according to maxmemory-policy until the
+// dataset fits in maxmemory. It returns false when the limit is still
+// exceeded, either because the policy is noeviction or because no key is
+// eligible. Evictions are logged to the AOF as DEL so that a restart does not
+// bring the keys back.
+//
+// The databases take turns, each eviction picking its key in the next
+// database having an eligible one.
+func (s *Server) freeMemoryIfNeeded() bool {
+	config := s.cfg()
+	if config.MaxMemory <= 0 {
+		return true
+	}
+
+	for s.usedMemory() > config.MaxMemory {
+		if config.EvictionPolicy == "noeviction" {
+			return false
+		}
+		if !s.evictOne(config.EvictionPolicy, config.MaxMemorySamples) {
+			return false
+		}
+	}
+	return true
+}
+
+// evictOne evicts a key from the next database with an eligible one.
+func (s *Server) evictOne(policy string, samples int) bool {
+	for range s.dbs {
+		i := int(atomic.AddUint32(&s.evictNext, 1)) % len(s.dbs)
+		key, ok := s.dbs[i].Evict(policy, samples)
+		if !ok {
+			continue
+		}
+		atomic.AddInt64(&s.stats.evictedKeys, 1)
+		if err := s.persistence.WriteAOF(i, []string{"DEL", key}); err != nil {
+			logger.Warningf("Error writing AOF: %v", err)
+		}
+		return true
+	}
+	return false
+}
+
+// usedMemory returns the estimated size of every database.
+func (s *Server) usedMemory() int64 {
+	var used int64
+	for _, db := range s.dbs {
+		used += db.UsedMemory()
+	}
+	return used
+}
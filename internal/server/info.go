@@ -0,0 +1,243 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/protocol"
+)
+
+const serverVersion = "7.0.0-clone"
+
+// infoSection renders one "# Title" block of the INFO reply.
+type infoSection struct {
+	name  string
+	write func(s *Server, b *strings.Builder)
+}
+
+var infoSections = []infoSection{
+	{"server", (*Server).infoServer},
+	{"clients", (*Server).infoClients},
+	{"memory", (*Server).infoMemory},
+	{"persistence", (*Server).infoPersistence},
+	{"stats", (*Server).infoStats},
+	{"keyspace", (*Server).infoKeyspace},
+}
+
+func (s *Server) handleInfo(args []string) *protocol.RESPValue {
+	if len(args) > 1 {
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR wrong number of arguments for 'info' command",
+		}
+	}
+
+	selected := "default"
+	if len(args) == 1 {
+		selected = strings.ToLower(args[0])
+	}
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if selected != "default" && selected != "all" && selected != "everything" && selected != section.name {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		section.write(s, &b)
+	}
+
+	return &protocol.RESPValue{
+		Type: protocol.BulkString,
+		Str:  b.String(),
+	}
+}
+
+func infoField(b *strings.Builder, name string, value interface{}) {
+	fmt.Fprintf(b, "%s:%v\r\n", name, value)
+}
+
+func (s *Server) infoServer(b *strings.Builder) {
+	uptime := time.Since(s.startTime)
+	infoField(b, "redis_version", serverVersion)
+	infoField(b, "os", runtime.GOOS+" "+runtime.GOARCH)
+	infoField(b, "go_version", runtime.Version())
+	infoField(b, "process_id", os.Getpid())
+	infoField(b, "uptime_in_seconds", int64(uptime.Seconds()))
+	infoField(b, "uptime_in_days", int64(uptime.Hours()/24))
+	infoField(b, "hz", s.cfg().Hz)
+}
+
+func (s *Server) infoClients(b *strings.Builder) {
+	s.clientsMu.RLock()
+	connected := len(s.clients)
+	s.clientsMu.RUnlock()
+
+	infoField(b, "connected_clients", connected)
+	infoField(b, "blocked_clients", s.blockedClients())
+}
+
+func (s *Server) infoMemory(b *strings.Builder) {
+	config := s.cfg()
+	used := s.usedMemory()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	infoField(b, "used_memory", used)
+	infoField(b, "used_memory_human", humanBytes(used))
+	infoField(b, "used_memory_heap", mem.HeapAlloc)
+	infoField(b, "used_memory_sys", mem.Sys)
+	infoField(b, "maxmemory", config.MaxMemory)
+	infoField(b, "maxmemory_human", humanBytes(config.MaxMemory))
+	infoField(b, "maxmemory_policy", config.EvictionPolicy)
+	pending, _ := database.LazyFreeStats()
+	infoField(b, "lazyfree_pending_objects", pending)
+}
+
+// humanBytes formats a size the way INFO memory does, e.g. "1.50M".
+func humanBytes(n int64) string {
+	const units = "KMGTP"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	size := float64(n) / 1024
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f%c", size, units[i])
+}
+
+func (s *Server) infoPersistence(b *strings.Builder) {
+	rdb := s.persistence.RDBStatus()
+	infoField(b, "rdb_changes_since_last_save", rdb.ChangesSinceSave)
+	bgsave := 0
+	if rdb.BgsaveInProgress {
+		bgsave = 1
+	}
+	infoField(b, "rdb_bgsave_in_progress", bgsave)
+	infoField(b, "rdb_last_save_time", rdb.LastSave.Unix())
+	status := "ok"
+	if rdb.LastError != nil {
+		status = "err"
+	}
+	infoField(b, "rdb_last_bgsave_status", status)
+	infoField(b, "rdb_last_bgsave_time_ms", rdb.LastBgsaveTook.Milliseconds())
+
+	aof := s.persistence.AOFStatus()
+
+	enabled := 0
+	if aof.Enabled {
+		enabled = 1
+	}
+	infoField(b, "aof_enabled", enabled)
+	infoField(b, "aof_fsync_policy", aof.FsyncPolicy)
+	infoField(b, "aof_fsync_lag_ms", aof.Lag.Milliseconds())
+	lastFsync := int64(-1)
+	if !aof.LastFsync.IsZero() {
+		lastFsync = aof.LastFsync.Unix()
+	}
+	infoField(b, "aof_last_fsync_time", lastFsync)
+	status = "ok"
+	if aof.LastError != nil {
+		status = "err"
+	}
+	infoField(b, "aof_last_fsync_status", status)
+	status = "ok"
+	if aof.LastWriteErr != nil {
+		status = "err"
+	}
+	infoField(b, "aof_last_write_status", status)
+	infoField(b, "aof_delayed_fsync", aof.DelayedFsync)
+	rewriting := 0
+	if aof.RewriteInProgress {
+		rewriting = 1
+	}
+	infoField(b, "aof_rewrite_in_progress", rewriting)
+	status = "ok"
+	if aof.LastRewriteError != nil {
+		status = "err"
+	}
+	infoField(b, "aof_last_bgrewrite_status", status)
+	infoField(b, "aof_last_rewrite_time_ms", aof.LastRewriteTook.Milliseconds())
+	infoField(b, "aof_current_size", aof.CurrentSize)
+	infoField(b, "aof_base_size", aof.BaseSize)
+}
+
+// serverStats holds the counters shown in INFO stats. They are updated
+// atomically and cleared by CONFIG RESETSTAT.
+type serverStats struct {
+	totalConnections int64
+	totalCommands    int64
+	evictedKeys      int64
+
+	outputLimitDisconnections int64 // subscribers dropped for a full queue
+}
+
+func (st *serverStats) reset() {
+	atomic.StoreInt64(&st.totalConnections, 0)
+	atomic.StoreInt64(&st.totalCommands, 0)
+	atomic.StoreInt64(&st.evictedKeys, 0)
+	atomic.StoreInt64(&st.outputLimitDisconnections, 0)
+}
+
+func (s *Server) infoStats(b *strings.Builder) {
+	infoField(b, "total_connections_received", atomic.LoadInt64(&s.stats.totalConnections))
+	infoField(b, "total_commands_processed", atomic.LoadInt64(&s.stats.totalCommands))
+	expire := s.expireStats()
+	infoField(b, "expired_keys", expire.ExpiredKeys)
+	infoField(b, "expired_subkeys", expire.ExpiredFields)
+	infoField(b, "expired_stale_perc", fmt.Sprintf("%.2f", expire.StalePerc))
+	infoField(b, "expired_time_cap_reached_count", expire.TimeCapReached)
+	infoField(b, "expire_cycle_cpu_milliseconds", expire.CycleTime.Milliseconds())
+	infoField(b, "expire_cycle_last_duration_us", expire.LastCycle.Microseconds())
+	infoField(b, "evicted_keys", atomic.LoadInt64(&s.stats.evictedKeys))
+	_, freed := database.LazyFreeStats()
+	infoField(b, "lazyfreed_objects", freed)
+	channels, patterns := s.pubsubCounts()
+	infoField(b, "pubsub_channels", channels)
+	infoField(b, "pubsub_patterns", patterns)
+	infoField(b, "client_output_buffer_limit_disconnections", atomic.LoadInt64(&s.stats.outputLimitDisconnections))
+}
+
+// expireStats adds up the expiration counters of every database. The stale
+// percentage is averaged over the databases with volatile keys, weighted by
+// their number.
+func (s *Server) expireStats() database.ExpireStats {
+	var total database.ExpireStats
+	var volatile int
+	for _, db := range s.dbs {
+		stats := db.ExpireStats()
+		total.ExpiredKeys += stats.ExpiredKeys
+		total.ExpiredFields += stats.ExpiredFields
+		total.TimeCapReached += stats.TimeCapReached
+		total.Cycles += stats.Cycles
+		total.CycleTime += stats.CycleTime
+		total.LastCycle = max(total.LastCycle, stats.LastCycle)
+
+		n := db.ExpiresCount()
+		total.StalePerc += stats.StalePerc * float64(n)
+		volatile += n
+	}
+	if volatile > 0 {
+		total.StalePerc /= float64(volatile)
+	}
+	return total
+}
+
+func (s *Server) infoKeyspace(b *strings.Builder) {
+	for i, db := range s.dbs {
+		if keys := db.Size(); keys > 0 {
+			fmt.Fprintf(b, "db%d:keys=%d,expires=%d\r\n", i, keys, db.ExpiresCount())
+		}
+	}
+}
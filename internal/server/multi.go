@@ -0,0 +1,199 @@
+package server
+
+import (
+	"redis-clone/internal/database"
+	"redis-clone/internal/logger"
+	"redis-clone/internal/persistence"
+	"redis-clone/internal/protocol"
+)
+
+// transaction holds the commands queued by a client between MULTI and EXEC.
+type transaction struct {
+	commands [][]string // upper-cased command name followed by its arguments
+	flags    int        // union of the flags of the queued commands
+	aborted  bool       // a command was refused while queueing
+}
+
+// watchedKey is a key watched by a client, with its version at WATCH time.
+type watchedKey struct {
+	db      *database.Database
+	key     string
+	version uint64
+}
+
+const errOOM = "OOM command not allowed when used memory > 'maxmemory'."
+
+// transactionCommand reports whether command controls transactions, and is
+// therefore run right away instead of being queued.
+func transactionCommand(command string) bool {
+	switch command {
+	case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
+		return true
+	}
+	return false
+}
+
+func (s *Server) handleTransaction(client *Client, command string, args []string) *protocol.RESPValue {
+	if err := checkCommand(command, args); err != nil {
+		return err
+	}
+	switch command {
+	case "MULTI":
+		if client.tx != nil {
+			return errorReply("ERR MULTI calls can not be nested")
+		}
+		client.tx = &transaction{}
+		return okReply()
+	case "EXEC":
+		return s.handleExec(client)
+	case "DISCARD":
+		if client.tx == nil {
+			return errorReply("ERR DISCARD without MULTI")
+		}
+		client.tx = nil
+		s.unwatchAll(client)
+		return okReply()
+	case "WATCH":
+		if client.tx != nil {
+			return errorReply("ERR WATCH inside MULTI is not allowed")
+		}
+		s.handleWatch(client, args)
+		return okReply()
+	default:
+		s.unwatchAll(client)
+		return okReply()
+	}
+}
+
+// queueCommand adds a command to the transaction of client. Commands that
+// would fail whatever the dataset, because they do not exist or have a
+// wrong number of arguments, or that are refused for lack of memory, are
+// not queued and make the following EXEC fail.
+func (s *Server) queueCommand(client *Client, command string, args []string) *protocol.RESPValue {
+	tx := client.tx
+	if err := checkCommand(command, args); err != nil {
+		tx.aborted = true
+		return err
+	}
+	if commandTable[command].flags&flagNoMulti != 0 {
+		tx.aborted = true
+		return errorReply("ERR Command not allowed inside a transaction")
+	}
+	if !client.replaying() && commandTable[command].flags&flagDenyOOM != 0 && !s.checkMemory() {
+		tx.aborted = true
+		return errorReply(errOOM)
+	}
+
+	tx.commands = append(tx.commands, append([]string{command}, args...))
+	tx.flags |= commandTable[command].flags
+	return simpleReply("QUEUED")
+}
+
+// checkMemory evicts keys if needed and reports whether memory is below the
+// limit, outside of any command.
+func (s *Server) checkMemory() bool {
+	s.execMu.RLock()
+	defer s.execMu.RUnlock()
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+	return s.freeMemoryIfNeeded()
+}
+
+// handleExec runs the queued commands of client while no other command
+// runs, and logs their writes to the AOF as a single MULTI/EXEC block. The
+// transaction is dropped without running anything when one of the watched
+// keys was modified since WATCH.
+func (s *Server) handleExec(client *Client) *protocol.RESPValue {
+	tx := client.tx
+	if tx == nil {
+		return errorReply("ERR EXEC without MULTI")
+	}
+	client.tx = nil
+	defer s.unwatchAll(client)
+
+	if tx.aborted {
+		return errorReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+	switch {
+	case tx.flags&flagExclusive != 0:
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+	case tx.flags&flagWrite != 0:
+		s.writeMu.RLock()
+		defer s.writeMu.RUnlock()
+	}
+
+	if !client.replaying() && tx.flags&flagWrite != 0 {
+		if err := s.persistence.AOFError(); err != nil {
+			return errorReply("EXECABORT Transaction discarded because of: " + aofErrorReply(err).Str)
+		}
+	}
+	// Evicting keys may touch watched keys, so this comes first
+	if !client.replaying() && tx.flags&flagDenyOOM != 0 && !s.freeMemoryIfNeeded() {
+		return errorReply("EXECABORT Transaction discarded because of: " + errOOM)
+	}
+	for _, w := range client.watched {
+		if w.db.WatchedVersion(w.key) != w.version {
+			return nullArrayReply()
+		}
+	}
+
+	replies := make([]*protocol.RESPValue, len(tx.commands))
+	var entries []persistence.AOFEntry
+	for i, cmd := range tx.commands {
+		command, args := cmd[0], cmd[1:]
+		replies[i] = s.dispatch(client, command, args)
+		if entry := writeEntry(command, args, replies[i]); entry != nil {
+			entries = append(entries, persistence.AOFEntry{DB: client.db, Args: entry})
+		}
+	}
+	var aofErr error
+	if len(entries) > 0 {
+		if aofErr = s.persistence.WriteAOFTransaction(entries); aofErr != nil {
+			logger.Warningf("Error writing AOF: %v", aofErr)
+		}
+	}
+	if tx.flags&flagWrite != 0 {
+		s.serveReady()
+	}
+	if aofErr != nil {
+		return aofErrorReply(aofErr)
+	}
+	return arrayReply(replies...)
+}
+
+// handleWatch records the current version of each key, so that EXEC can
+// tell whether it was modified in the meantime.
+func (s *Server) handleWatch(client *Client, keys []string) {
+	db := s.dbs[client.db]
+	for _, key := range keys {
+		if client.watching(db, key) {
+			continue
+		}
+		client.watched = append(client.watched, watchedKey{
+			db:      db,
+			key:     key,
+			version: db.Watch(key),
+		})
+	}
+}
+
+// unwatchAll forgets every key watched by client.
+func (s *Server) unwatchAll(client *Client) {
+	for _, w := range client.watched {
+		w.db.Unwatch(w.key)
+	}
+	client.watched = nil
+}
+
+func (c *Client) watching(db *database.Database, key string) bool {
+	for _, w := range c.watched {
+		if w.db == db && w.key == key {
+			return true
+		}
+	}
+	return false
+}
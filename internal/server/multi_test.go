@@ -0,0 +1,179 @@
+package server
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"redis-clone/internal/protocol"
+)
+
+func TestExecRunsQueuedCommands(t *testing.T) {
+	s := newTestServer(t, map[string]string{"appendonly": "yes", "save": `""`})
+	t.Cleanup(s.persistence.Close)
+	client := newTestClient(t, s)
+
+	s.do(client, "SET", "text", "not a number")
+	s.do(client, "MULTI")
+	for _, command := range [][]string{
+		{"SET", "a", "1"},
+		{"INCR", "text"},
+		{"INCR", "a"},
+		{"GET", "a"},
+	} {
+		if reply := s.do(client, command...); reply.Str != "QUEUED" {
+			t.Fatalf("%q replied %+v inside MULTI", command, reply)
+		}
+	}
+	if reply := s.do(client, "GET", "a"); reply.Str != "QUEUED" {
+		t.Fatalf("GET replied %+v inside MULTI", reply)
+	}
+
+	// A command failing at run time does not stop the others
+	reply := s.do(client, "EXEC")
+	if len(reply.Array) != 5 {
+		t.Fatalf("EXEC replied %+v", reply)
+	}
+	if reply.Array[1].Type != protocol.Error || reply.Array[2].Num != 2 || reply.Array[3].Str != "2" {
+		t.Errorf("EXEC replied %+v", flatten(reply))
+	}
+
+	// The writes of the transaction are logged as a single block
+	var replayed []string
+	err := s.persistence.LoadAOF(func(args []string) ([]string, error) {
+		replayed = append(replayed, args[0])
+		return args, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"SELECT", "SET", "MULTI", "SET", "INCR", "EXEC"}
+	if !reflect.DeepEqual(replayed, want) {
+		t.Errorf("AOF replays %q, want %q", replayed, want)
+	}
+}
+
+func TestExecAbortsOnQueueError(t *testing.T) {
+	s := newTestServer(t, nil)
+	client := newTestClient(t, s)
+
+	s.do(client, "MULTI")
+	s.do(client, "SET", "a", "1")
+	if reply := s.do(client, "SET", "b"); reply.Type != protocol.Error {
+		t.Fatalf("SET with a missing argument replied %+v", reply)
+	}
+	if reply := s.do(client, "NOSUCHCOMMAND"); reply.Type != protocol.Error {
+		t.Fatalf("unknown command replied %+v", reply)
+	}
+	if reply := s.do(client, "EXEC"); !strings.HasPrefix(reply.Str, "EXECABORT") {
+		t.Fatalf("EXEC replied %+v", reply)
+	}
+	if reply := s.do(client, "EXISTS", "a"); reply.Num != 0 {
+		t.Error("a command of an aborted transaction was applied")
+	}
+	if reply := s.do(client, "EXEC"); reply.Type != protocol.Error {
+		t.Errorf("second EXEC replied %+v", reply)
+	}
+}
+
+func TestWatchAbortsExec(t *testing.T) {
+	s := newTestServer(t, nil)
+	client, other := newTestClient(t, s), newTestClient(t, s)
+
+	s.do(client, "SET", "balance", "10")
+	s.do(client, "WATCH", "balance", "missing")
+	s.do(other, "INCRBY", "balance", "5")
+	s.do(client, "MULTI")
+	s.do(client, "SET", "balance", "0")
+	if reply := s.do(client, "EXEC"); !reply.Null {
+		t.Fatalf("EXEC after a change of a watched key replied %+v", reply)
+	}
+	if reply := s.do(client, "GET", "balance"); reply.Str != "15" {
+		t.Errorf("balance = %q, want 15", reply.Str)
+	}
+
+	// EXEC forgets the watched keys, so the next transaction runs
+	s.do(other, "SET", "balance", "1")
+	s.do(client, "MULTI")
+	s.do(client, "SET", "balance", "0")
+	if reply := s.do(client, "EXEC"); len(reply.Array) != 1 {
+		t.Fatalf("EXEC without watched keys replied %+v", reply)
+	}
+
+	// Creating a watched key counts as a change, UNWATCH forgets it
+	s.do(client, "WATCH", "missing")
+	s.do(other, "SET", "missing", "now set")
+	s.do(client, "MULTI")
+	s.do(client, "DEL", "missing")
+	if reply := s.do(client, "EXEC"); !reply.Null {
+		t.Fatalf("EXEC after the creation of a watched key replied %+v", reply)
+	}
+	s.do(client, "WATCH", "balance")
+	s.do(client, "UNWATCH")
+	s.do(other, "SET", "balance", "2")
+	s.do(client, "MULTI")
+	s.do(client, "GET", "balance")
+	if reply := s.do(client, "EXEC"); len(reply.Array) != 1 || reply.Array[0].Str != "2" {
+		t.Errorf("EXEC after UNWATCH replied %+v", reply)
+	}
+}
+
+func TestExecIsAtomic(t *testing.T) {
+	s := newTestServer(t, nil)
+	const writers, rounds = 8, 1000
+
+	// Each transaction moves one unit from a to b: readers must never see
+	// the total change
+	s.do(newTestClient(t, s), "MSET", "a", strconv.Itoa(writers*rounds), "b", "0")
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		client := newTestClient(t, s)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				s.do(client, "MULTI")
+				s.do(client, "DECR", "a")
+				// Widen the window between the two writes
+				for k := 0; k < 20; k++ {
+					s.do(client, "GET", "a")
+				}
+				s.do(client, "INCR", "b")
+				if reply := s.do(client, "EXEC"); len(reply.Array) != 22 {
+					t.Errorf("EXEC replied %+v", reply)
+					return
+				}
+			}
+		}()
+	}
+	reader := newTestClient(t, s)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			reply := s.do(reader, "MGET", "a", "b")
+			a, _ := strconv.Atoi(reply.Array[0].Str)
+			b, _ := strconv.Atoi(reply.Array[1].Str)
+			if a+b != writers*rounds {
+				t.Errorf("read a = %d and b = %d in the middle of a transaction", a, b)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-done
+
+	reply := s.do(reader, "MGET", "a", "b")
+	if got := []string{reply.Array[0].Str, reply.Array[1].Str}; !reflect.DeepEqual(got, []string{"0", strconv.Itoa(writers * rounds)}) {
+		t.Errorf("a and b = %q after the transactions", got)
+	}
+}
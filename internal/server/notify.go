@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"redis-clone/internal/database"
+)
+
+// Channel kinds of notify-keyspace-events, stored with the event classes
+// of the database package in Config.NotifyKeyspaceEvents.
+const (
+	notifyKeyspace = 1 << (iota + 16) // K: __keyspace@<db>__:<key> gets the event
+	notifyKeyevent                    // E: __keyevent@<db>__:<event> gets the key
+)
+
+// notifyClasses maps the characters of notify-keyspace-events to event
+// classes, in the order CONFIG GET shows them.
+var notifyClasses = []struct {
+	char  byte
+	class database.EventClass
+}{
+	{'g', database.EventGeneric},
+	{'$', database.EventString},
+	{'l', database.EventList},
+	{'s', database.EventSet},
+	{'h', database.EventHash},
+	{'z', database.EventZSet},
+	{'x', database.EventExpired},
+	{'e', database.EventEvicted},
+}
+
+// notifyAll is the set of classes selected by 'A'.
+const notifyAll = int(database.EventGeneric | database.EventString | database.EventList |
+	database.EventSet | database.EventHash | database.EventZSet |
+	database.EventExpired | database.EventEvicted)
+
+// parseNotifyFlags parses a notify-keyspace-events value such as "Ex" or
+// "KA".
+func parseNotifyFlags(s string) (int, error) {
+	flags := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		case 'A':
+			flags |= notifyAll
+		default:
+			found := false
+			for _, nc := range notifyClasses {
+				if nc.char == c {
+					flags |= int(nc.class)
+					found = true
+				}
+			}
+			if !found {
+				return 0, fmt.Errorf("invalid event class character '%c'", c)
+			}
+		}
+	}
+	return flags, nil
+}
+
+// formatNotifyFlags is the inverse of parseNotifyFlags, using 'A' when
+// every class is selected.
+func formatNotifyFlags(flags int) string {
+	var b strings.Builder
+	if flags&notifyAll == notifyAll {
+		b.WriteByte('A')
+	} else {
+		for _, nc := range notifyClasses {
+			if flags&int(nc.class) != 0 {
+				b.WriteByte(nc.char)
+			}
+		}
+	}
+	if flags&notifyKeyspace != 0 {
+		b.WriteByte('K')
+	}
+	if flags&notifyKeyevent != 0 {
+		b.WriteByte('E')
+	}
+	return b.String()
+}
+
+// notifyKeyspaceEvent is the notifier of every database. It publishes the
+// event on the channels selected by notify-keyspace-events.
+func (s *Server) notifyKeyspaceEvent(db int, class database.EventClass, event, key string) {
+	flags := s.cfg().NotifyKeyspaceEvents
+	if flags&int(class) == 0 {
+		return
+	}
+	if flags&notifyKeyspace != 0 {
+		s.publish("__keyspace@"+strconv.Itoa(db)+"__:"+key, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		s.publish("__keyevent@"+strconv.Itoa(db)+"__:"+event, key)
+	}
+}
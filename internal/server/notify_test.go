@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"redis-clone/internal/protocol"
+)
+
+// testConn is a connection to a server, served by handleConnection.
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *protocol.Reader
+}
+
+// dial serves s on a local port and connects to it.
+func dial(t *testing.T, s *Server) *testConn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			s.handleConnection(conn)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &testConn{t: t, conn: conn, reader: protocol.NewReader(conn)}
+}
+
+// do sends a command and returns the next value received.
+func (c *testConn) do(args ...string) *protocol.RESPValue {
+	c.t.Helper()
+	if _, err := c.conn.Write(protocol.Serialize(protocol.NewBulkArray(args))); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.read()
+}
+
+// read returns the next value received.
+func (c *testConn) read() *protocol.RESPValue {
+	c.t.Helper()
+	value, err := c.reader.ReadValue()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return value
+}
+
+func TestExpiredEventOnRead(t *testing.T) {
+	s := newTestServer(t, map[string]string{"notify-keyspace-events": "Ex", "save": `""`})
+	subscriber := dial(t, s)
+	client := dial(t, s)
+
+	subscriber.do("SUBSCRIBE", "__keyevent@0__:expired")
+	if reply := client.do("SET", "k", "v", "PX", "10"); reply.Str != "OK" {
+		t.Fatalf("SET replied %+v", reply)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if reply := client.do("GET", "k"); !reply.Null {
+		t.Fatalf("GET of an expired key replied %+v", reply)
+	}
+	message := subscriber.read()
+	if len(message.Array) != 3 || message.Array[0].Str != "message" ||
+		message.Array[1].Str != "__keyevent@0__:expired" || message.Array[2].Str != "k" {
+		t.Fatalf("received %+v, want the expired event of k", message)
+	}
+
+	// The key was removed: reading it again sends nothing more
+	client.do("GET", "k")
+	client.do("SET", "other", "v", "PX", "10")
+	time.Sleep(50 * time.Millisecond)
+	client.do("EXISTS", "other")
+	if message := subscriber.read(); message.Array[2].Str != "other" {
+		t.Fatalf("received %+v, want the expired event of other", message)
+	}
+}
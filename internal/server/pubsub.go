@@ -0,0 +1,302 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"redis-clone/internal/glob"
+	"redis-clone/internal/logger"
+	"redis-clone/internal/protocol"
+)
+
+// subscriberQueueLen bounds the messages waiting to be written to a
+// subscriber. PUBLISH never waits for a subscriber: one that lets its queue
+// fill up is disconnected, as Redis does with its pubsub output buffer limit.
+const subscriberQueueLen = 1024
+
+// pubsub holds the subscribers of every channel and pattern.
+type pubsub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Client]struct{}
+	patterns map[string]map[*Client]struct{}
+}
+
+func newPubSub() pubsub {
+	return pubsub{
+		channels: make(map[string]map[*Client]struct{}),
+		patterns: make(map[string]map[*Client]struct{}),
+	}
+}
+
+// subscriptions is the subscriber side of a client, created by its first
+// subscription command. From then on everything sent to the client goes
+// through queue, so that replies and messages keep their order, and is
+// written by a goroutine of its own.
+type subscriptions struct {
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	queue    chan *protocol.RESPValue
+	done     chan struct{} // closed when the client disconnects
+	dropped  sync.Once
+}
+
+// subscriptions returns the subscriber side of c, creating it if needed.
+// The caller holds the pubsub lock.
+func (c *Client) subscriptions() *subscriptions {
+	if c.subs == nil {
+		c.subs = &subscriptions{
+			channels: make(map[string]struct{}),
+			patterns: make(map[string]struct{}),
+			queue:    make(chan *protocol.RESPValue, subscriberQueueLen),
+			done:     make(chan struct{}),
+		}
+		go c.writeLoop(c.subs)
+	}
+	return c.subs
+}
+
+// subscribed reports whether c is in subscriber mode, where only the
+// subscription commands and PING are allowed.
+func (c *Client) subscribed() bool {
+	return c.subs != nil && len(c.subs.channels)+len(c.subs.patterns) > 0
+}
+
+// subscriptionCount is the count sent with (un)subscribe confirmations.
+func (c *Client) subscriptionCount() int64 {
+	return int64(len(c.subs.channels) + len(c.subs.patterns))
+}
+
+// reply sends the response to a command of c.
+func (c *Client) reply(resp *protocol.RESPValue) {
+	if c.subs == nil {
+		c.WriteResponse(resp)
+		return
+	}
+	c.subs.queue <- resp
+}
+
+func (c *Client) writeLoop(subs *subscriptions) {
+	for {
+		select {
+		case resp := <-subs.queue:
+			c.WriteResponse(resp)
+		case <-subs.done:
+			return
+		}
+	}
+}
+
+// push queues a message for a subscriber without waiting.
+func (s *Server) push(c *Client, msg *protocol.RESPValue) {
+	select {
+	case c.subs.queue <- msg:
+	default:
+		c.subs.dropped.Do(func() {
+			logger.Warningf("Closing subscriber %s: too many pending messages", c.conn.RemoteAddr())
+			atomic.AddInt64(&s.stats.outputLimitDisconnections, 1)
+			c.conn.Close()
+		})
+	}
+}
+
+// allowedWhenSubscribed reports whether command may run in subscriber mode.
+func allowedWhenSubscribed(command string) bool {
+	switch command {
+	case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "PING":
+		return true
+	}
+	return false
+}
+
+// handleSubscribedPing answers PING in subscriber mode, where a simple
+// reply could be mistaken for a message.
+func (s *Server) handleSubscribedPing(args []string) *protocol.RESPValue {
+	if len(args) > 1 {
+		return wrongArgsReply("ping")
+	}
+	message := ""
+	if len(args) == 1 {
+		message = args[0]
+	}
+	return arrayReply(bulkReply("pong"), bulkReply(message))
+}
+
+// subscriptionTables returns the subscriptions of c and the server table
+// used by command, for channels or for patterns.
+func (s *Server) subscriptionTables(c *Client, command string) (map[string]struct{}, map[string]map[*Client]struct{}) {
+	if command == "PSUBSCRIBE" || command == "PUNSUBSCRIBE" {
+		return c.subs.patterns, s.pubsub.patterns
+	}
+	return c.subs.channels, s.pubsub.channels
+}
+
+// handleSubscribe subscribes client to channels, or patterns for
+// PSUBSCRIBE. The confirmations are queued directly, one per argument, so
+// it returns nil.
+func (s *Server) handleSubscribe(client *Client, command string, args []string) *protocol.RESPValue {
+	if len(args) == 0 {
+		return wrongArgsReply(command)
+	}
+
+	s.pubsub.mu.Lock()
+	defer s.pubsub.mu.Unlock()
+
+	client.subscriptions()
+	mine, table := s.subscriptionTables(client, command)
+	kind := bulkReply(strings.ToLower(command))
+	for _, name := range args {
+		if _, exists := mine[name]; !exists {
+			mine[name] = struct{}{}
+			if table[name] == nil {
+				table[name] = make(map[*Client]struct{})
+			}
+			table[name][client] = struct{}{}
+		}
+		s.push(client, arrayReply(kind, bulkReply(name), integerReply(client.subscriptionCount())))
+	}
+	return nil
+}
+
+// handleUnsubscribe unsubscribes client from channels, or patterns for
+// PUNSUBSCRIBE, or from all of them without arguments. Like
+// handleSubscribe it queues one confirmation per name and returns nil.
+func (s *Server) handleUnsubscribe(client *Client, command string, args []string) *protocol.RESPValue {
+	s.pubsub.mu.Lock()
+	defer s.pubsub.mu.Unlock()
+
+	client.subscriptions()
+	mine, table := s.subscriptionTables(client, command)
+	kind := bulkReply(strings.ToLower(command))
+	if len(args) == 0 {
+		if len(mine) == 0 {
+			s.push(client, arrayReply(kind, nullBulkReply(), integerReply(client.subscriptionCount())))
+			return nil
+		}
+		for name := range mine {
+			args = append(args, name)
+		}
+		sort.Strings(args)
+	}
+	for _, name := range args {
+		unsubscribe(client, mine, table, name)
+		s.push(client, arrayReply(kind, bulkReply(name), integerReply(client.subscriptionCount())))
+	}
+	return nil
+}
+
+func unsubscribe(client *Client, mine map[string]struct{}, table map[string]map[*Client]struct{}, name string) {
+	delete(mine, name)
+	delete(table[name], client)
+	if len(table[name]) == 0 {
+		delete(table, name)
+	}
+}
+
+// unsubscribeAll drops every subscription of a disconnecting client and
+// stops its writer.
+func (s *Server) unsubscribeAll(client *Client) {
+	if client.subs == nil {
+		return
+	}
+
+	s.pubsub.mu.Lock()
+	for name := range client.subs.channels {
+		unsubscribe(client, client.subs.channels, s.pubsub.channels, name)
+	}
+	for name := range client.subs.patterns {
+		unsubscribe(client, client.subs.patterns, s.pubsub.patterns, name)
+	}
+	s.pubsub.mu.Unlock()
+	close(client.subs.done)
+}
+
+func (s *Server) handlePublish(args []string) *protocol.RESPValue {
+	if len(args) != 2 {
+		return wrongArgsReply("publish")
+	}
+	return integerReply(int64(s.publish(args[0], args[1])))
+}
+
+// publish sends message to the subscribers of channel and of the patterns
+// matching it, and returns how many messages were queued. A client
+// subscribed both ways receives the message twice.
+func (s *Server) publish(name, message string) int {
+	channel := bulkReply(name)
+	payload := bulkReply(message)
+
+	s.pubsub.mu.RLock()
+	defer s.pubsub.mu.RUnlock()
+
+	receivers := 0
+	if clients := s.pubsub.channels[name]; len(clients) > 0 {
+		msg := arrayReply(bulkReply("message"), channel, payload)
+		for client := range clients {
+			s.push(client, msg)
+			receivers++
+		}
+	}
+	for pattern, clients := range s.pubsub.patterns {
+		if !glob.Match(pattern, name) {
+			continue
+		}
+		msg := arrayReply(bulkReply("pmessage"), bulkReply(pattern), channel, payload)
+		for client := range clients {
+			s.push(client, msg)
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// handlePubSub implements PUBSUB CHANNELS [pattern], PUBSUB NUMSUB
+// [channel ...] and PUBSUB NUMPAT.
+func (s *Server) handlePubSub(args []string) *protocol.RESPValue {
+	if len(args) == 0 {
+		return wrongArgsReply("pubsub")
+	}
+
+	s.pubsub.mu.RLock()
+	defer s.pubsub.mu.RUnlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "CHANNELS":
+		if len(args) > 2 {
+			return wrongArgsReply("pubsub|channels")
+		}
+		var names []string
+		for name := range s.pubsub.channels {
+			if len(args) == 1 || glob.Match(args[1], name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		items := make([]*protocol.RESPValue, len(names))
+		for i, name := range names {
+			items[i] = bulkReply(name)
+		}
+		return arrayReply(items...)
+	case "NUMSUB":
+		items := make([]*protocol.RESPValue, 0, 2*(len(args)-1))
+		for _, name := range args[1:] {
+			items = append(items, bulkReply(name), integerReply(int64(len(s.pubsub.channels[name]))))
+		}
+		return arrayReply(items...)
+	case "NUMPAT":
+		if len(args) != 1 {
+			return wrongArgsReply("pubsub|numpat")
+		}
+		return integerReply(int64(len(s.pubsub.patterns)))
+	default:
+		return errorReply("ERR unknown subcommand '" + args[0] + "'. Try PUBSUB CHANNELS, PUBSUB NUMSUB, PUBSUB NUMPAT.")
+	}
+}
+
+// pubsubCounts returns the number of channels and patterns with at least
+// one subscriber.
+func (s *Server) pubsubCounts() (channels, patterns int) {
+	s.pubsub.mu.RLock()
+	defer s.pubsub.mu.RUnlock()
+	return len(s.pubsub.channels), len(s.pubsub.patterns)
+}
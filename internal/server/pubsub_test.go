@@ -0,0 +1,110 @@
+package server
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPublishDelivers(t *testing.T) {
+	s := newTestServer(t, nil)
+	subscriber := dial(t, s)
+	publisher := newTestClient(t, s)
+
+	if reply := subscriber.do("SUBSCRIBE", "news"); strings.Join(flatten(reply), " ") != " subscribe news " || reply.Array[2].Num != 1 {
+		t.Fatalf("SUBSCRIBE replied %+v", reply)
+	}
+	if reply := subscriber.do("PSUBSCRIBE", "news.*"); reply.Array[2].Num != 2 {
+		t.Fatalf("PSUBSCRIBE replied %+v", reply)
+	}
+	if reply := subscriber.do("GET", "key"); !strings.Contains(reply.Str, "only (P)SUBSCRIBE") {
+		t.Errorf("GET in subscriber mode replied %+v", reply)
+	}
+
+	for _, tt := range []struct {
+		channel   string
+		receivers int64
+		want      string
+	}{
+		{"news", 1, " message news hello"},
+		{"news.sport", 1, " pmessage news.* news.sport hello"},
+		{"other", 0, ""},
+	} {
+		if reply := s.do(publisher, "PUBLISH", tt.channel, "hello"); reply.Num != tt.receivers {
+			t.Errorf("PUBLISH to %s reached %d clients, want %d", tt.channel, reply.Num, tt.receivers)
+		}
+		if tt.want == "" {
+			continue
+		}
+		if message := strings.Join(flatten(subscriber.read()), " "); message != tt.want {
+			t.Errorf("received %q, want %q", message, tt.want)
+		}
+	}
+
+	if reply := s.do(publisher, "PUBSUB", "NUMSUB", "news", "other"); strings.Join(flatten(reply), " ") != " news  other " ||
+		reply.Array[1].Num != 1 || reply.Array[3].Num != 0 {
+		t.Errorf("PUBSUB NUMSUB replied %+v", reply)
+	}
+}
+
+func TestSlowSubscriberDisconnected(t *testing.T) {
+	s := newTestServer(t, nil)
+	slow := dial(t, s)
+	fast := dial(t, s)
+	publisher := newTestClient(t, s)
+	slow.do("SUBSCRIBE", "events")
+	fast.do("SUBSCRIBE", "events")
+
+	// The fast subscriber reads everything, the slow one nothing: once the
+	// socket buffers and its queue are full it is dropped. The publisher
+	// never gets more than a batch ahead of the fast subscriber.
+	const messages, batch = 3000, 100
+	payload := strings.Repeat("x", 16*1024)
+	var received atomic.Int64
+	go func() {
+		for received.Load() < messages {
+			if _, err := fast.reader.ReadValue(); err != nil {
+				return
+			}
+			received.Add(1)
+		}
+	}()
+
+	var slowest time.Duration
+	for i := 0; i < messages; i++ {
+		start := time.Now()
+		s.do(publisher, "PUBLISH", "events", payload)
+		slowest = max(slowest, time.Since(start))
+		if i%batch == batch-1 {
+			deadline := time.Now().Add(5 * time.Second)
+			for received.Load() < int64(i+1) {
+				if time.Now().After(deadline) {
+					t.Fatalf("the fast subscriber received %d messages, want %d", received.Load(), i+1)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	if slowest > time.Second {
+		t.Errorf("a PUBLISH took %v", slowest)
+	}
+	if n := atomic.LoadInt64(&s.stats.outputLimitDisconnections); n != 1 {
+		t.Fatalf("%d subscribers disconnected, want 1", n)
+	}
+
+	// The connection is closed and its subscription dropped
+	slow.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, err := slow.reader.ReadValue(); err != nil {
+			break
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.do(publisher, "PUBLISH", "events", "last").Num != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the slow subscriber is still subscribed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"redis-clone/internal/protocol"
+)
+
+// Error replies shared by many commands.
+const (
+	errNotInteger  = "ERR value is not an integer or out of range"
+	errSyntax      = "ERR syntax error"
+	errNotPositive = "ERR value is out of range, must be positive"
+)
+
+func errorReply(msg string) *protocol.RESPValue {
+	return &protocol.RESPValue{
+		Type: protocol.Error,
+		Str:  msg,
+	}
+}
+
+func wrongArgsReply(command string) *protocol.RESPValue {
+	return errorReply("ERR wrong number of arguments for '" + strings.ToLower(command) + "' command")
+}
+
+func okReply() *protocol.RESPValue {
+	return &protocol.RESPValue{
+		Type: protocol.SimpleString,
+		Str:  "OK",
+	}
+}
+
+func simpleReply(s string) *protocol.RESPValue {
+	return &protocol.RESPValue{
+		Type: protocol.SimpleString,
+		Str:  s,
+	}
+}
+
+func integerReply(n int64) *protocol.RESPValue {
+	return &protocol.RESPValue{
+		Type: protocol.Integer,
+		Num:  n,
+	}
+}
+
+// boolReply encodes a boolean as the integer 1 or 0.
+func boolReply(b bool) *protocol.RESPValue {
+	if b {
+		return integerReply(1)
+	}
+	return integerReply(0)
+}
+
+func bulkReply(s string) *protocol.RESPValue {
+	return &protocol.RESPValue{
+		Type: protocol.BulkString,
+		Str:  s,
+	}
+}
+
+func nullBulkReply() *protocol.RESPValue {
+	return &protocol.RESPValue{
+		Type: protocol.BulkString,
+		Null: true,
+	}
+}
+
+func nullArrayReply() *protocol.RESPValue {
+	return &protocol.RESPValue{
+		Type: protocol.Array,
+		Null: true,
+	}
+}
+
+func arrayReply(items ...*protocol.RESPValue) *protocol.RESPValue {
+	return &protocol.RESPValue{
+		Type:  protocol.Array,
+		Array: items,
+	}
+}
+
+// parseInt parses an integer argument the way Redis does, rejecting
+// surrounding spaces and a leading '+'.
+func parseInt(arg string) (int64, bool) {
+	if arg == "" || arg[0] == '+' {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	return n, err == nil
+}
+
+// parseFloat parses a float argument. NaN is rejected.
+func parseFloat(arg string) (float64, bool) {
+	f, err := strconv.ParseFloat(arg, 64)
+	return f, err == nil && !math.IsNaN(f)
+}
+
+// formatFloat formats a float the way Redis replies with scores: integers
+// without a decimal point, "inf" and "-inf" for infinities.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-5 || abs >= 1e17) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"redis-clone/internal/database"
+	"redis-clone/internal/glob"
+	"redis-clone/internal/protocol"
+)
+
+// scanArgs holds the options shared by SCAN, SSCAN, HSCAN and ZSCAN.
+type scanArgs struct {
+	cursor uint64
+	match  string // empty when no MATCH option was given
+	count  int
+	typ    database.ValueType // TYPE option of SCAN, empty if not given
+}
+
+// scanTypes are the type names accepted by the TYPE option.
+var scanTypes = map[string]database.ValueType{
+	"string": database.StringType,
+	"hash":   database.HashType,
+	"list":   database.ListType,
+	"set":    database.SetType,
+	"zset":   database.ZSetType,
+}
+
+// parseScanArgs parses "cursor [MATCH pattern] [COUNT count]", followed by
+// [TYPE type] for the keyspace SCAN.
+func parseScanArgs(args []string, keyspace bool) (scanArgs, *protocol.RESPValue) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return scanArgs{}, errorReply("ERR invalid cursor")
+	}
+	scan := scanArgs{cursor: cursor, count: 10}
+
+	opts := args[1:]
+	for len(opts) > 0 {
+		if len(opts) < 2 {
+			return scanArgs{}, errorReply(errSyntax)
+		}
+		switch strings.ToUpper(opts[0]) {
+		case "MATCH":
+			scan.match = opts[1]
+		case "COUNT":
+			n, ok := parseInt(opts[1])
+			if !ok {
+				return scanArgs{}, errorReply(errNotInteger)
+			}
+			if n < 1 {
+				return scanArgs{}, errorReply(errSyntax)
+			}
+			scan.count = int(n)
+		case "TYPE":
+			if !keyspace {
+				return scanArgs{}, errorReply(errSyntax)
+			}
+			typ, ok := scanTypes[strings.ToLower(opts[1])]
+			if !ok {
+				return scanArgs{}, errorReply("ERR unknown type name '" + opts[1] + "'")
+			}
+			scan.typ = typ
+		default:
+			return scanArgs{}, errorReply(errSyntax)
+		}
+		opts = opts[2:]
+	}
+	return scan, nil
+}
+
+func (scan scanArgs) matches(s string) bool {
+	return scan.match == "" || scan.match == "*" || glob.Match(scan.match, s)
+}
+
+// filter keeps the elements matching the MATCH pattern, in place.
+func (scan scanArgs) filter(elements []string) []string {
+	matched := elements[:0]
+	for _, element := range elements {
+		if scan.matches(element) {
+			matched = append(matched, element)
+		}
+	}
+	return matched
+}
+
+// scanReply builds the [cursor, elements] reply.
+func scanReply(cursor uint64, elements []string) *protocol.RESPValue {
+	return arrayReply(bulkReply(strconv.FormatUint(cursor, 10)), protocol.NewBulkArray(elements))
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func (s *Server) handleScan(db *database.Database, args []string) *protocol.RESPValue {
+	if len(args) < 1 {
+		return wrongArgsReply("scan")
+	}
+
+	scan, errReply := parseScanArgs(args, true)
+	if errReply != nil {
+		return errReply
+	}
+
+	cursor, keys := db.Scan(scan.cursor, scan.count, scan.typ)
+	return scanReply(cursor, scan.filter(keys))
+}
@@ -2,95 +2,196 @@ package server
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"redis-clone/internal/database"
+	"redis-clone/internal/logger"
 	"redis-clone/internal/persistence"
 	"redis-clone/internal/protocol"
 )
 
 type Server struct {
-	listener    net.Listener
-	db          *database.Database
-	persistence *persistence.Manager
-	clients     map[string]*Client
-	clientsMu   sync.RWMutex
-	shutdown    chan bool
-	config      *Config
+	listeners    []net.Listener
+	dbs          []*database.Database
+	persistence  *persistence.Manager
+	clients      map[string]*Client
+	clientsMu    sync.RWMutex
+	shutdown     chan bool
+	config       atomic.Pointer[Config]
+	configMu     sync.Mutex // serializes CONFIG SET and CONFIG REWRITE
+	configPath   string
+	startTime    time.Time
+	stats        serverStats
+	evictNext    uint32 // database the next eviction starts from, see evictOne
+	slowlog      slowlog
+	pubsub       pubsub
+	blocking     blocking
+	lastClientID int64 // id of the last client connected, see NewClient
+
+	// loaded is set once the dataset was loaded from disk, see Shutdown
+	loaded atomic.Bool
+
+	// writeMu is held shared by write commands from the moment they touch
+	// the database until they are logged, and exclusively while persistence
+	// takes a snapshot, so a snapshot never sees a write the AOF misses nor
+	// half of a write spanning databases. Commands taking a snapshot have
+	// flagExclusive, see runCommand; other callers use pauseWrites. Writes
+	// also hold it exclusively while clients are blocked, see lockWrite.
+	writeMu sync.RWMutex
+
+	// execMu is held shared by every command and exclusively by EXEC, so
+	// that the commands of a transaction run without any other in between.
+	execMu sync.RWMutex
 }
 
-type Config struct {
-	AOFEnabled     bool
-	RDBEnabled     bool
-	SaveInterval   time.Duration
-	AOFSyncPolicy  string
-	MaxMemory      int64
-	EvictionPolicy string
-}
+// cronInterval is how often periodic server tasks run.
+const cronInterval = 100 * time.Millisecond
+
+// NewServer builds a server from the configuration file at configPath.
+// An empty path means the defaults are used. Overrides, typically coming
+// from command line flags, map directive names to their value and take
+// precedence over the file.
+func NewServer(configPath string, overrides map[string]string) (*Server, error) {
+	config := DefaultConfig()
+	if configPath != "" {
+		loaded, err := LoadConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		config = loaded
+	}
+
+	for name, value := range overrides {
+		args, err := splitConfigLine(value)
+		if err == nil && name == "save" {
+			config.SaveRules = nil
+		}
+		if err == nil {
+			err = config.Apply(name, args)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s '%s': %v", name, value, err)
+		}
+	}
 
-func NewServer(configPath string) *Server {
-	config := &Config{
-		AOFEnabled:     true,
-		RDBEnabled:     true,
-		SaveInterval:   300 * time.Second,
-		AOFSyncPolicy:  "everysec",
-		MaxMemory:      100 * 1024 * 1024, // 100MB
-		EvictionPolicy: "allkeys-lru",
+	level, _ := logger.ParseLevel(config.LogLevel)
+	logger.SetLevel(level)
+	if err := logger.SetFile(config.LogFile); err != nil {
+		return nil, fmt.Errorf("can't open log file '%s': %v", config.LogFile, err)
 	}
 
-	db := database.NewDatabase()
-	persistence := persistence.NewManager(db, config.AOFEnabled, config.RDBEnabled)
+	dbs := make([]*database.Database, config.Databases)
+	for i := range dbs {
+		dbs[i] = database.NewDatabase(i)
+		dbs[i].SetHz(config.Hz)
+	}
+	persistence := persistence.NewManager(dbs, config.AOFEnabled)
+	persistence.SetFiles(config.Dir, config.DBFilename, config.AppendFilename)
+	persistence.SetNoFsyncOnRewrite(config.NoAppendFsyncOnRewrite)
+	if err := persistence.SetFsyncPolicy(config.AOFSyncPolicy); err != nil {
+		return nil, err
+	}
 
-	return &Server{
-		db:          db,
+	s := &Server{
+		dbs:         dbs,
 		persistence: persistence,
 		clients:     make(map[string]*Client),
 		shutdown:    make(chan bool),
-		config:      config,
+		configPath:  configPath,
+		startTime:   time.Now(),
+		pubsub:      newPubSub(),
+		blocking:    newBlocking(),
+	}
+	s.config.Store(config)
+	for _, db := range dbs {
+		db.SetNotifier(s.notifyKeyspaceEvent)
+		db.SetReadyFunc(s.keyReady)
 	}
+	return s, nil
 }
 
-func (s *Server) Start(port string) error {
-	listener, err := net.Listen("tcp", ":"+port)
-	if err != nil {
+// cfg returns the current configuration. CONFIG SET replaces it as a whole,
+// so the returned value must be treated as read-only.
+func (s *Server) cfg() *Config {
+	return s.config.Load()
+}
+
+// Start loads the dataset, then listens on the configured addresses and
+// serves clients until Shutdown is called. It returns an error without
+// listening when the dataset cannot be loaded.
+func (s *Server) Start() error {
+	config := s.cfg()
+
+	// Load existing data before accepting clients
+	if err := s.loadData(); err != nil {
 		return err
 	}
-	s.listener = listener
+	s.loaded.Store(true)
 
-	// Start background processes
-	go s.db.StartExpirationManager()
-	go s.persistence.StartBackgroundSave(s.config.SaveInterval)
+	addresses := config.Bind
+	if len(addresses) == 0 {
+		addresses = []string{""}
+	}
 
-	// Load existing data
-	if err := s.persistence.LoadRDB(); err != nil {
-		fmt.Printf("Warning: Could not load RDB file: %v\n", err)
+	for _, address := range addresses {
+		// A leading '-' marks an address that may be unavailable
+		optional := strings.HasPrefix(address, "-")
+		address = strings.TrimPrefix(address, "-")
+
+		listener, err := net.Listen("tcp", net.JoinHostPort(address, config.Port))
+		if err != nil {
+			if optional {
+				logger.Warningf("Skipping optional bind address %s: %v", address, err)
+				continue
+			}
+			s.closeListeners()
+			return err
+		}
+		s.listeners = append(s.listeners, listener)
+	}
+	if len(s.listeners) == 0 {
+		return fmt.Errorf("no bind address available")
 	}
 
-	if err := s.persistence.LoadAOF(); err != nil {
-		fmt.Printf("Warning: Could not load AOF file: %v\n", err)
+	// Start background processes
+	for _, db := range s.dbs {
+		db.StartExpirationManager()
 	}
+	s.persistence.StartAOFSync()
+	go s.cron()
 
-	fmt.Printf("Redis server listening on port %s\n", port)
+	logger.Noticef("Redis server listening on port %s", config.Port)
+
+	for _, listener := range s.listeners[1:] {
+		go s.acceptLoop(listener)
+	}
+	s.acceptLoop(s.listeners[0])
+	return nil
+}
 
+func (s *Server) acceptLoop(listener net.Listener) {
 	for {
 		select {
 		case <-s.shutdown:
-			return nil
+			return
 		default:
 			conn, err := listener.Accept()
 			if err != nil {
 				// Check if we're shutting down
 				select {
 				case <-s.shutdown:
-					return nil
+					return
 				default:
-					fmt.Printf("Error accepting connection: %v\n", err)
+					logger.Warningf("Error accepting connection: %v", err)
 					continue
 				}
 			}
@@ -99,12 +200,96 @@ func (s *Server) Start(port string) error {
 	}
 }
 
+func (s *Server) closeListeners() {
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+}
+
+// loadData restores the dataset from disk. The AOF holds every write since it
+// was created, so when it exists it is the only source used; the RDB snapshot
+// is a fallback so that commands are never applied twice. A file that cannot
+// be loaded as a whole is an error: serving part of the data, and appending
+// to an AOF that stops loading at the same point, would lose the rest.
+func (s *Server) loadData() error {
+	if s.cfg().AOFEnabled {
+		client := newAOFClient(s)
+		err := s.persistence.LoadAOF(func(args []string) ([]string, error) {
+			return s.replayCommand(client, args)
+		})
+		var repaired *persistence.AOFRepairedError
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &repaired):
+			logger.Warningf("AOF file repaired: %v", err)
+			return nil
+		case !errors.Is(err, os.ErrNotExist):
+			return fmt.Errorf("could not load AOF file: %w", err)
+		}
+	}
+
+	if err := s.persistence.LoadRDB(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not load RDB file: %w", err)
+	}
+	return nil
+}
+
+// replayCommand runs a command read from the AOF through the regular
+// dispatch path, as client so that SELECT applies to the commands after it,
+// and returns it as it would be logged now.
+func (s *Server) replayCommand(client *Client, args []string) ([]string, error) {
+	response := s.executeCommand(client, protocol.NewBulkArray(args))
+	if response.Type == protocol.Error {
+		return nil, errors.New(response.Str)
+	}
+	return writeEntry(strings.ToUpper(args[0]), args[1:], response), nil
+}
+
+// cron runs periodic housekeeping until the server shuts down.
+func (s *Server) cron() {
+	ticker := time.NewTicker(cronInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			config := s.cfg()
+			if s.persistence.SaveRulesDue(config.SaveRules) {
+				if err := s.pauseWrites(s.persistence.BackgroundSave); err != nil {
+					logger.Warningf("Background save failed: %v", err)
+				}
+			}
+			if s.persistence.AOFRewriteNeeded(config.AutoAOFRewritePercentage, config.AutoAOFRewriteMinSize) {
+				logger.Noticef("Starting automatic AOF rewrite")
+				if err := s.pauseWrites(s.persistence.StartRewriteAOF); err != nil {
+					logger.Warningf("Automatic AOF rewrite failed: %v", err)
+				}
+			}
+		case <-s.shutdown:
+			return
+		}
+	}
+}
+
+// pauseWrites runs fn, a persistence operation taking a snapshot, while
+// no write is in flight.
+func (s *Server) pauseWrites(fn func() error) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return fn()
+}
+
 func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
-	fmt.Printf("New client connected: %s\n", conn.RemoteAddr())
+	logger.Verbosef("New client connected: %s", conn.RemoteAddr())
 
 	client := NewClient(conn, s)
 	clientID := conn.RemoteAddr().String()
+	// Connections opened while no password is set stay authenticated if
+	// one is configured later
+	client.authenticated = s.cfg().RequirePass == ""
+	atomic.AddInt64(&s.stats.totalConnections, 1)
 
 	s.clientsMu.Lock()
 	s.clients[clientID] = client
@@ -114,32 +299,42 @@ func (s *Server) handleConnection(conn net.Conn) {
 		s.clientsMu.Lock()
 		delete(s.clients, clientID)
 		s.clientsMu.Unlock()
-		fmt.Printf("Client disconnected: %s\n", conn.RemoteAddr())
+		s.unwatchAll(client)
+		s.unsubscribeAll(client)
+		logger.Verbosef("Client disconnected: %s", conn.RemoteAddr())
 	}()
 
-	reader := bufio.NewReader(conn)
-
 	for {
-		// Set read timeout
-		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		// Close clients idle for longer than the configured timeout
+		if timeout := s.cfg().Timeout; timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
 
 		// Read RESP command
-		cmd, err := s.readRESPArray(reader)
+		cmd, err := s.readRESPArray(client.reader)
 		if err != nil {
 			if err == io.EOF {
 				return
 			}
-			fmt.Printf("Error reading command: %v\n", err)
-			client.WriteError("ERR " + err.Error())
+			var netErr net.Error
+			if errors.As(err, &netErr) {
+				// Idle timeout or broken connection
+				logger.Verbosef("Closing client %s: %v", conn.RemoteAddr(), err)
+				return
+			}
+			logger.Verbosef("Error reading command: %v", err)
+			client.reply(errorReply("ERR " + err.Error()))
 			continue
 		}
 
 		if len(cmd) == 0 {
-			client.WriteError("ERR empty command")
+			client.reply(errorReply("ERR empty command"))
 			continue
 		}
 
-		fmt.Printf("Received command: %v\n", cmd)
+		logger.Debugf("Received command: %v", redactArgs(cmd))
 
 		// Convert string array to RESPValue for executeCommand
 		respArray := make([]*protocol.RESPValue, len(cmd))
@@ -155,8 +350,15 @@ func (s *Server) handleConnection(conn net.Conn) {
 			Array: respArray,
 		}
 
-		response := s.executeCommand(respCmd)
-		client.WriteResponse(response)
+		start := time.Now()
+		response := s.executeCommand(client, respCmd)
+		s.slowlog.record(s.cfg(), cmd, time.Since(start)-client.waited, clientID)
+		client.waited = 0
+		atomic.AddInt64(&s.stats.totalCommands, 1)
+		// Subscription commands queue their replies themselves
+		if response != nil {
+			client.reply(response)
+		}
 	}
 }
 
@@ -254,9 +456,10 @@ func (s *Server) readBulkString(reader *bufio.Reader) (string, error) {
 
 func (s *Server) Shutdown() {
 	close(s.shutdown)
-	if s.listener != nil {
-		s.listener.Close()
+	s.closeListeners()
+	// A dataset that failed to load must not replace the snapshot on disk
+	if s.loaded.Load() {
+		s.pauseWrites(s.persistence.SaveRDB)
 	}
-	s.persistence.SaveRDB()
 	s.persistence.Close()
 }
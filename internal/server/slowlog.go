@@ -0,0 +1,173 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"redis-clone/internal/protocol"
+)
+
+// Limits applied to the arguments stored for each slowlog entry.
+const (
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+	client   string
+}
+
+// slowlog keeps the most recent commands that took longer than
+// slowlog-log-slower-than, newest first.
+type slowlog struct {
+	mu      sync.Mutex
+	entries []slowlogEntry
+	nextID  int64
+}
+
+// redactedArg replaces the passwords in the arguments shown by SLOWLOG GET.
+const redactedArg = "(redacted)"
+
+// redactArgs returns args, or a copy without the passwords given to AUTH
+// and to CONFIG SET requirepass, which must never be shown back.
+func redactArgs(args []string) []string {
+	switch {
+	case len(args) > 1 && strings.EqualFold(args[0], "AUTH"):
+		redacted := []string{args[0]}
+		for range args[1:] {
+			redacted = append(redacted, redactedArg)
+		}
+		return redacted
+	case len(args) > 2 && strings.EqualFold(args[0], "CONFIG") && strings.EqualFold(args[1], "SET"):
+		redacted := append([]string(nil), args...)
+		for i := 2; i+1 < len(redacted); i += 2 {
+			if strings.EqualFold(redacted[i], "requirepass") {
+				redacted[i+1] = redactedArg
+			}
+		}
+		return redacted
+	}
+	return args
+}
+
+func (l *slowlog) record(config *Config, args []string, duration time.Duration, client string) {
+	threshold := config.SlowlogLogSlowerThan
+	if threshold < 0 || duration.Microseconds() < threshold {
+		return
+	}
+
+	stored := redactArgs(args)
+	if len(stored) > slowlogMaxArgs {
+		stored = append(stored[:slowlogMaxArgs-1:slowlogMaxArgs-1], fmt.Sprintf("... (%d more arguments)", len(stored)-slowlogMaxArgs+1))
+	}
+	trimmed := make([]string, len(stored))
+	for i, arg := range stored {
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		trimmed[i] = arg
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := slowlogEntry{
+		id:       l.nextID,
+		time:     time.Now(),
+		duration: duration,
+		args:     trimmed,
+		client:   client,
+	}
+	l.nextID++
+	l.entries = append([]slowlogEntry{entry}, l.entries...)
+	l.trim(config.SlowlogMaxLen)
+}
+
+func (l *slowlog) trim(maxLen int) {
+	if len(l.entries) > maxLen {
+		l.entries = l.entries[:maxLen]
+	}
+}
+
+func (s *Server) handleSlowlog(args []string) *protocol.RESPValue {
+	if len(args) == 0 {
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR wrong number of arguments for 'slowlog' command",
+		}
+	}
+
+	l := &s.slowlog
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		count := 10
+		if len(args) > 2 {
+			return &protocol.RESPValue{
+				Type: protocol.Error,
+				Str:  "ERR wrong number of arguments for 'slowlog|get' command",
+			}
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < -1 {
+				return &protocol.RESPValue{
+					Type: protocol.Error,
+					Str:  "ERR count should be greater than or equal to -1",
+				}
+			}
+			count = n
+		}
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if count == -1 || count > len(l.entries) {
+			count = len(l.entries)
+		}
+
+		result := make([]*protocol.RESPValue, count)
+		for i, entry := range l.entries[:count] {
+			result[i] = &protocol.RESPValue{
+				Type: protocol.Array,
+				Array: []*protocol.RESPValue{
+					{Type: protocol.Integer, Num: entry.id},
+					{Type: protocol.Integer, Num: entry.time.Unix()},
+					{Type: protocol.Integer, Num: entry.duration.Microseconds()},
+					protocol.NewBulkArray(entry.args),
+					{Type: protocol.BulkString, Str: entry.client},
+					{Type: protocol.BulkString, Str: ""},
+				},
+			}
+		}
+		return &protocol.RESPValue{
+			Type:  protocol.Array,
+			Array: result,
+		}
+	case "LEN":
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return &protocol.RESPValue{
+			Type: protocol.Integer,
+			Num:  int64(len(l.entries)),
+		}
+	case "RESET":
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.entries = nil
+		return &protocol.RESPValue{
+			Type: protocol.SimpleString,
+			Str:  "OK",
+		}
+	default:
+		return &protocol.RESPValue{
+			Type: protocol.Error,
+			Str:  "ERR unknown subcommand '" + args[0] + "'. Try SLOWLOG GET, SLOWLOG LEN, SLOWLOG RESET.",
+		}
+	}
+}
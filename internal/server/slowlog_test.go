@@ -0,0 +1,50 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"redis-clone/internal/protocol"
+)
+
+// flatten returns every string found in value, recursively.
+func flatten(value *protocol.RESPValue) []string {
+	strs := []string{value.Str}
+	for _, item := range value.Array {
+		strs = append(strs, flatten(item)...)
+	}
+	return strs
+}
+
+func TestSlowlogRedactsPasswords(t *testing.T) {
+	s := newTestServer(t, nil)
+	client := dial(t, s)
+
+	client.do("CONFIG", "SET", "slowlog-log-slower-than", "0")
+	client.do("CONFIG", "SET", "maxmemory-policy", "noeviction", "requirepass", "s3cret")
+	if reply := client.do("AUTH", "s3cret"); reply.Str != "OK" {
+		t.Fatalf("AUTH replied %+v", reply)
+	}
+	client.do("AUTH", "default", "wrong-s3cret")
+	client.do("SET", "key", "s3cret value")
+
+	var args [][]string
+	for _, entry := range client.do("SLOWLOG", "GET").Array {
+		args = append(args, flatten(entry.Array[3])[1:])
+	}
+	want := [][]string{
+		{"SET", "key", "s3cret value"},
+		{"AUTH", redactedArg, redactedArg},
+		{"AUTH", redactedArg},
+		{"CONFIG", "SET", "maxmemory-policy", "noeviction", "requirepass", redactedArg},
+		{"CONFIG", "SET", "slowlog-log-slower-than", "0"},
+	}
+	if len(args) != len(want) {
+		t.Fatalf("slowlog holds %q, want %q", args, want)
+	}
+	for i := range want {
+		if strings.Join(args[i], " ") != strings.Join(want[i], " ") {
+			t.Errorf("slowlog entry %d is %q, want %q", i, args[i], want[i])
+		}
+	}
+}